@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"os"
 
 	"github.com/fsnotify/fsnotify"
@@ -9,34 +10,66 @@ import (
 	"gorm.io/gorm"
 
 	"smile.expression/destiny/pkg/cache"
+	"smile.expression/destiny/pkg/chat"
 	"smile.expression/destiny/pkg/database"
 	"smile.expression/destiny/pkg/http/controller"
 	"smile.expression/destiny/pkg/http/middleware"
-	"smile.expression/destiny/pkg/http/routes"
 	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/moderation"
+	"smile.expression/destiny/pkg/notification"
+	"smile.expression/destiny/pkg/push"
 	"smile.expression/destiny/pkg/storage"
+	"smile.expression/destiny/pkg/utils"
+	"smile.expression/destiny/pkg/verification"
 )
 
 type App struct {
-	options           *Options
-	r                 *gin.Engine
-	db                *gorm.DB
-	storageClient     *storage.Client
-	cacheClient       *cache.Client
-	authController    *controller.AuthController
-	userController    *controller.UserController
-	storageController *controller.StorageController
-	bannerController  *controller.BannerController
-	goodsController   *controller.GoodsController
+	options                *Options
+	r                      *gin.Engine
+	db                     *gorm.DB
+	storageClient          *storage.Client
+	cacheClient            *cache.Client
+	verificationClient     *verification.Client
+	chatHub                *chat.Hub
+	chatRelay              *chat.Relay
+	chatPresence           *chat.Presence
+	moderationFilter       *moderation.Filter
+	moderator              *moderation.Pipeline
+	notifier               *notification.Notifier
+	pushClient             *push.Client
+	authController         *controller.AuthController
+	userController         *controller.UserController
+	storageController      *controller.StorageController
+	bannerController       *controller.BannerController
+	goodsController        *controller.GoodsController
+	orderController        *controller.OrderController
+	cartController         *controller.CartController
+	chatController         *controller.ChatController
+	adminController        *controller.AdminController
+	reviewController       *controller.ReviewController
+	addressController      *controller.AddressController
+	accountController      *controller.AccountController
+	notificationController *controller.NotificationController
+	deviceController       *controller.DeviceController
 }
 
 type Options struct {
-	DBOptions               *database.Options                   `json:"dbOptions"`
-	StorageOptions          *storage.Options                    `json:"storageOptions"`
-	CacheOptions            *cache.Options                      `json:"cacheOptions"`
-	GoodsControllerOptions  *controller.GoodsControllerOptions  `json:"goodsControllerOptions"`
-	BannerControllerOptions *controller.BannerControllerOptions `json:"bannerControllerOptions"`
-	AuthControllerOptions   *controller.AuthControllerOptions   `json:"authControllerOptions"`
+	DBOptions                     *database.Options                         `json:"dbOptions"`
+	StorageOptions                *storage.Options                          `json:"storageOptions"`
+	CacheOptions                  *cache.Options                            `json:"cacheOptions"`
+	GoodsControllerOptions        *controller.GoodsControllerOptions        `json:"goodsControllerOptions"`
+	BannerControllerOptions       *controller.BannerControllerOptions       `json:"bannerControllerOptions"`
+	AuthControllerOptions         *controller.AuthControllerOptions         `json:"authControllerOptions"`
+	UserControllerOptions         *controller.UserControllerOptions         `json:"userControllerOptions"`
+	ChatControllerOptions         *controller.ChatControllerOptions         `json:"chatControllerOptions"`
+	ReviewControllerOptions       *controller.ReviewControllerOptions       `json:"reviewControllerOptions"`
+	OrderControllerOptions        *controller.OrderControllerOptions        `json:"orderControllerOptions"`
+	StorageControllerOptions      *controller.StorageControllerOptions      `json:"storageControllerOptions"`
+	JWTOptions                    *utils.JWTOptions                         `json:"jwtOptions"`
+	VerificationOptions           *verification.Options                     `json:"verificationOptions"`
+	NotificationControllerOptions *controller.NotificationControllerOptions `json:"notificationControllerOptions"`
+	PushOptions                   *push.Options                             `json:"pushOptions"`
+	ModerationOptions             *moderation.Options                       `json:"moderationOptions"`
 }
 
 func (a *App) Init() {
@@ -51,41 +84,107 @@ func (a *App) Init() {
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		// 在配置文件发生更改时重新加载配置
 		a.initOrUpdateConfig()
+		a.reloadModeration()
 	})
 
 	a.serve()
 }
 
 func (a *App) serve() {
-	a.db = database.NewDB(a.options.DBOptions)
-
+	// 迁移旧图片的上传记录时需要按存储配置解析对象 key
 	a.storageClient = storage.NewClient(a.options.StorageOptions)
+	a.db = database.NewDB(a.options.DBOptions, a.storageClient)
+
 	a.cacheClient = cache.NewClient(a.options.CacheOptions)
+	a.verificationClient = verification.NewClient(a.options.VerificationOptions, a.cacheClient)
 
 	// controller
 	a.r = gin.Default()
+	// 只信任配置的反向代理传来的 X-Forwarded-For，否则客户端可以伪造 IP 绕过按 IP 的限流；未配置时直接使用连接地址
+	if err := a.r.SetTrustedProxies(viper.GetStringSlice("server.trustedProxies")); err != nil {
+		panic(err)
+	}
 	a.r.Use(middleware.CORSMiddleware(), middleware.RecoveryMiddleware())
 	a.r.Use(middleware.GenerateRequestID(), middleware.SetRequestID())
 
-	a.authController = controller.NewAuthController(a.options.AuthControllerOptions, a.cacheClient, a.db)
+	jwt, err := utils.NewJWT(a.options.JWTOptions)
+	if err != nil {
+		panic(err)
+	}
+
+	// 内容审核，规则随配置文件热更新
+	a.moderationFilter, err = moderation.NewFilter(a.options.ModerationOptions)
+	if err != nil {
+		panic(err)
+	}
+	a.moderator = moderation.NewPipeline(a.moderationFilter)
+
+	// 聊天推送经 redis 广播到所有实例，在线状态也保存在 redis；系统通知复用聊天连接推送
+	a.chatPresence = chat.NewPresence(a.cacheClient)
+	a.chatHub = chat.NewHub(a.chatPresence)
+	a.chatRelay = chat.NewRelay(a.chatHub, a.cacheClient)
+	go a.chatRelay.Run(context.Background())
+	a.pushClient = push.NewClient(a.options.PushOptions, a.db)
+	a.notifier = notification.NewNotifier(a.db, a.chatRelay, a.chatPresence, a.pushClient)
+
+	a.authController = controller.NewAuthController(a.options.AuthControllerOptions, a.r, a.cacheClient, a.db, jwt)
+	a.authController.Register()
+
+	// review controller，用户主页和商品详情会用到评分
+	a.reviewController = controller.NewReviewController(a.options.ReviewControllerOptions, a.r, a.db, a.cacheClient, a.storageClient, a.authController, a.notifier)
+	a.reviewController.Register()
 
 	// user controller
-	a.userController = controller.NewUserController(a.r, a.db)
+	a.userController = controller.NewUserController(a.options.UserControllerOptions, a.r, a.db, a.cacheClient, a.storageClient, a.verificationClient, a.reviewController, a.authController)
 	a.userController.Register()
 
+	// address controller
+	a.addressController = controller.NewAddressController(a.r, a.db, a.authController)
+	a.addressController.Register()
+
 	// storage controller
-	a.storageController = controller.NewStorageController(a.r, a.db, a.storageClient, a.authController)
+	a.storageController = controller.NewStorageController(a.options.StorageControllerOptions, a.r, a.db, a.storageClient, a.authController)
 	a.storageController.Register()
+	go a.storageController.RunGC()
+
+	// account controller，注销时通过 storage controller 释放图片
+	a.accountController = controller.NewAccountController(a.r, a.db, a.storageClient, a.authController, a.storageController)
+	a.accountController.Register()
 
 	// banner controller
-	a.bannerController = controller.NewBannerController(a.options.BannerControllerOptions, a.r, a.db, a.cacheClient)
+	a.bannerController = controller.NewBannerController(a.options.BannerControllerOptions, a.r, a.db, a.cacheClient, a.storageClient)
 	a.bannerController.Register()
 
 	// goods controller
-	a.goodsController = controller.NewGoodsController(a.options.GoodsControllerOptions, a.r, a.db, a.cacheClient, a.storageClient, a.authController)
+	a.goodsController = controller.NewGoodsController(a.options.GoodsControllerOptions, a.r, a.db, a.cacheClient, a.storageClient, a.reviewController, a.authController, a.moderator)
 	a.goodsController.Register()
 
-	a.r = routes.CollectRoute(a.r)
+	// order controller
+	a.orderController = controller.NewOrderController(a.options.OrderControllerOptions, a.r, a.db, a.storageClient, a.authController, a.notifier)
+	a.orderController.Register()
+	go a.orderController.RunAutoComplete()
+
+	// cart controller
+	a.cartController = controller.NewCartController(a.r, a.db, a.storageClient, a.authController)
+	a.cartController.Register()
+
+	// chat controller
+	a.chatController = controller.NewChatController(a.options.ChatControllerOptions, a.r, a.db, a.cacheClient, a.storageClient, a.authController, a.chatHub, a.chatRelay, a.chatPresence, a.moderator, a.pushClient)
+	a.chatController.Register()
+
+	// admin controller
+	a.adminController = controller.NewAdminController(a.r, a.db, a.cacheClient, a.storageClient, a.authController, a.notifier)
+	a.adminController.Register()
+
+	// notification controller
+	a.notificationController = controller.NewNotificationController(a.options.NotificationControllerOptions, a.r, a.db, a.notifier, a.authController)
+	a.notificationController.Register()
+	go a.notificationController.RunReminders()
+
+	// device controller，离线推送的设备令牌
+	a.deviceController = controller.NewDeviceController(a.r, a.pushClient, a.authController)
+	a.deviceController.Register()
+
 	panic(a.r.Run(":" + viper.GetString("server.port")))
 }
 
@@ -102,3 +201,26 @@ func (a *App) initOrUpdateConfig() {
 		log.WithError(err).Error("unmarshal config file error")
 	}
 }
+
+// reloadModeration 重新读取审核规则，单独解析一份配置，避免删除的规则残留在旧的切片中；
+// 新规则不合法时继续使用原来的规则
+func (a *App) reloadModeration() {
+	var (
+		log = logger.SmileLog.Logger
+	)
+
+	if a.moderationFilter == nil {
+		return
+	}
+
+	var options moderation.Options
+	if err := viper.UnmarshalKey("moderationOptions", &options); err != nil {
+		log.WithError(err).Error("unmarshal moderation options error")
+		return
+	}
+	if err := a.moderationFilter.Update(&options); err != nil {
+		log.WithError(err).Error("update moderation rules error")
+		return
+	}
+	log.Infof("moderation rules reloaded: %d", len(options.Rules))
+}
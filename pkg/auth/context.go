@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/utils"
+)
+
+const (
+	// userKey gin.Context 中保存当前登录用户的键
+	userKey = "user"
+	// claimsKey gin.Context 中保存当前 access token claims 的键
+	claimsKey = "claims"
+)
+
+// SetUser 由认证中间件调用，把当前登录用户写入上下文
+func SetUser(ctx *gin.Context, user *model.User) {
+	ctx.Set(userKey, user)
+}
+
+// UserFrom 返回认证中间件写入的当前登录用户，未经过认证中间件时返回 false
+func UserFrom(ctx *gin.Context) (*model.User, bool) {
+	value, exists := ctx.Get(userKey)
+	if !exists {
+		return nil, false
+	}
+
+	user, ok := value.(*model.User)
+	if !ok || user == nil {
+		return nil, false
+	}
+	return user, true
+}
+
+// SetClaims 由认证中间件调用，保存当前请求使用的 access token，注销时据此吊销
+func SetClaims(ctx *gin.Context, claims *utils.Claims) {
+	ctx.Set(claimsKey, claims)
+}
+
+// ClaimsFrom 返回当前请求的 access token claims
+func ClaimsFrom(ctx *gin.Context) (*utils.Claims, bool) {
+	value, exists := ctx.Get(claimsKey)
+	if !exists {
+		return nil, false
+	}
+
+	claims, ok := value.(*utils.Claims)
+	if !ok || claims == nil {
+		return nil, false
+	}
+	return claims, true
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"smile.expression/destiny/pkg/database/model"
+)
+
+// Permission 后台操作的权限
+type Permission string
+
+const (
+	PermManageUsers      Permission = "users:manage"
+	PermManageRoles      Permission = "roles:manage"
+	PermManageGoods      Permission = "goods:manage"
+	PermManageCategories Permission = "categories:manage"
+	PermManageBanners    Permission = "banners:manage"
+	PermManageDisputes   Permission = "disputes:manage"
+	PermManageReports    Permission = "reports:manage"
+	PermManageStorage    Permission = "storage:manage"
+)
+
+// rolePermissions 每个角色拥有的权限，普通用户没有任何后台权限
+var rolePermissions = map[string][]Permission{
+	model.RoleModerator: {
+		PermManageUsers,
+		PermManageGoods,
+		PermManageDisputes,
+		PermManageReports,
+	},
+	model.RoleAdmin: {
+		PermManageUsers,
+		PermManageRoles,
+		PermManageGoods,
+		PermManageCategories,
+		PermManageBanners,
+		PermManageDisputes,
+		PermManageReports,
+		PermManageStorage,
+	},
+}
+
+// HasPermission 判断用户的角色是否拥有权限
+func HasPermission(user *model.User, perm Permission) bool {
+	if user == nil {
+		return false
+	}
+	for _, p := range rolePermissions[user.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole 判断角色是否存在
+func ValidRole(role string) bool {
+	switch role {
+	case model.RoleUser, model.RoleModerator, model.RoleAdmin:
+		return true
+	default:
+		return false
+	}
+}
@@ -3,6 +3,9 @@ package cache
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -74,3 +77,289 @@ func (c *Client) Set(ctx context.Context, key string, value []byte, expiration i
 	log.Infof("set cache success: %s", key)
 	return nil
 }
+
+func (c *Client) Delete(ctx context.Context, keys ...string) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if err := c.redisClient.Del(ctx, keys...).Err(); err != nil {
+		log.WithError(err).Errorf("delete cache fail: %v", keys)
+		return err
+	}
+	log.Infof("delete cache success: %v", keys)
+	return nil
+}
+
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	n, err := c.redisClient.Exists(ctx, key).Result()
+	if err != nil {
+		log.WithError(err).Errorf("exists cache fail: %s", key)
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// SAdd 向集合中加入成员并刷新整个集合的过期时间
+func (c *Client) SAdd(ctx context.Context, key string, member string, expiration int) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if expiration < 0 {
+		expiration = c.options.Duration
+	}
+
+	pipe := c.redisClient.TxPipeline()
+	pipe.SAdd(ctx, key, member)
+	pipe.Expire(ctx, key, time.Duration(expiration)*time.Second)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.WithError(err).Errorf("sadd cache fail: %s", key)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) SMembers(ctx context.Context, key string) ([]string, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	members, err := c.redisClient.SMembers(ctx, key).Result()
+	if err != nil {
+		log.WithError(err).Errorf("smembers cache fail: %s", key)
+		return nil, err
+	}
+	return members, nil
+}
+
+func (c *Client) SRem(ctx context.Context, key string, member string) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if err := c.redisClient.SRem(ctx, key, member).Err(); err != nil {
+		log.WithError(err).Errorf("srem cache fail: %s", key)
+		return err
+	}
+	return nil
+}
+
+// GetDel 读取并删除 key，用于只能使用一次的数据
+func (c *Client) GetDel(ctx context.Context, key string) ([]byte, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	data, err := c.redisClient.GetDel(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		log.WithError(err).Infof("getdel cache miss: %s", key)
+		return nil, err
+	}
+
+	if err != nil {
+		log.WithError(err).Errorf("getdel cache fail: %s", key)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Incr 计数加一，第一次创建时设置过期时间
+func (c *Client) Incr(ctx context.Context, key string, expiration int) (int64, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if expiration < 0 {
+		expiration = c.options.Duration
+	}
+
+	n, err := c.redisClient.Incr(ctx, key).Result()
+	if err != nil {
+		log.WithError(err).Errorf("incr cache fail: %s", key)
+		return 0, err
+	}
+	if n == 1 {
+		if err = c.redisClient.Expire(ctx, key, time.Duration(expiration)*time.Second).Err(); err != nil {
+			log.WithError(err).Errorf("expire cache fail: %s", key)
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// TTL 返回 key 剩余的过期时间，key 不存在时返回 0
+func (c *Client) TTL(ctx context.Context, key string) (time.Duration, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	ttl, err := c.redisClient.TTL(ctx, key).Result()
+	if err != nil {
+		log.WithError(err).Errorf("ttl cache fail: %s", key)
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// RecordEvent 在滑动窗口中记录一次事件，返回窗口内包括本次在内的事件数
+func (c *Client) RecordEvent(ctx context.Context, key string, window time.Duration) (int64, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+		now = time.Now()
+	)
+
+	// 同一时刻可能有多个事件，member 加上随机后缀避免互相覆盖
+	member := fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())
+
+	pipe := c.redisClient.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixMilli(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: member})
+	card := pipe.ZCard(ctx, key)
+	pipe.PExpire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.WithError(err).Errorf("record event fail: %s", key)
+		return 0, err
+	}
+	return card.Val(), nil
+}
+
+// CountEvents 返回滑动窗口内的事件数
+func (c *Client) CountEvents(ctx context.Context, key string, window time.Duration) (int64, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+		now = time.Now()
+	)
+
+	count, err := c.redisClient.ZCount(ctx, key, strconv.FormatInt(now.Add(-window).UnixMilli(), 10), "+inf").Result()
+	if err != nil {
+		log.WithError(err).Errorf("count events fail: %s", key)
+		return 0, err
+	}
+	return count, nil
+}
+
+// CountEventsAndGet 用一次 pipeline 统计多个滑动窗口内的事件数并读取多个 key，不存在的 key 对应 nil
+func (c *Client) CountEventsAndGet(ctx context.Context, eventKeys []string, window time.Duration, keys []string) ([]int64, [][]byte, error) {
+	var (
+		log  = logger.SmileLog.WithContext(ctx)
+		from = strconv.FormatInt(time.Now().Add(-window).UnixMilli(), 10)
+	)
+
+	pipe := c.redisClient.Pipeline()
+	counts := make([]*redis.IntCmd, len(eventKeys))
+	for i, key := range eventKeys {
+		counts[i] = pipe.ZCount(ctx, key, from, "+inf")
+	}
+	values := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		values[i] = pipe.Get(ctx, key)
+	}
+	// 不存在的 key 会让 Exec 返回 redis.Nil，逐条判断
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		log.WithError(err).Errorf("count events and get fail: %d, %d", len(eventKeys), len(keys))
+		return nil, nil, err
+	}
+
+	resultCounts := make([]int64, len(counts))
+	for i, cmd := range counts {
+		resultCounts[i] = cmd.Val()
+	}
+	resultValues := make([][]byte, len(values))
+	for i, cmd := range values {
+		data, err := cmd.Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		resultValues[i] = data
+	}
+	return resultCounts, resultValues, nil
+}
+
+// TouchMember 把成员的时间戳更新为当前时间，并清理窗口外的成员，可以用 CountEvents 统计窗口内的成员数
+func (c *Client) TouchMember(ctx context.Context, key string, member string, window time.Duration) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+		now = time.Now()
+	)
+
+	pipe := c.redisClient.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixMilli(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: member})
+	pipe.PExpire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.WithError(err).Errorf("touch member fail: %s", key)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) RemoveMember(ctx context.Context, key string, member string) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if err := c.redisClient.ZRem(ctx, key, member).Err(); err != nil {
+		log.WithError(err).Errorf("remove member fail: %s", key)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) Publish(ctx context.Context, channel string, payload []byte) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if err := c.redisClient.Publish(ctx, channel, payload).Err(); err != nil {
+		log.WithError(err).Errorf("publish fail: %s", channel)
+		return err
+	}
+	return nil
+}
+
+// Subscribe 订阅频道并阻塞处理消息，直到 ctx 结束；断线后 go-redis 会自动重新订阅
+func (c *Client) Subscribe(ctx context.Context, channel string, handler func(payload []byte)) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	pubsub := c.redisClient.Subscribe(ctx, channel)
+	defer pubsub.Close()
+
+	// 等待订阅确认，之后发布的消息才能保证收到
+	if _, err := pubsub.Receive(ctx); err != nil {
+		log.WithError(err).Errorf("subscribe fail: %s", channel)
+		return err
+	}
+	log.Infof("subscribe success: %s", channel)
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			handler([]byte(msg.Payload))
+		}
+	}
+}
+
+// IsMiss 判断错误是否为 key 不存在
+func IsMiss(err error) bool {
+	return errors.Is(err, redis.Nil)
+}
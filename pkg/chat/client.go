@@ -0,0 +1,163 @@
+package chat
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = pongWait * 9 / 10
+	maxMessageSize = 4096 // 默认的单帧大小上限
+	sendBuffer     = 64
+)
+
+// Handler 处理客户端发来的帧
+type Handler func(client *Client, frame *Frame)
+
+// Client 一个 WebSocket 连接，读写各用一个 goroutine
+type Client struct {
+	id     string
+	hub    *Hub
+	conn   *websocket.Conn
+	userID uint
+	queue  chan []byte
+
+	maxFrameSize int
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewClient maxFrameSize 是允许的单帧大小（字节），超出的帧回复错误但不断开连接，为 0 时使用默认值
+func NewClient(hub *Hub, conn *websocket.Conn, userID uint, maxFrameSize int) *Client {
+	if maxFrameSize <= 0 {
+		maxFrameSize = maxMessageSize
+	}
+
+	return &Client{
+		id:           uuid.New().String(),
+		hub:          hub,
+		conn:         conn,
+		userID:       userID,
+		queue:        make(chan []byte, sendBuffer),
+		done:         make(chan struct{}),
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+func (c *Client) UserID() uint {
+	return c.userID
+}
+
+// Send 直接回复当前连接
+func (c *Client) Send(frame *Frame) bool {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return false
+	}
+	return c.send(data)
+}
+
+// send 放入发送队列，队列满说明客户端太慢，直接断开让它重连后通过 REST 补齐
+func (c *Client) send(data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.queue <- data:
+		return true
+	default:
+		c.close()
+		return false
+	}
+}
+
+// Run 注册到 hub 并阻塞读取，连接断开后返回
+func (c *Client) Run(handler Handler) {
+	c.hub.register(c)
+	c.hub.touch(c)
+	defer func() {
+		c.hub.unregister(c)
+		c.hub.leave(c)
+		c.close()
+	}()
+
+	go c.writePump()
+
+	// 超过上限几倍的帧视为恶意请求，直接断开
+	c.conn.SetReadLimit(int64(c.maxFrameSize) * 4)
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.hub.touch(c)
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, reader, err := c.conn.NextReader()
+		if err != nil {
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(reader, int64(c.maxFrameSize)+1))
+		if err != nil {
+			return
+		}
+		if len(data) > c.maxFrameSize {
+			// 丢弃剩余内容，连接保持
+			if _, err = io.Copy(io.Discard, reader); err != nil {
+				return
+			}
+			c.Send(&Frame{Type: FrameError, Error: "frame too large"})
+			continue
+		}
+
+		var frame Frame
+		if err = json.Unmarshal(data, &frame); err != nil {
+			c.Send(&Frame{Type: FrameError, Error: "invalid frame"})
+			continue
+		}
+		handler(c, &frame)
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		_ = c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data := <-c.queue:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
+
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"time"
+
+	"smile.expression/destiny/pkg/http/api"
+)
+
+// WebSocket 上收发的帧类型
+const (
+	FrameSend         = "send"         // 客户端发送消息
+	FrameAck          = "ack"          // 客户端确认收到消息
+	FrameMessage      = "message"      // 服务端推送新消息
+	FrameSent         = "sent"         // 服务端确认消息已保存
+	FrameDelivered    = "delivered"    // 服务端通知发送方消息已送达
+	FrameNotification = "notification" // 服务端推送系统通知
+	FrameError        = "error"
+)
+
+type Frame struct {
+	Type           string            `json:"type"`
+	ID             string            `json:"id,omitempty"` // 客户端生成的请求 ID，用于匹配 sent/error
+	To             string            `json:"to,omitempty"`
+	ConversationID uint              `json:"conversationId,omitempty"` // 发到指定会话，例如商品会话
+	MessageType    string            `json:"messageType,omitempty"`    // send 时的消息类型，为空时是文本
+	Content        string            `json:"content,omitempty"`
+	RefID          uint              `json:"refId,omitempty"` // 商品或订单 ID
+	MessageID      string            `json:"messageId,omitempty"`
+	Message        *Message          `json:"message,omitempty"`
+	Notification   *api.Notification `json:"notification,omitempty"`
+	Error          string            `json:"error,omitempty"`
+}
+
+// Message 推送给客户端的消息，卡片已经渲染好
+type Message struct {
+	ID             string         `json:"id"`
+	ConversationID uint           `json:"conversationId"`
+	From           string         `json:"from"`
+	To             string         `json:"to"`
+	Type           string         `json:"type"`
+	Content        string         `json:"content"`
+	Image          string         `json:"image,omitempty"`
+	Goods          *api.GoodsCard `json:"goods,omitempty"`
+	Order          *api.OrderCard `json:"order,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+}
@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"smile.expression/destiny/pkg/logger"
+)
+
+// Hub 保存本实例上所有在线的 WebSocket 连接，同一用户可以有多个连接
+type Hub struct {
+	mu       sync.RWMutex
+	clients  map[uint]map[*Client]struct{}
+	presence *Presence
+}
+
+// NewHub presence 为空时不记录在线状态
+func NewHub(presence *Presence) *Hub {
+	return &Hub{
+		clients:  make(map[uint]map[*Client]struct{}),
+		presence: presence,
+	}
+}
+
+func (h *Hub) register(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conns, ok := h.clients[client.userID]
+	if !ok {
+		conns = make(map[*Client]struct{})
+		h.clients[client.userID] = conns
+	}
+	conns[client] = struct{}{}
+}
+
+func (h *Hub) unregister(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conns, ok := h.clients[client.userID]
+	if !ok {
+		return
+	}
+	delete(conns, client)
+	if len(conns) == 0 {
+		delete(h.clients, client.userID)
+	}
+}
+
+// touch 连接建立和收到心跳时刷新在线状态
+func (h *Hub) touch(client *Client) {
+	if h.presence == nil {
+		return
+	}
+	ctx := context.Background()
+	if err := h.presence.Touch(ctx, client.userID, client.id); err != nil {
+		logger.SmileLog.WithContext(ctx).WithError(err).Errorf("touch presence error: %d", client.userID)
+	}
+}
+
+func (h *Hub) leave(client *Client) {
+	if h.presence == nil {
+		return
+	}
+	ctx := context.Background()
+	if err := h.presence.Leave(ctx, client.userID, client.id); err != nil {
+		logger.SmileLog.WithContext(ctx).WithError(err).Errorf("leave presence error: %d", client.userID)
+	}
+}
+
+// Online 用户在本实例上是否有连接
+func (h *Hub) Online(userID uint) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.clients[userID]) > 0
+}
+
+// SendToUser 推送给用户在本实例上的所有连接，返回成功放入发送队列的连接数
+func (h *Hub) SendToUser(userID uint, frame *Frame) int {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return 0
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sent := 0
+	for client := range h.clients[userID] {
+		if client.send(data) {
+			sent++
+		}
+	}
+	return sent
+}
@@ -0,0 +1,125 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"smile.expression/destiny/pkg/cache"
+)
+
+const (
+	// presenceTTL 连接超过这个时间没有心跳就视为离线，实例异常退出时靠它清理
+	presenceTTL = 2 * pongWait
+	// lastSeenExpiration 最后在线时间保留 30 天（秒）
+	lastSeenExpiration = 30 * 24 * 3600
+)
+
+// Presence 在 redis 中记录用户的在线状态，多个实例共享
+type Presence struct {
+	cacheClient *cache.Client
+}
+
+func NewPresence(cacheClient *cache.Client) *Presence {
+	return &Presence{
+		cacheClient: cacheClient,
+	}
+}
+
+// Touch 连接建立或收到心跳时刷新连接的在线时间
+func (p *Presence) Touch(ctx context.Context, userID uint, connID string) error {
+	if err := p.cacheClient.TouchMember(ctx, presenceKey(userID), connID, presenceTTL); err != nil {
+		return err
+	}
+	return p.setLastSeen(ctx, userID)
+}
+
+// Leave 连接断开
+func (p *Presence) Leave(ctx context.Context, userID uint, connID string) error {
+	if err := p.cacheClient.RemoveMember(ctx, presenceKey(userID), connID); err != nil {
+		return err
+	}
+	return p.setLastSeen(ctx, userID)
+}
+
+// Status 返回用户是否在线（任意实例上有连接）和最后在线时间，从未上线时 lastSeen 为零值
+func (p *Presence) Status(ctx context.Context, userID uint) (bool, time.Time, error) {
+	count, err := p.cacheClient.CountEvents(ctx, presenceKey(userID), presenceTTL)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	if count > 0 {
+		return true, time.Now(), nil
+	}
+
+	data, err := p.cacheClient.Get(ctx, lastSeenKey(userID))
+	if err != nil {
+		if cache.IsMiss(err) {
+			return false, time.Time{}, nil
+		}
+		return false, time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	return false, time.Unix(seconds, 0), nil
+}
+
+// UserStatus 用户的在线状态，从未上线时 LastSeen 为零值
+type UserStatus struct {
+	Online   bool
+	LastSeen time.Time
+}
+
+// StatusMany 批量查询在线状态，所有用户只需要一次 redis 往返
+func (p *Presence) StatusMany(ctx context.Context, userIDs []uint) (map[uint]UserStatus, error) {
+	result := make(map[uint]UserStatus, len(userIDs))
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	presenceKeys := make([]string, len(userIDs))
+	lastSeenKeys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		presenceKeys[i] = presenceKey(userID)
+		lastSeenKeys[i] = lastSeenKey(userID)
+	}
+
+	counts, values, err := p.cacheClient.CountEventsAndGet(ctx, presenceKeys, presenceTTL, lastSeenKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i, userID := range userIDs {
+		if counts[i] > 0 {
+			result[userID] = UserStatus{Online: true, LastSeen: now}
+			continue
+		}
+		if values[i] == nil {
+			result[userID] = UserStatus{}
+			continue
+		}
+		seconds, err := strconv.ParseInt(string(values[i]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		result[userID] = UserStatus{LastSeen: time.Unix(seconds, 0)}
+	}
+	return result, nil
+}
+
+func (p *Presence) setLastSeen(ctx context.Context, userID uint) error {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	return p.cacheClient.Set(ctx, lastSeenKey(userID), []byte(now), lastSeenExpiration)
+}
+
+func presenceKey(userID uint) string {
+	return fmt.Sprintf("chat/presence/%d", userID)
+}
+
+func lastSeenKey(userID uint) string {
+	return fmt.Sprintf("chat/last_seen/%d", userID)
+}
@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"smile.expression/destiny/pkg/cache"
+	"smile.expression/destiny/pkg/logger"
+)
+
+const relayChannel = "chat/relay"
+
+// envelope 在 redis 频道中传递的推送
+type envelope struct {
+	UserID uint   `json:"userId"`
+	Frame  *Frame `json:"frame"`
+}
+
+// Relay 通过 redis pub/sub 把推送广播到所有实例，每个实例只投递给本地连接的用户
+type Relay struct {
+	hub         *Hub
+	cacheClient *cache.Client
+}
+
+func NewRelay(hub *Hub, cacheClient *cache.Client) *Relay {
+	return &Relay{
+		hub:         hub,
+		cacheClient: cacheClient,
+	}
+}
+
+// SendToUser 推送给用户在所有实例上的连接，redis 不可用时退回只推送本实例
+func (r *Relay) SendToUser(ctx context.Context, userID uint, frame *Frame) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	data, err := json.Marshal(&envelope{UserID: userID, Frame: frame})
+	if err == nil {
+		err = r.cacheClient.Publish(ctx, relayChannel, data)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("relay publish error, deliver locally: %d", userID)
+		r.hub.SendToUser(userID, frame)
+	}
+}
+
+// Run 订阅频道并投递到本地连接，阻塞直到 ctx 结束，订阅失败时稍后重试
+func (r *Relay) Run(ctx context.Context) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	for {
+		err := r.cacheClient.Subscribe(ctx, relayChannel, r.deliver)
+		if ctx.Err() != nil {
+			return
+		}
+		log.WithError(err).Error("relay subscribe stopped, retrying")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (r *Relay) deliver(payload []byte) {
+	var msg envelope
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Frame == nil {
+		logger.SmileLog.WithContext(context.Background()).WithError(err).Error("relay invalid payload")
+		return
+	}
+	r.hub.SendToUser(msg.UserID, msg.Frame)
+}
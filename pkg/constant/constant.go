@@ -7,8 +7,12 @@ const (
 
 	Route = "route"
 
-	PutObject    = "PutObject"
-	RemoveObject = "RemoveObject"
+	PutObject       = "PutObject"
+	GetObject       = "GetObject"
+	RemoveObject    = "RemoveObject"
+	ListObjects     = "ListObjects"
+	PresignObject   = "PresignObject"
+	MultipartUpload = "MultipartUpload"
 
 	Bucket = "bucket"
 	Object = "object"
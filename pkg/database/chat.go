@@ -0,0 +1,187 @@
+package database
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"smile.expression/destiny/pkg/database/model"
+)
+
+// 旧版本的聊天表，每条消息在 chats 中保存两行（发送方 type 为 1，接收方为 0），chat_lists 也是双方各一行
+const (
+	legacyChatTable     = "chats"
+	legacyChatListTable = "chat_lists"
+)
+
+type legacyChat struct {
+	ID        uint
+	CreatedAt time.Time
+	Me        string
+	You       string
+	Type      string
+	Content   string
+	MessageID string
+	Delivered bool
+}
+
+type legacyChatList struct {
+	Me  string
+	You string
+}
+
+// migrateChats 把旧聊天表合并到会话表中，完成后旧表重命名为 *_legacy 保留备查。
+// MySQL 的 RENAME TABLE 无法和数据放在同一个事务里，重命名失败后再次迁移时已经迁移过的消息会被跳过
+func migrateChats(db *gorm.DB) error {
+	migrator := db.Migrator()
+	hasChats := migrator.HasTable(legacyChatTable)
+	hasChatLists := migrator.HasTable(legacyChatListTable)
+	if !hasChats && !hasChatLists {
+		return nil
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		conversations := make(map[string]uint)
+
+		if hasChatLists {
+			var lists []legacyChatList
+			if err := tx.Table(legacyChatListTable).Where("deleted_at IS NULL").Find(&lists).Error; err != nil {
+				return err
+			}
+			for _, list := range lists {
+				a, b, ok := parsePair(list.Me, list.You)
+				if !ok {
+					continue
+				}
+				if _, err := ensureConversation(tx, conversations, a, b); err != nil {
+					return err
+				}
+			}
+		}
+
+		if hasChats {
+			// 只取发送方的一行，接收方的一行是重复数据
+			var chats []legacyChat
+			if err := tx.Table(legacyChatTable).Where("deleted_at IS NULL AND type = ?", "1").
+				FindInBatches(&chats, 500, func(_ *gorm.DB, _ int) error {
+					for _, chat := range chats {
+						if err := migrateChat(tx, conversations, &chat); err != nil {
+							return err
+						}
+					}
+					return nil
+				}).Error; err != nil {
+				return err
+			}
+		}
+
+		// 旧数据没有已读状态，迁移过来的消息都视为已读
+		for _, id := range conversations {
+			var conversation model.Conversation
+			if err := tx.First(&conversation, id).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&model.ConversationParticipant{}).Where("conversation_id = ?", id).
+				Update("last_read_message_id", conversation.LastMessageID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if hasChats {
+		if err := migrator.RenameTable(legacyChatTable, legacyChatTable+"_legacy"); err != nil {
+			return err
+		}
+	}
+	if hasChatLists {
+		if err := migrator.RenameTable(legacyChatListTable, legacyChatListTable+"_legacy"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateChat(tx *gorm.DB, conversations map[string]uint, chat *legacyChat) error {
+	senderID, recipientID, ok := parsePair(chat.Me, chat.You)
+	if !ok {
+		return nil
+	}
+	conversationID, err := ensureConversation(tx, conversations, senderID, recipientID)
+	if err != nil {
+		return err
+	}
+
+	// 没有消息 ID 的旧消息按行 ID 生成固定的 UUID，重复迁移时不会产生重复消息
+	messageID := chat.MessageID
+	if messageID == "" {
+		messageID = uuid.NewSHA1(uuid.NameSpaceOID, []byte(legacyChatTable+"/"+strconv.Itoa(int(chat.ID)))).String()
+	}
+
+	// 接收方的一行可能已经确认送达
+	delivered := chat.Delivered
+	if !delivered && chat.MessageID != "" {
+		var count int64
+		if err = tx.Table(legacyChatTable).Where("message_id = ? AND delivered = ?", chat.MessageID, true).Count(&count).Error; err != nil {
+			return err
+		}
+		delivered = count > 0
+	}
+
+	message := model.Message{
+		Model:          gorm.Model{CreatedAt: chat.CreatedAt, UpdatedAt: chat.CreatedAt},
+		UUID:           messageID,
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Type:           model.MessageText,
+		Content:        chat.Content,
+		Delivered:      delivered,
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&message)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return tx.Model(&model.Conversation{}).Where("id = ?", conversationID).Updates(map[string]interface{}{
+		"last_message_id": message.ID,
+		"last_message_at": message.CreatedAt,
+	}).Error
+}
+
+// ensureConversation 查找或创建两人之间的会话和成员
+func ensureConversation(tx *gorm.DB, conversations map[string]uint, a, b uint) (uint, error) {
+	key := model.PairKey(a, b, 0)
+	if id, ok := conversations[key]; ok {
+		return id, nil
+	}
+
+	conversation := model.Conversation{PairKey: key}
+	if err := tx.Where(model.Conversation{PairKey: key}).FirstOrCreate(&conversation).Error; err != nil {
+		return 0, err
+	}
+	for _, userID := range []uint{a, b} {
+		participant := model.ConversationParticipant{ConversationID: conversation.ID, UserID: userID}
+		if err := tx.Where(participant).FirstOrCreate(&participant).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	conversations[key] = conversation.ID
+	return conversation.ID, nil
+}
+
+func parsePair(me, you string) (uint, uint, bool) {
+	a, err := strconv.ParseUint(me, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	b, err := strconv.ParseUint(you, 10, 64)
+	if err != nil || a == b {
+		return 0, 0, false
+	}
+	return uint(a), uint(b), true
+}
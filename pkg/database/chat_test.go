@@ -0,0 +1,193 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+
+	"smile.expression/destiny/pkg/database/model"
+)
+
+func TestParsePair(t *testing.T) {
+	tests := []struct {
+		name   string
+		me     string
+		you    string
+		wantA  uint
+		wantB  uint
+		wantOK bool
+	}{
+		{name: "valid", me: "1", you: "2", wantA: 1, wantB: 2, wantOK: true},
+		{name: "order kept", me: "20", you: "3", wantA: 20, wantB: 3, wantOK: true},
+		{name: "same user", me: "5", you: "5"},
+		{name: "empty me", me: "", you: "2"},
+		{name: "invalid you", me: "1", you: "abc"},
+		{name: "negative", me: "-1", you: "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b, ok := parsePair(tt.me, tt.you)
+			if a != tt.wantA || b != tt.wantB || ok != tt.wantOK {
+				t.Errorf("parsePair(%q, %q) = (%d, %d, %v), want (%d, %d, %v)", tt.me, tt.you, a, b, ok, tt.wantA, tt.wantB, tt.wantOK)
+			}
+		})
+	}
+}
+
+// testLegacyChat 旧版本 chats 表的结构，chat_lists 只用到其中的 Me 和 You
+type testLegacyChat struct {
+	gorm.Model
+	Me        string
+	You       string
+	Type      string
+	Content   string
+	MessageID string
+	Delivered bool
+}
+
+// newTestDB 每个测试使用单独的 sqlite 文件，models 为需要建的表
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestLegacyDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db := newTestDB(t, &model.Conversation{}, &model.ConversationParticipant{}, &model.Message{})
+	if err := db.Table(legacyChatTable).AutoMigrate(&testLegacyChat{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Table(legacyChatListTable).AutoMigrate(&testLegacyChat{}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Add(-time.Hour)
+	deleted := gorm.DeletedAt{Time: now, Valid: true}
+	chats := []testLegacyChat{
+		// 每条消息双方各一行，发送方 type 为 1
+		{Model: gorm.Model{CreatedAt: now}, Me: "1", You: "2", Type: "1", Content: "hi"},
+		{Model: gorm.Model{CreatedAt: now}, Me: "2", You: "1", Type: "0", Content: "hi"},
+		// 接收方的一行已经确认送达
+		{Model: gorm.Model{CreatedAt: now.Add(time.Minute)}, Me: "2", You: "1", Type: "1", Content: "yo", MessageID: "abc"},
+		{Model: gorm.Model{CreatedAt: now.Add(time.Minute)}, Me: "1", You: "2", Type: "0", Content: "yo", MessageID: "abc", Delivered: true},
+		{Model: gorm.Model{CreatedAt: now, DeletedAt: deleted}, Me: "1", You: "3", Type: "1", Content: "deleted"},
+		{Model: gorm.Model{CreatedAt: now}, Me: "4", You: "4", Type: "1", Content: "self"},
+	}
+	lists := []testLegacyChat{
+		{Me: "1", You: "2"},
+		{Me: "2", You: "1"},
+		{Me: "1", You: "3"},
+		{Me: "3", You: "1"},
+		{Model: gorm.Model{DeletedAt: deleted}, Me: "1", You: "5"},
+		{Me: "abc", You: "1"},
+	}
+	if err := db.Table(legacyChatTable).Create(&chats).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Table(legacyChatListTable).Create(&lists).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrateChats(t *testing.T) {
+	db := newTestLegacyDB(t)
+	if err := migrateChats(db); err != nil {
+		t.Fatalf("migrateChats error: %v", err)
+	}
+	assertMigratedChats(t, db)
+
+	migrator := db.Migrator()
+	for _, table := range []string{legacyChatTable, legacyChatListTable} {
+		if migrator.HasTable(table) || !migrator.HasTable(table+"_legacy") {
+			t.Errorf("table %s not renamed", table)
+		}
+	}
+
+	// 数据迁移成功但重命名失败时会再次迁移，不能产生重复数据
+	for _, table := range []string{legacyChatTable, legacyChatListTable} {
+		if err := migrator.RenameTable(table+"_legacy", table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrateChats(db); err != nil {
+		t.Fatalf("second migrateChats error: %v", err)
+	}
+	assertMigratedChats(t, db)
+
+	// 已经迁移过时直接跳过
+	if err := migrateChats(db); err != nil {
+		t.Fatalf("third migrateChats error: %v", err)
+	}
+}
+
+func assertMigratedChats(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	var conversations []model.Conversation
+	db.Order("pair_key").Find(&conversations)
+	if len(conversations) != 2 || conversations[0].PairKey != "1:2" || conversations[1].PairKey != "1:3" {
+		t.Fatalf("conversations = %+v, want 1:2 and 1:3", conversations)
+	}
+
+	var messages []model.Message
+	db.Order("created_at").Find(&messages)
+	if len(messages) != 2 {
+		t.Fatalf("messages = %d, want 2", len(messages))
+	}
+
+	tests := []struct {
+		name          string
+		message       model.Message
+		wantSender    uint
+		wantContent   string
+		wantDelivered bool
+	}{
+		{name: "without message id", message: messages[0], wantSender: 1, wantContent: "hi"},
+		{name: "delivered to recipient", message: messages[1], wantSender: 2, wantContent: "yo", wantDelivered: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.message
+			if m.ConversationID != conversations[0].ID || m.SenderID != tt.wantSender || m.Content != tt.wantContent || m.Delivered != tt.wantDelivered {
+				t.Errorf("message = {conversation %d sender %d %q delivered %v}, want {conversation %d sender %d %q delivered %v}",
+					m.ConversationID, m.SenderID, m.Content, m.Delivered, conversations[0].ID, tt.wantSender, tt.wantContent, tt.wantDelivered)
+			}
+		})
+	}
+	if messages[1].UUID != "abc" {
+		t.Errorf("message uuid = %q, want abc", messages[1].UUID)
+	}
+
+	// 迁移过来的消息都视为已读
+	if conversations[0].LastMessageID != messages[1].ID {
+		t.Errorf("last message = %d, want %d", conversations[0].LastMessageID, messages[1].ID)
+	}
+	var participants []model.ConversationParticipant
+	db.Where("conversation_id = ?", conversations[0].ID).Find(&participants)
+	if len(participants) != 2 {
+		t.Fatalf("participants = %d, want 2", len(participants))
+	}
+	for _, p := range participants {
+		if p.LastReadMessageID != messages[1].ID {
+			t.Errorf("user %d last read = %d, want %d", p.UserID, p.LastReadMessageID, messages[1].ID)
+		}
+	}
+}
@@ -3,15 +3,15 @@ package database
 import (
 	"fmt"
 	"net/url"
+	"strings"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 
 	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/storage"
 )
 
-var DB *gorm.DB
-
 type Options struct {
 	Database string `json:"database"`
 	Username string `json:"username"`
@@ -22,7 +22,7 @@ type Options struct {
 	Loc      string `json:"loc"`
 }
 
-func NewDB(options *Options) *gorm.DB {
+func NewDB(options *Options, storageClient *storage.Client) *gorm.DB {
 	//可以用navicat或datagrip等数据库操作软件，利用下面的信息登录数据库查看数据
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=true&loc=%s",
 		options.Username,
@@ -43,17 +43,89 @@ func NewDB(options *Options) *gorm.DB {
 	_ = db.AutoMigrate(&model.Category{})
 	_ = db.AutoMigrate(&model.Banner{})
 	_ = db.AutoMigrate(&model.Picture{})
-	_ = db.AutoMigrate(&model.Chat{})
-	_ = db.AutoMigrate(&model.ChatList{})
 	_ = db.AutoMigrate(&model.Cart{})
-	_ = db.AutoMigrate(&model.Order{})
+	if err = migrateOrderStatus(db); err != nil {
+		panic("Error to migrate orders.status, err: " + err.Error())
+	}
 	_ = db.AutoMigrate(&model.Image{})
-	_ = db.AutoMigrate(&model.UserAddress{})
+	if err = migrateAddressUserID(db); err != nil {
+		panic("Error to migrate user_addresses.user_id, err: " + err.Error())
+	}
+	if err = migrateAddressDefault(db); err != nil {
+		panic("Error to migrate user_addresses.is_default, err: " + err.Error())
+	}
+	if err = migrateStorageObjects(db, storageClient); err != nil {
+		panic("Error to migrate storage_objects, err: " + err.Error())
+	}
+	_ = db.AutoMigrate(&model.StorageBlob{})
+	_ = db.AutoMigrate(&model.StorageAudit{})
+	_ = db.AutoMigrate(&model.MultipartUpload{})
+	_ = db.AutoMigrate(&model.Dispute{})
+	_ = db.AutoMigrate(&model.AdminAudit{})
+	_ = db.AutoMigrate(&model.Review{})
+	_ = db.AutoMigrate(&model.Conversation{})
+	_ = db.AutoMigrate(&model.ConversationParticipant{})
+	_ = db.AutoMigrate(&model.Message{})
+	_ = db.AutoMigrate(&model.UserBlock{})
+	_ = db.AutoMigrate(&model.ChatReport{})
+	_ = db.AutoMigrate(&model.ModerationFlag{})
+	_ = db.AutoMigrate(&model.Notification{})
+	_ = db.AutoMigrate(&model.DeviceToken{})
+	if err = migrateChats(db); err != nil {
+		panic("Error to migrate chats, err: " + err.Error())
+	}
 
-	DB = db
 	return db
 }
 
-func GetDB() *gorm.DB {
-	return DB
+// migrateOrderStatus 订单状态是后来加的，之前的订单都视为已完成，不能按默认值变成待收货
+func migrateOrderStatus(db *gorm.DB) error {
+	migrator := db.Migrator()
+	legacy := migrator.HasTable(&model.Order{}) && !migrator.HasColumn(&model.Order{}, "Status")
+
+	if err := db.AutoMigrate(&model.Order{}); err != nil {
+		return err
+	}
+	if !legacy {
+		return nil
+	}
+	return db.Model(&model.Order{}).Where("1 = 1").Update("status", model.OrderCompleted).Error
+}
+
+// migrateAddressDefault 默认地址是后来加的，旧数据把每个用户最新的地址设为默认地址
+func migrateAddressDefault(db *gorm.DB) error {
+	migrator := db.Migrator()
+	legacy := migrator.HasTable(&model.UserAddress{}) && !migrator.HasColumn(&model.UserAddress{}, "IsDefault")
+
+	if err := db.AutoMigrate(&model.UserAddress{}); err != nil {
+		return err
+	}
+	if !legacy {
+		return nil
+	}
+
+	// MySQL 不允许 UPDATE 的子查询直接读同一张表，需要包一层派生表
+	latest := db.Model(&model.UserAddress{}).Select("MAX(id) AS id").Group("user_id")
+	return db.Model(&model.UserAddress{}).
+		Where("id IN (?)", db.Table("(?) AS latest", latest).Select("id")).
+		Update("is_default", true).Error
+}
+
+// migrateAddressUserID 旧版本的 user_addresses.user_id 是 varchar，改为和 users.id 一致的整数类型
+func migrateAddressUserID(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&model.UserAddress{}) {
+		return nil
+	}
+
+	columns, err := migrator.ColumnTypes(&model.UserAddress{})
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if column.Name() == "user_id" && strings.Contains(strings.ToLower(column.DatabaseTypeName()), "char") {
+			return migrator.AlterColumn(&model.UserAddress{}, "UserID")
+		}
+	}
+	return nil
 }
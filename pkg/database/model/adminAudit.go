@@ -0,0 +1,12 @@
+package model
+
+import "gorm.io/gorm"
+
+// AdminAudit 后台操作记录
+type AdminAudit struct {
+	gorm.Model
+	AdminID uint   `gorm:"index;not null"`
+	Action  string `gorm:"type:varchar(50);not null"`
+	Target  string `gorm:"type:varchar(255);not null"`
+	Detail  string `gorm:"type:varchar(1024);not null"`
+}
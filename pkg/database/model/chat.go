@@ -1,11 +1,57 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"time"
 
-type Chat struct {
+	"gorm.io/gorm"
+)
+
+// Conversation 两个用户之间的会话，GoodsID 不为 0 时是关于某个商品的会话
+type Conversation struct {
+	gorm.Model
+	PairKey       string `gorm:"type:varchar(64);uniqueIndex;not null"` // 由双方 ID 和商品 ID 生成，保证不重复
+	GoodsID       uint   `gorm:"index;not null;default:0"`
+	LastMessageID uint
+	LastMessageAt *time.Time `gorm:"index"`
+}
+
+// ConversationParticipant 会话成员，LastReadMessageID 是已读游标，之后别人发的消息都算未读
+type ConversationParticipant struct {
+	gorm.Model
+	ConversationID    uint `gorm:"uniqueIndex:idx_conversation_user;not null"`
+	UserID            uint `gorm:"uniqueIndex:idx_conversation_user;index;not null"`
+	LastReadMessageID uint `gorm:"not null;default:0"`
+	Muted             bool `gorm:"not null;default:false"` // 免打扰，不再推送通知
+}
+
+// 消息类型
+const (
+	MessageText  = "text"
+	MessageImage = "image" // Content 保存图片的对象 key
+	MessageGoods = "goods" // RefID 为商品 ID
+	MessageOrder = "order" // RefID 为订单 ID
+)
+
+// Message 聊天消息，每条消息只保存一行，卡片类消息只保存引用，展示数据在读取时查询
+type Message struct {
 	gorm.Model
-	Me      string `gorm:"type:varchar(255);not null"`
-	You     string `gorm:"type:varchar(255);not null"`
-	Type    string `gorm:"type:varchar(255);not null"`
-	Content string `gorm:"type:varchar(255);not null"`
+	UUID           string `gorm:"type:char(36);uniqueIndex;not null"` // 推送和送达确认使用的 ID
+	ConversationID uint   `gorm:"index;not null"`
+	SenderID       uint   `gorm:"index;not null"`
+	Type           string `gorm:"type:varchar(16);not null;default:text"`
+	Content        string `gorm:"type:text;not null"`
+	RefID          uint
+	Delivered      bool `gorm:"not null;default:false"` // 接收方是否已确认收到
+}
+
+// PairKey 两个用户的会话键，与顺序无关，goodsID 为 0 时是普通会话
+func PairKey(a, b uint, goodsID uint) string {
+	if a > b {
+		a, b = b, a
+	}
+	if goodsID == 0 {
+		return fmt.Sprintf("%d:%d", a, b)
+	}
+	return fmt.Sprintf("%d:%d:%d", a, b, goodsID)
 }
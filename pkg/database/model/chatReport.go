@@ -0,0 +1,22 @@
+package model
+
+import "gorm.io/gorm"
+
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+	ReportRejected = "rejected"
+)
+
+// ChatReport 用户对其他用户或聊天消息的举报，由管理员审核
+type ChatReport struct {
+	gorm.Model
+	ReporterID uint   `json:"reporterId" gorm:"index;not null"`
+	UserID     uint   `json:"userId" gorm:"index;not null"` // 被举报的用户
+	MessageID  uint   `json:"messageId"`                    // 被举报的消息，举报用户时为 0
+	Snapshot   string `json:"snapshot" gorm:"type:text"`    // 举报时的消息内容，消息之后被删除也能审核
+	Reason     string `json:"reason" gorm:"type:varchar(1024);not null"`
+	Status     string `json:"status" gorm:"type:varchar(20);index;not null"`
+	Resolution string `json:"resolution" gorm:"type:varchar(1024);not null"`
+	HandlerID  uint   `json:"handlerId"`
+}
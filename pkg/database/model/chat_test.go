@@ -0,0 +1,26 @@
+package model
+
+import "testing"
+
+func TestPairKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    uint
+		goodsID uint
+		want    string
+	}{
+		{name: "ordered", a: 1, b: 2, want: "1:2"},
+		{name: "reversed", a: 2, b: 1, want: "1:2"},
+		{name: "multi digit", a: 12, b: 3, want: "3:12"},
+		{name: "goods", a: 1, b: 2, goodsID: 7, want: "1:2:7"},
+		{name: "goods reversed", a: 2, b: 1, goodsID: 7, want: "1:2:7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PairKey(tt.a, tt.b, tt.goodsID); got != tt.want {
+				t.Errorf("PairKey(%d, %d, %d) = %q, want %q", tt.a, tt.b, tt.goodsID, got, tt.want)
+			}
+		})
+	}
+}
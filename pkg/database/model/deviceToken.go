@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// DeviceToken 离线推送的设备令牌，同一设备换账号登录时归属新用户，失效或注销时直接删除
+type DeviceToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	Token     string `gorm:"type:varchar(255);uniqueIndex;not null"`
+	Platform  string `gorm:"type:varchar(16);not null"` // ios、android 或 web
+}
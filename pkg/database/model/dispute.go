@@ -0,0 +1,20 @@
+package model
+
+import "gorm.io/gorm"
+
+const (
+	DisputeOpen     = "open"
+	DisputeResolved = "resolved"
+	DisputeRejected = "rejected"
+)
+
+// Dispute 买家对订单发起的纠纷，由管理员处理
+type Dispute struct {
+	gorm.Model
+	OrderID    uint   `json:"orderId" gorm:"index;not null"`
+	UserID     uint   `json:"userId" gorm:"index;not null"`
+	Reason     string `json:"reason" gorm:"type:varchar(1024);not null"`
+	Status     string `json:"status" gorm:"type:varchar(20);index;not null"`
+	Resolution string `json:"resolution" gorm:"type:varchar(1024);not null"`
+	HandlerID  uint   `json:"handlerId"`
+}
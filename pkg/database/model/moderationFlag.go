@@ -0,0 +1,22 @@
+package model
+
+import "gorm.io/gorm"
+
+const (
+	FlagOpen     = "open"
+	FlagResolved = "resolved"
+	FlagRejected = "rejected"
+)
+
+// ModerationFlag 内容审核命中 flag 规则的记录，内容照常发布，由管理员复查
+type ModerationFlag struct {
+	gorm.Model
+	Scope      string `json:"scope" gorm:"type:varchar(20);index;not null"` // chat 或 goods
+	UserID     uint   `json:"userId" gorm:"index;not null"`                 // 发布内容的用户
+	TargetID   uint   `json:"targetId" gorm:"not null"`                     // 消息或商品 ID
+	Content    string `json:"content" gorm:"type:text"`
+	Rules      string `json:"rules" gorm:"type:varchar(255);not null"` // 命中的规则名，逗号分隔
+	Status     string `json:"status" gorm:"type:varchar(20);index;not null"`
+	Resolution string `json:"resolution" gorm:"type:varchar(1024);not null"`
+	HandlerID  uint   `json:"handlerId"`
+}
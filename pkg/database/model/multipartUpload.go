@@ -0,0 +1,13 @@
+package model
+
+import "gorm.io/gorm"
+
+// MultipartUpload 未完成的分片上传，用于校验归属和清理过期上传
+type MultipartUpload struct {
+	gorm.Model
+	UploadID    string `gorm:"type:varchar(255);not null;uniqueIndex"`
+	UserID      uint   `gorm:"index;not null"`
+	Bucket      string `gorm:"type:varchar(63);not null"`
+	Object      string `gorm:"type:varchar(767);not null"`
+	ContentType string `gorm:"type:varchar(255)"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification 用户收件箱中的系统通知，标题和内容在创建时按模板生成
+type Notification struct {
+	gorm.Model
+	UserID  uint       `gorm:"index:idx_user_read;not null"`
+	Type    string     `gorm:"type:varchar(32);index:idx_type_ref;not null"`
+	RefID   uint       `gorm:"index:idx_type_ref"` // 关联的订单、商品、评价等
+	Title   string     `gorm:"type:varchar(255);not null"`
+	Content string     `gorm:"type:varchar(1024);not null"`
+	ReadAt  *time.Time `gorm:"index:idx_user_read"`
+}
@@ -2,10 +2,16 @@ package model
 
 import "gorm.io/gorm"
 
+const (
+	OrderPending   = "pending"   // 已下单，等待买家确认收货
+	OrderCompleted = "completed" // 买家已确认收货，双方可以互相评价
+)
+
 type Order struct {
 	gorm.Model // ID gen update del
 	GoodId     string
 	AddressId  string
 	UserId     uint
 	PayMoney   int
+	Status     string `gorm:"type:varchar(20);not null;default:pending"`
 }
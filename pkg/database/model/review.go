@@ -0,0 +1,19 @@
+package model
+
+import "gorm.io/gorm"
+
+const (
+	ReviewerBuyer  = "buyer"
+	ReviewerSeller = "seller"
+)
+
+// Review 交易完成后买卖双方的互相评价，每个订单每人只能评价一次
+type Review struct {
+	gorm.Model
+	OrderID    uint   `gorm:"uniqueIndex:idx_order_reviewer;not null"`
+	ReviewerID uint   `gorm:"uniqueIndex:idx_order_reviewer;not null"`
+	RevieweeID uint   `gorm:"index;not null"`
+	Role       string `gorm:"type:varchar(10);not null"` // 评价人在交易中的身份
+	Rating     int    `gorm:"not null"`                  // 1-5 星
+	Content    string `gorm:"type:varchar(1024);not null"`
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StorageObject 记录对象由哪个用户上传，删除时据此校验归属
+type StorageObject struct {
+	gorm.Model
+	UserID uint   `gorm:"index;not null"`
+	Bucket string `gorm:"type:varchar(63);not null"`
+	Object string `gorm:"type:varchar(767);not null;index"`
+	Hash   string `gorm:"type:char(64);index"` // 内容哈希，去重之前上传的对象为空
+}
+
+// StorageBlob 按内容哈希去重后的实际对象，RefCount 为引用它的 StorageObject 数量
+type StorageBlob struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Bucket    string `gorm:"type:varchar(63);not null;uniqueIndex:idx_bucket_hash;uniqueIndex:idx_bucket_object"`
+	Hash      string `gorm:"type:char(64);not null;uniqueIndex:idx_bucket_hash"`
+	Object    string `gorm:"type:varchar(512);not null;uniqueIndex:idx_bucket_object"` // 一个对象只能属于一个 blob，覆盖写入时唯一索引会报错
+	ETag      string `gorm:"type:varchar(255)"`
+	Size      int64  `gorm:"not null"`
+	RefCount  int    `gorm:"not null;index"`
+}
+
+// StorageAudit 对象删除的审计记录
+type StorageAudit struct {
+	gorm.Model
+	UserID uint   `gorm:"index;not null"`
+	Action string `gorm:"type:varchar(30);not null"`
+	Bucket string `gorm:"type:varchar(63);not null"`
+	Object string `gorm:"type:varchar(767);not null"`
+	Admin  bool   `gorm:"not null"`
+}
@@ -2,12 +2,20 @@ package model
 
 import "gorm.io/gorm"
 
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	gorm.Model
 	Name      string `json:"nickname" gorm:"type:varchar(30);not null"`
 	Telephone string `json:"account" gorm:"type:varchar(30);not null;unique"` //手机号就是账号，要求11位
-	Password  string `json:"password" gorm:"size:255"`                        //密码长度：6-14
+	Password  string `json:"-" gorm:"size:255"`                               //密码哈希，不参与序列化
 	Gender    string `json:"gender" gorm:"type:varchar(10);not null"`
 	// Token     string `json:"token" gorm:"size:255;not null"`
 	Avatar string `json:"avatar" gorm:"type:varchar(1024);not null"`
+	Role   string `json:"role" gorm:"type:varchar(20);not null;default:user"`
+	Banned bool   `json:"banned" gorm:"not null;default:false"`
 }
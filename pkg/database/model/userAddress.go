@@ -4,8 +4,13 @@ import "gorm.io/gorm"
 
 type UserAddress struct {
 	gorm.Model
-	Receiver string `json:"receiver" gorm:"type:varchar(255);not null"`
-	Contact  string `json:"contact" gorm:"type:varchar(255);not null"`
-	Address  string `json:"address" gorm:"type:varchar(1024);not null"`
-	UserID   uint   `json:"userID" gorm:"type:varchar(30);not null"`
+	UserID    uint   `json:"userID" gorm:"index;not null"`
+	Receiver  string `json:"receiver" gorm:"type:varchar(255);not null"`
+	Contact   string `json:"contact" gorm:"type:varchar(255);not null"` // 收件人手机号
+	Province  string `json:"province" gorm:"type:varchar(50);not null;default:''"`
+	City      string `json:"city" gorm:"type:varchar(50);not null;default:''"`
+	District  string `json:"district" gorm:"type:varchar(50);not null;default:''"`
+	Detail    string `json:"detail" gorm:"type:varchar(512);not null;default:''"`
+	Address   string `json:"address" gorm:"type:varchar(1024);not null"` // 完整地址，旧数据只有这一项
+	IsDefault bool   `json:"isDefault" gorm:"not null;default:false"`
 }
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// UserBlock 用户拉黑的人，双方都不能再给对方发消息，取消拉黑时直接删除
+type UserBlock struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint `gorm:"uniqueIndex:idx_user_blocked;not null"`
+	BlockedID uint `gorm:"uniqueIndex:idx_user_blocked;index;not null"`
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"strconv"
+
+	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/storage"
+)
+
+type storageKey struct {
+	bucket string
+	object string
+}
+
+type legacyGoodsImage struct {
+	ID      uint
+	User    string
+	Picture string
+}
+
+type legacyPictureImage struct {
+	GoodId   string
+	Picture1 string
+	Picture2 string
+	Picture3 string
+	Picture4 string
+	Picture5 string
+}
+
+type legacyAvatar struct {
+	ID     uint
+	Avatar string
+}
+
+// migrateStorageObjects 上传记录是后来加的，按商品图片、商品详情图和头像为旧对象补上归属，
+// 否则旧图片在删除、导出和注销时都找不到属主。已有记录的对象跳过，可以重复执行；
+// 被多个用户引用的对象无法判断属主，不补记录
+func migrateStorageObjects(db *gorm.DB, storageClient *storage.Client) error {
+	if err := db.AutoMigrate(&model.StorageObject{}); err != nil {
+		return err
+	}
+
+	owners := make(map[storageKey]uint)
+	shared := make(map[storageKey]bool)
+	addOwner := func(value string, userID uint) {
+		if value == "" || userID == 0 {
+			return
+		}
+		// 无法识别的外部地址不属于本服务的存储
+		bucketName, objectName, err := storageClient.ParseURL(storageClient.ObjectKey(value))
+		if err != nil || !storageClient.IsBucket(bucketName) {
+			return
+		}
+		key := storageKey{bucket: bucketName, object: objectName}
+		if owner, ok := owners[key]; ok && owner != userID {
+			shared[key] = true
+		}
+		owners[key] = userID
+	}
+
+	// 软删除的商品和用户的图片仍然在存储中，一并补上记录
+	var goods []legacyGoodsImage
+	if err := db.Unscoped().Model(&model.Goods{}).Select("id", "user", "picture").Find(&goods).Error; err != nil {
+		return err
+	}
+	goodsOwner := make(map[string]uint, len(goods))
+	for _, item := range goods {
+		userID, err := strconv.ParseUint(item.User, 10, 64)
+		if err != nil {
+			continue
+		}
+		goodsOwner[strconv.Itoa(int(item.ID))] = uint(userID)
+		addOwner(item.Picture, uint(userID))
+	}
+
+	var pictures []legacyPictureImage
+	if err := db.Model(&model.Picture{}).Find(&pictures).Error; err != nil {
+		return err
+	}
+	for _, picture := range pictures {
+		userID := goodsOwner[picture.GoodId]
+		for _, value := range []string{picture.Picture1, picture.Picture2, picture.Picture3, picture.Picture4, picture.Picture5} {
+			addOwner(value, userID)
+		}
+	}
+
+	var avatars []legacyAvatar
+	if err := db.Unscoped().Model(&model.User{}).Select("id", "avatar").Find(&avatars).Error; err != nil {
+		return err
+	}
+	for _, user := range avatars {
+		addOwner(user.Avatar, user.ID)
+	}
+
+	var existing []model.StorageObject
+	if err := db.Unscoped().Select("bucket", "object").Find(&existing).Error; err != nil {
+		return err
+	}
+	for _, record := range existing {
+		delete(owners, storageKey{bucket: record.Bucket, object: record.Object})
+	}
+
+	var records []model.StorageObject
+	for key, userID := range owners {
+		if shared[key] {
+			continue
+		}
+		records = append(records, model.StorageObject{UserID: userID, Bucket: key.bucket, Object: key.object})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return db.CreateInBatches(&records, 500).Error
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/storage"
+)
+
+func TestMain(m *testing.M) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	logger.SmileLog = &logger.SmileLogger{Logger: log}
+
+	os.Exit(m.Run())
+}
+
+func TestMigrateStorageObjects(t *testing.T) {
+	db := newTestDB(t, &model.User{}, &model.Goods{}, &model.Picture{}, &model.StorageObject{})
+	storageClient := storage.NewClient(&storage.Options{
+		Driver:  storage.DriverLocal,
+		Buckets: []string{"picture", "video"},
+		Local: &storage.LocalOptions{
+			Root:    t.TempDir(),
+			BaseURL: "http://localhost:8080/storage/file",
+		},
+	})
+
+	users := []model.User{
+		{Name: "alice", Telephone: "13800000001", Avatar: "alice/2024-01-01/avatar"},
+		{Name: "bob", Telephone: "13800000002", Avatar: "default"},
+		{Name: "carol", Telephone: "13800000003", Avatar: "default"},
+	}
+	goods := []model.Goods{
+		{User: "1", Picture: "http://localhost:8080/storage/file/picture/alice/goods"},
+		{User: "2", Picture: "video/bob/goods"},
+		{User: "2", Picture: "https://example.com/external.png"},
+	}
+	pictures := []model.Picture{
+		{GoodId: "2", Picture1: "bob/detail1", Picture2: "bob/detail2"},
+		{GoodId: "9", Picture1: "unknown/detail"},
+	}
+	for _, value := range []interface{}{&users, &goods, &pictures} {
+		if err := db.Create(value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 已经有上传记录的对象保持原来的属主，删除过的也不能补回来
+	existing := []model.StorageObject{
+		{UserID: 3, Bucket: "picture", Object: "bob/detail2"},
+		{UserID: 1, Bucket: "picture", Object: "alice/goods"},
+	}
+	if err := db.Create(&existing).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&existing[1]).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 重复执行结果相同
+	for i := 0; i < 2; i++ {
+		if err := migrateStorageObjects(db, storageClient); err != nil {
+			t.Fatalf("migrateStorageObjects error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		bucket string
+		object string
+		want   []uint
+	}{
+		{bucket: "picture", object: "alice/2024-01-01/avatar", want: []uint{1}},
+		{bucket: "picture", object: "alice/goods"},
+		{bucket: "video", object: "bob/goods", want: []uint{2}},
+		{bucket: "picture", object: "bob/detail1", want: []uint{2}},
+		{bucket: "picture", object: "bob/detail2", want: []uint{3}},
+		{bucket: "picture", object: "unknown/detail"},
+		// 多个用户使用的对象无法判断属主
+		{bucket: "picture", object: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.bucket+"/"+tt.object, func(t *testing.T) {
+			var owners []uint
+			if err := db.Model(&model.StorageObject{}).Where("bucket = ? AND object = ?", tt.bucket, tt.object).
+				Pluck("user_id", &owners).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(owners) != len(tt.want) || (len(owners) == 1 && owners[0] != tt.want[0]) {
+				t.Errorf("owners = %v, want %v", owners, tt.want)
+			}
+		})
+	}
+
+	var total int64
+	db.Model(&model.StorageObject{}).Count(&total)
+	if total != 4 {
+		t.Errorf("records = %d, want 4", total)
+	}
+}
@@ -3,3 +3,156 @@ package api
 type RemoveObjectRequest struct {
 	URL string `json:"url"`
 }
+
+type InitiateMultipartRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type LoginRequest struct {
+	Telephone string `json:"account"`
+	Password  string `json:"password"`
+}
+
+type RegisterRequest struct {
+	Name      string `json:"nickname"`
+	Telephone string `json:"account"`
+	Password  string `json:"password"`
+	Gender    string `json:"gender"`
+	Avatar    string `json:"avatar"`
+	Code      string `json:"code"` // 短信验证码
+}
+
+// ChangeInfoRequest 只允许修改昵称和性别
+type ChangeInfoRequest struct {
+	Name   string `json:"nickname"`
+	Gender string `json:"gender"`
+}
+
+type SendCodeRequest struct {
+	Telephone string `json:"telephone"`
+	Purpose   string `json:"purpose"` // register、reset_password 或 change_telephone
+}
+
+type ResetPasswordRequest struct {
+	Telephone   string `json:"telephone"`
+	Code        string `json:"code"`
+	NewPassword string `json:"new_password"`
+}
+
+type ChangeTelephoneRequest struct {
+	Telephone string `json:"telephone"`
+	Code      string `json:"code"`
+}
+
+type BanUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role"` // user、moderator 或 admin
+}
+
+type TakedownGoodsRequest struct {
+	Reason string `json:"reason"`
+}
+
+type CategoryRequest struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+}
+
+type BannerRequest struct {
+	Id     string `json:"id"`
+	ImgUrl string `json:"imgUrl"`
+	HreUrl string `json:"hrefUrl"`
+}
+
+type CreateDisputeRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ResolveDisputeRequest struct {
+	Status     string `json:"status"` // resolved 或 rejected
+	Resolution string `json:"resolution"`
+}
+
+type ReviewRequest struct {
+	Rating  int    `json:"rating"` // 1-5 星
+	Content string `json:"content"`
+}
+
+type AddressRequest struct {
+	Receiver  string `json:"receiver"`
+	Contact   string `json:"contact"`
+	Province  string `json:"province"`
+	City      string `json:"city"`
+	District  string `json:"district"`
+	Detail    string `json:"detail"`
+	Address   string `json:"address"` // 旧客户端只提交完整地址
+	IsDefault bool   `json:"isDefault"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// SendMessageRequest 兼容旧版 /chat/send_msg 的请求体，MessageType 为空时是文本消息
+type SendMessageRequest struct {
+	You            string `json:"you"`
+	ConversationID uint   `json:"conversationId"` // 发到指定会话，例如商品会话，此时可以不传 you
+	MessageType    string `json:"messageType"`
+	Content        string `json:"content"` // 文本内容或图片地址
+	RefID          uint   `json:"refId"`   // 商品或订单 ID
+}
+
+type AddChatRequest struct {
+	You string `json:"you"`
+}
+
+// ReadConversationRequest MessageID 为空时标记会话中的全部消息为已读
+type ReadConversationRequest struct {
+	MessageID uint `json:"messageId"`
+}
+
+// CreateConversationRequest 传 GoodsID 时创建或复用与卖家关于该商品的会话，否则是与 UserID 的普通会话
+type CreateConversationRequest struct {
+	UserID  uint `json:"userId"`
+	GoodsID uint `json:"goodsId"`
+}
+
+type BlockUserRequest struct {
+	UserID uint `json:"userId"`
+}
+
+// ReportRequest 举报消息时传 MessageID，被举报的用户由消息确定
+type ReportRequest struct {
+	UserID    uint   `json:"userId"`
+	MessageID uint   `json:"messageId"`
+	Reason    string `json:"reason"`
+}
+
+type ResolveReportRequest struct {
+	Status     string `json:"status"` // resolved 或 rejected
+	Resolution string `json:"resolution"`
+}
+
+type ResolveFlagRequest struct {
+	Status     string `json:"status"` // resolved 或 rejected
+	Resolution string `json:"resolution"`
+}
+
+type RegisterDeviceRequest struct {
+	Token    string `json:"token"`
+	Platform string `json:"platform"` // ios、android 或 web
+}
+
+// UnregisterDeviceRequest web 推送的令牌是 URL，不能放在路径里
+type UnregisterDeviceRequest struct {
+	Token string `json:"token"`
+}
@@ -1,6 +1,10 @@
 package api
 
-import "smile.expression/destiny/pkg/database/model"
+import (
+	"time"
+
+	"smile.expression/destiny/pkg/database/model"
+)
 
 type PutObjectResponse struct {
 	URL  string `json:"url"`
@@ -8,15 +12,31 @@ type PutObjectResponse struct {
 	Size int64  `json:"size"`
 }
 
+type MultipartUploadResponse struct {
+	UploadID string `json:"uploadId"`
+	ImageID  string `json:"imageId"`
+}
+
 type Address struct {
 	AddressID string `json:"id"`
 	Receiver  string `json:"receiver"`
 	Contact   string `json:"contact"`
+	Province  string `json:"province"`
+	City      string `json:"city"`
+	District  string `json:"district"`
+	Detail    string `json:"detail"`
 	Address   string `json:"address"`
+	IsDefault bool   `json:"isDefault"`
 }
 
 type RegisterResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
 }
 
 type Goods struct { // "_2" 区分于commodity controller的AllIdle
@@ -27,11 +47,120 @@ type Goods struct { // "_2" 区分于commodity controller的AllIdle
 }
 
 type UserResponse struct {
-	ID          uint      `json:"id"`
-	Account     string    `json:"account"`
-	Token       string    `json:"token"`
-	Avatar      string    `json:"avatar"`
-	Nickname    string    `json:"nickname"`
-	Gender      string    `json:"gender"`
-	UserAddress []Address `json:"userAddresses"`
+	ID           uint      `json:"id"`
+	Account      string    `json:"account"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	Avatar       string    `json:"avatar"`
+	Nickname     string    `json:"nickname"`
+	Gender       string    `json:"gender"`
+	UserAddress  []Address `json:"userAddresses"`
+}
+
+// PublicUser 可以展示给任何人的用户信息
+type PublicUser struct {
+	ID       uint      `json:"id"`
+	Nickname string    `json:"nickname"`
+	Avatar   string    `json:"avatar"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// Profile 用户本人可见的信息
+type Profile struct {
+	ID       uint      `json:"id"`
+	Account  string    `json:"account"`
+	Nickname string    `json:"nickname"`
+	Gender   string    `json:"gender"`
+	Avatar   string    `json:"avatar"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// AdminUser 后台用户列表中的用户信息
+type AdminUser struct {
+	Profile
+	Banned bool `json:"banned"`
+}
+
+// SellerProfile 卖家公开主页
+type SellerProfile struct {
+	PublicUser
+	Listings     []model.Goods `json:"listings"`
+	ListingCount int64         `json:"listingCount"`
+	SoldCount    int64         `json:"soldCount"`
+	Rating       float64       `json:"rating"`      // 平均评分，没有评价时为 0
+	RatingCount  int64         `json:"ratingCount"` // 评价数量
+	Reviews      []Review      `json:"reviews"`     // 最近收到的评价
+}
+
+// RatingSummary 用户收到的评价汇总
+type RatingSummary struct {
+	Rating float64 `json:"rating"`
+	Count  int64   `json:"count"`
+}
+
+type Review struct {
+	ID        uint       `json:"id"`
+	OrderID   uint       `json:"orderId"`
+	Reviewer  PublicUser `json:"reviewer"`
+	Role      string     `json:"role"`
+	Rating    int        `json:"rating"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+type ChatMessage struct {
+	ID             uint       `json:"id"`
+	MessageID      string     `json:"messageId"`
+	ConversationID uint       `json:"conversationId"`
+	SenderID       uint       `json:"senderId"`
+	Type           string     `json:"type"`
+	Content        string     `json:"content"` // 文本内容，其他类型为列表中展示的摘要
+	Image          string     `json:"image,omitempty"`
+	Goods          *GoodsCard `json:"goods,omitempty"`
+	Order          *OrderCard `json:"order,omitempty"`
+	Delivered      bool       `json:"delivered"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// GoodsCard 聊天中分享的商品，Removed 表示商品已下架或删除
+type GoodsCard struct {
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	Price   string `json:"price"`
+	Picture string `json:"picture"`
+	IsSold  bool   `json:"isSold"`
+	Removed bool   `json:"removed"`
+}
+
+// OrderCard 聊天中分享的订单
+type OrderCard struct {
+	ID       uint       `json:"id"`
+	Status   string     `json:"status"`
+	PayMoney int        `json:"payMoney"`
+	Goods    *GoodsCard `json:"goods,omitempty"`
+}
+
+// Conversation 会话列表中的一项
+type Conversation struct {
+	ID          uint         `json:"id"`
+	Peer        PublicUser   `json:"peer"`
+	Goods       *GoodsCard   `json:"goods,omitempty"` // 商品会话顶部展示的商品
+	LastMessage *ChatMessage `json:"lastMessage"`
+	Unread      int64        `json:"unread"`
+	LastReadID  uint         `json:"lastReadId"`
+	Muted       bool         `json:"muted"`
+	Online      bool         `json:"online"`
+	LastSeen    *time.Time   `json:"lastSeen"`
+}
+
+type Notification struct {
+	ID        uint      `json:"id"`
+	Type      string    `json:"type"`
+	RefID     uint      `json:"refId"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"createdAt"`
 }
@@ -3,13 +3,41 @@ package controller
 import (
 	"fmt"
 	"net/http"
-	"smile.expression/destiny/pkg/database"
-	model2 "smile.expression/destiny/pkg/database/model"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/auth"
+	model2 "smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/storage"
 )
 
+type CartController struct {
+	r              *gin.Engine
+	db             *gorm.DB
+	storageClient  *storage.Client
+	authController *AuthController
+}
+
+func NewCartController(r *gin.Engine, db *gorm.DB, storageClient *storage.Client, authController *AuthController) *CartController {
+	return &CartController{
+		r:              r,
+		db:             db,
+		storageClient:  storageClient,
+		authController: authController,
+	}
+}
+
+func (c *CartController) Register() {
+	rg := c.r.Group("/member/cart", c.authController.AuthMiddleware())
+
+	rg.POST("/add", c.cartIn)
+	rg.GET("/pull", c.cartOut)
+	rg.DELETE("/del", c.cartDel)
+	rg.DELETE("/del2", c.cartDelOne)
+}
+
 type CartGoods struct {
 	Id          string `json:"id"`
 	CateId      string
@@ -29,15 +57,18 @@ type goodID struct {
 	GID string `json:"id"`
 }
 
-func CartIn(c *gin.Context) {
-	user, _ := c.Get("user")
-	userinfo := user.(model2.User)
+func (c *CartController) cartIn(ctx *gin.Context) {
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
 	uId := userinfo.ID
 	fmt.Println("uId: ", uId)
-	db := database.GetDB()
+	db := c.db
 	var gID goodID
-	if err := c.BindJSON(&gID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+	if err := ctx.BindJSON(&gID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -61,14 +92,14 @@ func CartIn(c *gin.Context) {
 		}
 		tx.Commit()
 		fmt.Println("check 3")
-		c.JSON(200, gin.H{
+		ctx.JSON(200, gin.H{
 			"code":   "1",
 			"result": "true",
 			"msg":    "操作成功",
 		})
 	} else {
 		// 存在相同的数据，不插入新数据
-		c.JSON(200, gin.H{
+		ctx.JSON(200, gin.H{
 			"code":   "0",
 			"result": false,
 			"msg":    "已经加入购物车",
@@ -77,14 +108,17 @@ func CartIn(c *gin.Context) {
 
 }
 
-func CartDel(c *gin.Context) {
-	user, _ := c.Get("user")
-	userinfo := user.(model2.User)
+func (c *CartController) cartDel(ctx *gin.Context) {
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
 	uId := userinfo.ID
-	db := database.GetDB()
+	db := c.db
 	var gIds goodIDs
-	if err := c.BindJSON(&gIds); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+	if err := ctx.BindJSON(&gIds); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -94,7 +128,7 @@ func CartDel(c *gin.Context) {
 		var count int64
 		db.Table("carts").Where("user_id = ? AND  good_id= ?", uId, id).Count(&count)
 		if count == 0 {
-			c.JSON(200, gin.H{
+			ctx.JSON(200, gin.H{
 				"code":   "0",
 				"result": false,
 				"msg":    "商品不在购物车内",
@@ -107,7 +141,7 @@ func CartDel(c *gin.Context) {
 			}
 			tx.Commit()
 			//有必要可以先查询验证
-			c.JSON(200, gin.H{
+			ctx.JSON(200, gin.H{
 				"code":   "1",
 				"result": true,
 				"msg":    "已删除",
@@ -116,18 +150,21 @@ func CartDel(c *gin.Context) {
 	}
 }
 
-// CartDelOne 该函数使用query传递单个参数
-func CartDelOne(c *gin.Context) {
-	user, _ := c.Get("user")
-	userinfo := user.(model2.User)
+// cartDelOne 该函数使用query传递单个参数
+func (c *CartController) cartDelOne(ctx *gin.Context) {
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
 	uId := userinfo.ID
-	db := database.GetDB()
-	gId := c.Query("id")
+	db := c.db
+	gId := ctx.Query("id")
 
 	var count int64
 	db.Table("carts").Where("user_id = ? AND  good_id= ?", uId, gId).Count(&count)
 	if count == 0 {
-		c.JSON(200, gin.H{
+		ctx.JSON(200, gin.H{
 			"code":   "0",
 			"result": false,
 			"msg":    "商品不在购物车内",
@@ -141,7 +178,7 @@ func CartDelOne(c *gin.Context) {
 		tx.Commit()
 
 		//有必要可以先查询验证
-		c.JSON(200, gin.H{
+		ctx.JSON(200, gin.H{
 			"code":   "1",
 			"result": "true",
 			"msg":    "已删除",
@@ -149,11 +186,14 @@ func CartDelOne(c *gin.Context) {
 	}
 }
 
-func CartOut(c *gin.Context) {
-	user, _ := c.Get("user")
-	userinfo := user.(model2.User)
+func (c *CartController) cartOut(ctx *gin.Context) {
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
 	uId := userinfo.ID
-	db := database.GetDB()
+	db := c.db
 
 	var result []CartGoods
 	err := db.Table("carts").Joins("left join goods ON carts.good_id = goods.id").Where("carts.user_id = ?", uId).Scan(&result)
@@ -165,8 +205,12 @@ func CartOut(c *gin.Context) {
 		//错误处理
 		panic(err)
 	}
+	for i := range result {
+		result[i].Picture = c.storageClient.ObjectURL(result[i].Picture)
+	}
+
 	// 输出查询结果
-	c.JSON(200, gin.H{
+	ctx.JSON(200, gin.H{
 		"code":   "1",
 		"msg":    "获取购物车商品成功",
 		"result": result,
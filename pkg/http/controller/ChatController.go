@@ -1,55 +1,207 @@
 package controller
 
 import (
-	"smile.expression/destiny/pkg/database"
-	model2 "smile.expression/destiny/pkg/database/model"
+	"context"
+	"errors"
+	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"smile.expression/destiny/pkg/auth"
+	"smile.expression/destiny/pkg/cache"
+	"smile.expression/destiny/pkg/chat"
+	model2 "smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/http/middleware"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/moderation"
+	"smile.expression/destiny/pkg/push"
+	"smile.expression/destiny/pkg/storage"
 )
 
+// 前端与接口不同源，由 AuthMiddleware 负责鉴权
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+type ChatController struct {
+	options        *ChatControllerOptions
+	r              *gin.Engine
+	db             *gorm.DB
+	cacheClient    *cache.Client
+	storageClient  *storage.Client
+	authController *AuthController
+	hub            *chat.Hub
+	relay          *chat.Relay
+	presence       *chat.Presence
+	moderator      *moderation.Pipeline
+	pushClient     *push.Client
+}
+
+type ChatControllerOptions struct {
+	SendRateLimit *middleware.RateLimitOptions `json:"sendRateLimit"`
+	MaxTextLength int                          `json:"maxTextLength"` // 文本消息的最大字数，默认 2000
+}
+
+func NewChatController(options *ChatControllerOptions, r *gin.Engine, db *gorm.DB, cacheClient *cache.Client, storageClient *storage.Client, authController *AuthController, hub *chat.Hub, relay *chat.Relay, presence *chat.Presence, moderator *moderation.Pipeline, pushClient *push.Client) *ChatController {
+	if options == nil {
+		options = &ChatControllerOptions{}
+	}
+	if options.SendRateLimit == nil {
+		options.SendRateLimit = &middleware.RateLimitOptions{Limit: 30, Window: 60}
+	}
+	if options.MaxTextLength <= 0 {
+		options.MaxTextLength = 2000
+	}
+
+	return &ChatController{
+		options:        options,
+		r:              r,
+		db:             db,
+		cacheClient:    cacheClient,
+		storageClient:  storageClient,
+		authController: authController,
+		hub:            hub,
+		relay:          relay,
+		presence:       presence,
+		moderator:      moderator,
+		pushClient:     pushClient,
+	}
+}
+
+func (c *ChatController) Register() {
+	c.r.GET("/chat/ws", c.authController.WebSocketAuth(), c.serveWs)
+
+	rg := c.r.Group("/chat", c.authController.AuthMiddleware())
+
+	rg.POST("/ws/ticket", c.issueTicket)
+	rg.GET("/presence/:id", c.getPresence)
+	rg.GET("/conversations", c.listConversations)
+	rg.POST("/conversations", c.createConversation)
+	rg.GET("/conversations/:id", c.getConversation)
+	rg.GET("/conversations/:id/messages", c.listMessages)
+	rg.POST("/conversations/:id/read", c.readConversation)
+	rg.POST("/conversations/:id/mute", c.muteConversation)
+	rg.DELETE("/conversations/:id/mute", c.unmuteConversation)
+
+	rg.GET("/blocks", c.listBlocks)
+	rg.POST("/blocks", c.blockUser)
+	rg.DELETE("/blocks/:id", c.unblockUser)
+	rg.POST("/reports", c.createReport)
+
+	// 旧版接口
+	rg.GET("/get_msg", c.getMsg)
+	rg.POST("/send_msg", middleware.RateLimit(c.cacheClient, "send_msg", c.options.SendRateLimit, middleware.ByUser), c.sendMsg)
+	rg.POST("/add_chat", c.addChat)
+}
+
+// ChatDto 旧版接口的消息格式，Type 为 1 表示自己发出，0 表示收到
 type ChatDto struct {
-	Type    string
-	Content string
+	Type      string
+	Content   string
+	MessageID string
+	Delivered bool
 }
 
-func ToChatDto(chat model2.Chat) ChatDto {
-	return ChatDto{
-		Type:    chat.Type,
-		Content: chat.Content,
+func ToChatDto(message model2.Message, userID uint) ChatDto {
+	dto := ChatDto{
+		Type:      "0",
+		Content:   messagePreview(&message),
+		MessageID: message.UUID,
+		Delivered: message.Delivered,
 	}
+	if message.SenderID == userID {
+		dto.Type = "1"
+	}
+	return dto
 }
 
 type single struct {
 	Id       string
 	Nickname string
 	Avatar   string
+	Online   bool
+	LastSeen *time.Time
 	Chat     []ChatDto
 }
 
-func GetMsg(ctx *gin.Context) {
-	DB := database.GetDB()
+// getMsg 旧版接口，返回所有会话的全部消息
+func (c *ChatController) getMsg(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
 
 	// 获取当前用户的id
-	user, _ := ctx.Get("user")
-	userinfo := user.(model2.User)
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
 	id := userinfo.ID
 
-	var chatList []model2.ChatList
-	DB.Table("chat_lists").Where("me = ?", id).Find(&chatList)
+	peers, err := c.conversationPeers(id, 0, 0)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query conversations error: %d", id)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	conversationIDs := make([]uint, 0, len(peers))
+	userIDs := make([]uint, 0, len(peers))
+	for _, peer := range peers {
+		conversationIDs = append(conversationIDs, peer.ConversationID)
+		userIDs = append(userIDs, peer.UserID)
+	}
+
+	users, err := c.usersByID(userIDs)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query users error: %d", id)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	var messages []model2.Message
+	if len(conversationIDs) > 0 {
+		if err = c.db.Where("conversation_id IN ?", conversationIDs).Order("id").Find(&messages).Error; err != nil {
+			log.WithError(err).Errorf("mysql query messages error: %d", id)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+			return
+		}
+	}
+
+	chats := make(map[uint][]ChatDto, len(peers))
+	var received []string
+	for _, message := range messages {
+		chats[message.ConversationID] = append(chats[message.ConversationID], ToChatDto(message, id))
+		if message.SenderID != id && !message.Delivered {
+			received = append(received, message.UUID)
+		}
+	}
+	// 通过接口拉取到的消息也算送达
+	if err = c.markDelivered(ctx0, id, received); err != nil {
+		log.WithError(err).Errorf("mysql mark delivered error: %d", id)
+	}
 
+	presence := c.presencesOf(ctx0, userIDs)
 	var list []single
-	for i := 0; i < len(chatList); i++ {
-		var chat []model2.Chat
-		DB.Table("chats").Where("me = ? and you = ?", id, chatList[i].You).Find(&chat)
-		// fmt.Println(chat)
-		var chatDto []ChatDto
-		for j := 0; j < len(chat); j++ {
-			chatDto = append(chatDto, ToChatDto(chat[j]))
-		}
-		var tempUser model2.User
-		DB.Table("users").Where("id = ?", chatList[i].You).First(&tempUser)
-		newSingle := single{Id: chatList[i].You, Nickname: tempUser.Name, Avatar: tempUser.Avatar, Chat: chatDto}
+	for _, peer := range peers {
+		newSingle := single{Id: strconv.Itoa(int(peer.UserID)), Chat: chats[peer.ConversationID]}
+		if tempUser, ok := users[peer.UserID]; ok {
+			newSingle.Nickname = tempUser.Name
+			newSingle.Avatar = c.storageClient.ObjectURL(tempUser.Avatar)
+		}
+		newSingle.Online, newSingle.LastSeen = presence.of(peer.UserID)
 		list = append(list, newSingle)
 	}
 
@@ -58,56 +210,946 @@ func GetMsg(ctx *gin.Context) {
 	})
 }
 
-func SendMsg(ctx *gin.Context) {
-	DB := database.GetDB()
-	user, _ := ctx.Get("user")
-	userinfo := user.(model2.User)
+// sendMsg 旧版接口，通过 REST 发送消息
+func (c *ChatController) sendMsg(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
 
-	var chat model2.Chat
-	if err := ctx.BindJSON(&chat); err != nil {
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
 		return
 	}
 
-	chat.Me = strconv.Itoa(int(userinfo.ID))
-	chat.Type = "1"
-	newChat := model2.Chat{Me: chat.You, You: chat.Me, Type: "0", Content: chat.Content}
-	DB.Create(&chat)
-	DB.Create(&newChat)
+	var req api.SendMessageRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		return
+	}
+
+	message, err := c.saveMessage(ctx0, userinfo.ID, req.You, req.ConversationID, &messageInput{Type: req.MessageType, Content: req.Content, RefID: req.RefID})
+	if err != nil {
+		if errors.Is(err, errBlocked) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if isMessageError(err) {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).Errorf("mysql create message error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
 
 	ctx.JSON(200, gin.H{
-		"result": "suc",
+		"result":         "suc",
+		"messageId":      message.ID,
+		"conversationId": message.ConversationID,
 	})
 }
 
-func AddChat(ctx *gin.Context) {
-	DB := database.GetDB()
-	user, _ := ctx.Get("user")
-	userinfo := user.(model2.User)
+// addChat 旧版接口，创建与对方的会话
+func (c *ChatController) addChat(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
 
-	var chatList, check model2.ChatList
-	if err := ctx.BindJSON(&chatList); err != nil {
+	var req api.AddChatRequest
+	if err := ctx.BindJSON(&req); err != nil {
 		return
 	}
 	// 将string转为uint
-	if chatList.You == strconv.Itoa(int(userinfo.ID)) {
+	peerID, err := strconv.ParseUint(req.You, 10, 64)
+	if err != nil || uint(peerID) == userinfo.ID {
 		ctx.JSON(200, gin.H{
 			"result": "bug",
 		})
 		return
 	}
-	DB.Table("chat_lists").Where("me = ? and you = ?", userinfo.ID, chatList.You).First(&check)
-	if check.ID != 0 {
+
+	exists, err := c.userExists(uint(peerID))
+	if err != nil {
+		log.WithError(err).Errorf("mysql query user error: %d", peerID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	var count int64
+	if err = c.db.Model(&model2.Conversation{}).Where("pair_key = ?", model2.PairKey(userinfo.ID, uint(peerID), 0)).Count(&count).Error; err != nil {
+		log.WithError(err).Errorf("mysql query conversation error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if count > 0 {
 		ctx.JSON(200, gin.H{
 			"result": "no need to add chat",
 		})
 		return
 	}
 
-	chatList.Me = strconv.Itoa(int(userinfo.ID))
-	newChatList := model2.ChatList{Me: chatList.You, You: chatList.Me}
-	DB.Create(&chatList)
-	DB.Create(&newChatList)
+	if _, err = c.findOrCreateConversation(userinfo.ID, uint(peerID), 0); err != nil {
+		log.WithError(err).Errorf("mysql create conversation error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
 	ctx.JSON(200, gin.H{
 		"result": "succeed in adding chat",
 	})
 }
+
+// createConversation 创建或复用会话，商品详情页的“联系卖家”传 goodsId
+func (c *ChatController) createConversation(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	var req api.CreateConversationRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		log.WithError(err).Error("create conversation bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	peerID := req.UserID
+	if req.GoodsID > 0 {
+		// 已下架的商品不能再咨询
+		var goods model2.Goods
+		if err := c.db.Where("id = ?", req.GoodsID).First(&goods).Error; err != nil {
+			abortQuery(ctx, err, "goods not found")
+			return
+		}
+		sellerID, err := strconv.ParseUint(goods.User, 10, 64)
+		if err != nil {
+			log.WithError(err).Errorf("invalid seller id: %s", goods.User)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "invalid seller"})
+			return
+		}
+		if peerID != 0 && peerID != uint(sellerID) {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "user is not the seller"})
+			return
+		}
+		peerID = uint(sellerID)
+	}
+
+	if peerID == 0 || peerID == userinfo.ID {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+		return
+	}
+	exists, err := c.userExists(peerID)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query user error: %d", peerID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	blocked, err := c.isBlocked(userinfo.ID, peerID)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query block error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if blocked {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "blocked"})
+		return
+	}
+
+	conversation, err := c.findOrCreateConversation(userinfo.ID, peerID, req.GoodsID)
+	if err != nil {
+		log.WithError(err).Errorf("mysql create conversation error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
+
+	c.respondConversation(ctx, userinfo.ID, conversation.ID)
+}
+
+// getConversation 单个会话，包括商品会话顶部的商品信息
+func (c *ChatController) getConversation(ctx *gin.Context) {
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	participant, ok := c.loadParticipant(ctx, userinfo.ID)
+	if !ok {
+		return
+	}
+
+	c.respondConversation(ctx, userinfo.ID, participant.ConversationID)
+}
+
+func (c *ChatController) respondConversation(ctx *gin.Context, userID uint, conversationID uint) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	var peers []conversationPeer
+	if err := c.peersQuery(userID).Where("me.conversation_id = ?", conversationID).Scan(&peers).Error; err != nil {
+		log.WithError(err).Errorf("mysql query conversation error: %d", conversationID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if len(peers) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+		return
+	}
+
+	items, err := c.conversations(ctx0, userID, peers)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query conversation error: %d", conversationID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": items[0]})
+}
+
+// listConversations 当前用户的会话列表，按最后一条消息时间倒序，带未读数
+func (c *ChatController) listConversations(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	page, size := pageParams(ctx)
+
+	var total int64
+	if err := c.db.Table("(?) AS peers", c.peersQuery(userinfo.ID)).Count(&total).Error; err != nil {
+		log.WithError(err).Errorf("mysql count conversations error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	peers, err := c.conversationPeers(userinfo.ID, (page-1)*size, size)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query conversations error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	items, err := c.conversations(ctx0, userinfo.ID, peers)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query conversations error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"total": total, "items": items}})
+}
+
+// listMessages 会话的历史消息，按 ID 游标分页：
+// before 向前翻页（结果从新到旧），after 拉取之后的新消息（结果从旧到新），都不传时返回最新的一页
+func (c *ChatController) listMessages(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	before, err1 := strconv.ParseUint(ctx.DefaultQuery("before", "0"), 10, 64)
+	after, err2 := strconv.ParseUint(ctx.DefaultQuery("after", "0"), 10, 64)
+	limit, err3 := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if err1 != nil || err2 != nil || err3 != nil || (before > 0 && after > 0) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+		return
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	participant, ok := c.loadParticipant(ctx, userinfo.ID)
+	if !ok {
+		return
+	}
+
+	// 多查一条用来判断是否还有更多
+	query := c.db.Where("conversation_id = ?", participant.ConversationID).Limit(limit + 1)
+	if after > 0 {
+		query = query.Where("id > ?", after).Order("id")
+	} else {
+		if before > 0 {
+			query = query.Where("id < ?", before)
+		}
+		query = query.Order("id DESC")
+	}
+
+	var messages []model2.Message
+	if err := query.Find(&messages).Error; err != nil {
+		log.WithError(err).Errorf("mysql query messages error: %d", participant.ConversationID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	items, err := c.renderMessages(messages)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query message cards error: %d", participant.ConversationID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	var received []string
+	for i := range messages {
+		if messages[i].SenderID != userinfo.ID && !messages[i].Delivered {
+			received = append(received, messages[i].UUID)
+		}
+	}
+	if err := c.markDelivered(ctx0, userinfo.ID, received); err != nil {
+		log.WithError(err).Errorf("mysql mark delivered error: %d", userinfo.ID)
+	}
+
+	// 下一页的游标是本页最后一条消息的 ID
+	var nextCursor uint
+	if hasMore {
+		nextCursor = messages[len(messages)-1].ID
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"items": items, "hasMore": hasMore, "nextCursor": nextCursor}})
+}
+
+// readConversation 移动已读游标，只能向后移动
+func (c *ChatController) readConversation(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	var req api.ReadConversationRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.BindJSON(&req); err != nil {
+			log.WithError(err).Error("read conversation bind json error")
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+			return
+		}
+	}
+
+	participant, ok := c.loadParticipant(ctx, userinfo.ID)
+	if !ok {
+		return
+	}
+
+	var conversation model2.Conversation
+	if err := c.db.First(&conversation, participant.ConversationID).Error; err != nil {
+		abortQuery(ctx, err, "conversation not found")
+		return
+	}
+
+	readID := conversation.LastMessageID
+	if req.MessageID > 0 && req.MessageID < readID {
+		readID = req.MessageID
+	}
+	if readID > participant.LastReadMessageID {
+		if err := c.db.Model(&model2.ConversationParticipant{}).
+			Where("id = ? AND last_read_message_id < ?", participant.ID, readID).
+			Update("last_read_message_id", readID).Error; err != nil {
+			log.WithError(err).Errorf("mysql update read cursor error: %d", participant.ID)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+			return
+		}
+		participant.LastReadMessageID = readID
+	}
+
+	var unread int64
+	if err := c.db.Model(&model2.Message{}).
+		Where("conversation_id = ? AND id > ? AND sender_id <> ?", conversation.ID, participant.LastReadMessageID, userinfo.ID).
+		Count(&unread).Error; err != nil {
+		log.WithError(err).Errorf("mysql count unread error: %d", conversation.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"lastReadId": participant.LastReadMessageID, "unread": unread}})
+}
+
+// issueTicket 浏览器建立 WebSocket 之前先获取一次性凭证，连接时放在 ticket 参数中
+func (c *ChatController) issueTicket(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	ticket, err := c.authController.IssueWebSocketTicket(ctx0, userinfo.ID)
+	if err != nil {
+		log.WithError(err).Errorf("issue websocket ticket error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "issue ticket error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"ticket": ticket, "expiresIn": wsTicketTTL}})
+}
+
+// frameOverhead send 帧中消息内容之外的字段占用的字节数上限
+const frameOverhead = 1024
+
+// serveWs 升级为 WebSocket 连接，新消息实时推送，REST 接口保留作为兜底
+func (c *ChatController) serveWs(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		// Upgrade 失败时已经写好了错误响应
+		log.WithError(err).Errorf("websocket upgrade error: %d", userinfo.ID)
+		return
+	}
+
+	log.Infof("websocket connected: %d", userinfo.ID)
+	// UTF-8 每个字符最多 4 个字节，再留出帧中其他字段的空间
+	chat.NewClient(c.hub, conn, userinfo.ID, c.options.MaxTextLength*4+frameOverhead).Run(c.handleFrame)
+	log.Infof("websocket disconnected: %d", userinfo.ID)
+}
+
+// getPresence 用户是否在线以及最后在线时间
+func (c *ChatController) getPresence(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	online, lastSeen, err := c.presence.Status(ctx0, uint(userID))
+	if err != nil {
+		log.WithError(err).Errorf("redis query presence error: %d", userID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "redis query error"})
+		return
+	}
+
+	result := gin.H{"online": online, "lastSeen": nil}
+	if !lastSeen.IsZero() {
+		result["lastSeen"] = lastSeen
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+// handleFrame 处理客户端发来的 send 和 ack
+func (c *ChatController) handleFrame(client *chat.Client, frame *chat.Frame) {
+	var (
+		ctx0 = context.Background()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	switch frame.Type {
+	case chat.FrameSend:
+		// 和 /chat/send_msg 共用同一个限流窗口
+		if !middleware.Allow(ctx0, c.cacheClient, "send_msg", c.options.SendRateLimit, middleware.UserKey(client.UserID())) {
+			client.Send(&chat.Frame{Type: chat.FrameError, ID: frame.ID, Error: "too many requests"})
+			return
+		}
+		message, err := c.saveMessage(ctx0, client.UserID(), frame.To, frame.ConversationID, &messageInput{Type: frame.MessageType, Content: frame.Content, RefID: frame.RefID})
+		if err != nil {
+			reason := err.Error()
+			if !isMessageError(err) {
+				log.WithError(err).Errorf("mysql create chat error: %d", client.UserID())
+				reason = "mysql create error"
+			}
+			client.Send(&chat.Frame{Type: chat.FrameError, ID: frame.ID, Error: reason})
+			return
+		}
+		client.Send(&chat.Frame{Type: chat.FrameSent, ID: frame.ID, MessageID: message.ID, Message: message})
+
+	case chat.FrameAck:
+		if frame.MessageID == "" {
+			client.Send(&chat.Frame{Type: chat.FrameError, ID: frame.ID, Error: "invalid message id"})
+			return
+		}
+		if err := c.markDelivered(ctx0, client.UserID(), []string{frame.MessageID}); err != nil {
+			log.WithError(err).Errorf("mysql mark delivered error: %s", frame.MessageID)
+			client.Send(&chat.Frame{Type: chat.FrameError, ID: frame.ID, Error: "mysql update error"})
+		}
+
+	default:
+		client.Send(&chat.Frame{Type: chat.FrameError, ID: frame.ID, Error: "unknown frame type"})
+	}
+}
+
+// saveMessage 保存消息并推送给接收方，conversationID 不为 0 时发到指定会话，否则发到与 to 的普通会话
+func (c *ChatController) saveMessage(ctx context.Context, from uint, to string, conversationID uint, input *messageInput) (*chat.Message, error) {
+	var (
+		toID         uint
+		conversation *model2.Conversation
+		err          error
+	)
+
+	if to != "" {
+		id, err := strconv.ParseUint(to, 10, 64)
+		if err != nil || uint(id) == from {
+			return nil, errInvalidRecipient
+		}
+		toID = uint(id)
+	}
+
+	if conversationID > 0 {
+		var peers []conversationPeer
+		if err = c.peersQuery(from).Where("me.conversation_id = ?", conversationID).Scan(&peers).Error; err != nil {
+			return nil, err
+		}
+		if len(peers) == 0 {
+			return nil, errNoConversation
+		}
+		if toID != 0 && toID != peers[0].UserID {
+			return nil, errInvalidRecipient
+		}
+		toID = peers[0].UserID
+	} else if toID == 0 {
+		return nil, errInvalidRecipient
+	}
+
+	exists, err := c.userExists(toID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errInvalidRecipient
+	}
+
+	blocked, err := c.isBlocked(from, toID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errBlocked
+	}
+
+	record, err := c.buildMessage(from, toID, input)
+	if err != nil {
+		return nil, err
+	}
+
+	// 文本消息过审核，手机号、外部链接等可能被打码或拒绝
+	var verdict *moderation.Result
+	if record.Type == model2.MessageText {
+		verdict = c.moderator.Moderate(ctx, moderation.ScopeChat, record.Content)
+		if verdict.Action == moderation.ActionBlock {
+			return nil, errModerated
+		}
+		record.Content = verdict.Text
+	}
+
+	if conversationID > 0 {
+		conversation = &model2.Conversation{}
+		if err = c.db.First(conversation, conversationID).Error; err != nil {
+			return nil, err
+		}
+	} else if conversation, err = c.findOrCreateConversation(from, toID, 0); err != nil {
+		return nil, err
+	}
+
+	record.UUID = uuid.New().String()
+	record.ConversationID = conversation.ID
+	if err = c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model2.Conversation{}).Where("id = ?", conversation.ID).Updates(map[string]interface{}{
+			"last_message_id": record.ID,
+			"last_message_at": record.CreatedAt,
+		}).Error; err != nil {
+			return err
+		}
+		// 回复时视为已读之前的消息，发送方的已读游标移到这条消息
+		return tx.Model(&model2.ConversationParticipant{}).
+			Where("conversation_id = ? AND user_id = ?", conversation.ID, from).
+			Update("last_read_message_id", record.ID).Error
+	}); err != nil {
+		return nil, err
+	}
+	if verdict != nil {
+		flagContent(ctx, c.db, moderation.ScopeChat, from, record.ID, input.Content, verdict)
+	}
+
+	rendered, err := c.renderMessages([]model2.Message{*record})
+	if err != nil {
+		return nil, err
+	}
+	message := &chat.Message{
+		ID:             record.UUID,
+		ConversationID: conversation.ID,
+		From:           strconv.Itoa(int(from)),
+		To:             strconv.Itoa(int(toID)),
+		Type:           rendered[0].Type,
+		Content:        rendered[0].Content,
+		Image:          rendered[0].Image,
+		Goods:          rendered[0].Goods,
+		Order:          rendered[0].Order,
+		CreatedAt:      record.CreatedAt,
+	}
+	c.relay.SendToUser(ctx, toID, &chat.Frame{Type: chat.FrameMessage, Message: message})
+	go c.pushOffline(context.WithoutCancel(ctx), from, toID, record)
+	return message, nil
+}
+
+// pushOffline 接收方不在线时发送离线推送，开启免打扰的会话不推送
+func (c *ChatController) pushOffline(ctx context.Context, from, to uint, record *model2.Message) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if c.pushClient == nil {
+		return
+	}
+	if online, _ := c.presenceOf(ctx, to); online {
+		return
+	}
+
+	var participant model2.ConversationParticipant
+	if err := c.db.Where("conversation_id = ? AND user_id = ?", record.ConversationID, to).First(&participant).Error; err != nil {
+		log.WithError(err).Errorf("mysql query participant error: %d, %d", record.ConversationID, to)
+		return
+	}
+	if participant.Muted {
+		return
+	}
+
+	users, err := c.usersByID([]uint{from})
+	if err != nil {
+		log.WithError(err).Errorf("mysql query user error: %d", from)
+		return
+	}
+	title := "新消息"
+	if sender, ok := users[from]; ok {
+		title = sender.Name
+	}
+
+	body := []rune(messagePreview(record))
+	if len(body) > 100 {
+		body = append(body[:100], '…')
+	}
+
+	c.pushClient.SendToUser(ctx, to, &push.Payload{
+		Title: title,
+		Body:  string(body),
+		Data: map[string]string{
+			"type":           "chat",
+			"conversationId": strconv.Itoa(int(record.ConversationID)),
+			"messageId":      record.UUID,
+		},
+	})
+}
+
+// markDelivered 接收方确认收到，更新消息状态并通知发送方
+func (c *ChatController) markDelivered(ctx context.Context, userID uint, messageIDs []string) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	// 只能确认自己所在会话中别人发的消息，防止伪造送达状态
+	joined := c.db.Model(&model2.ConversationParticipant{}).Select("conversation_id").Where("user_id = ?", userID)
+
+	var messages []model2.Message
+	if err := c.db.Where("uuid IN ? AND delivered = ? AND sender_id <> ? AND conversation_id IN (?)", messageIDs, false, userID, joined).
+		Find(&messages).Error; err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	if err := c.db.Model(&model2.Message{}).Where("id IN ?", ids).Update("delivered", true).Error; err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		c.relay.SendToUser(ctx, message.SenderID, &chat.Frame{Type: chat.FrameDelivered, MessageID: message.UUID})
+	}
+	return nil
+}
+
+// findOrCreateConversation 查找两人之间的会话，不存在时创建会话和双方成员，goodsID 不为 0 时是关于该商品的会话
+func (c *ChatController) findOrCreateConversation(a, b uint, goodsID uint) (*model2.Conversation, error) {
+	key := model2.PairKey(a, b, goodsID)
+
+	var conversation model2.Conversation
+	err := c.db.Where("pair_key = ?", key).First(&conversation).Error
+	if err == nil {
+		return &conversation, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	conversation = model2.Conversation{PairKey: key, GoodsID: goodsID}
+	if err = c.db.Create(&conversation).Error; err != nil {
+		// 并发创建时唯一索引冲突，重新查询
+		if err = c.db.Where("pair_key = ?", key).First(&conversation).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// 并发时另一方可能已经插入了成员，忽略冲突
+	participants := []model2.ConversationParticipant{
+		{ConversationID: conversation.ID, UserID: a},
+		{ConversationID: conversation.ID, UserID: b},
+	}
+	if err = c.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&participants).Error; err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// loadParticipant 当前用户在路径参数指定会话中的成员记录，不是成员时返回 404
+func (c *ChatController) loadParticipant(ctx *gin.Context, userID uint) (*model2.ConversationParticipant, bool) {
+	var participant model2.ConversationParticipant
+	if err := c.db.Where("conversation_id = ? AND user_id = ?", ctx.Param("id"), userID).First(&participant).Error; err != nil {
+		abortQuery(ctx, err, "conversation not found")
+		return nil, false
+	}
+	return &participant, true
+}
+
+// conversationPeer 会话和其中的对方
+type conversationPeer struct {
+	ConversationID    uint
+	UserID            uint
+	LastReadMessageID uint
+	Muted             bool
+	GoodsID           uint
+}
+
+// conversationPeers 用户参与的会话及对方 ID，按最后一条消息时间倒序，limit 为 0 时返回全部
+func (c *ChatController) conversationPeers(userID uint, offset int, limit int) ([]conversationPeer, error) {
+	if limit <= 0 {
+		offset, limit = -1, -1
+	}
+
+	var peers []conversationPeer
+	err := c.peersQuery(userID).
+		Order("conversations.last_message_at IS NULL, conversations.last_message_at DESC, me.conversation_id DESC").
+		Offset(offset).Limit(limit).
+		Scan(&peers).Error
+	return peers, err
+}
+
+// peersQuery 查询用户参与的会话和对方
+func (c *ChatController) peersQuery(userID uint) *gorm.DB {
+	return c.db.Table("conversation_participants AS me").
+		Select("me.conversation_id, peer.user_id, me.last_read_message_id, me.muted, conversations.goods_id").
+		Joins("JOIN conversation_participants AS peer ON peer.conversation_id = me.conversation_id AND peer.user_id <> me.user_id AND peer.deleted_at IS NULL").
+		Joins("JOIN conversations ON conversations.id = me.conversation_id AND conversations.deleted_at IS NULL").
+		Where("me.user_id = ? AND me.deleted_at IS NULL AND peer.user_id NOT IN (?)", userID, c.blockedBy(userID))
+}
+
+// conversations 会话列表，包括对方信息、最后一条消息、未读数和在线状态，每类数据一次查询
+func (c *ChatController) conversations(ctx context.Context, userID uint, peers []conversationPeer) ([]api.Conversation, error) {
+	items := make([]api.Conversation, 0, len(peers))
+	if len(peers) == 0 {
+		return items, nil
+	}
+
+	conversationIDs := make([]uint, 0, len(peers))
+	userIDs := make([]uint, 0, len(peers))
+	var goodsIDs []uint
+	for _, peer := range peers {
+		conversationIDs = append(conversationIDs, peer.ConversationID)
+		userIDs = append(userIDs, peer.UserID)
+		if peer.GoodsID > 0 {
+			goodsIDs = append(goodsIDs, peer.GoodsID)
+		}
+	}
+
+	usersByID, err := c.usersByID(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	goods, err := c.goodsCards(goodsIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var conversations []model2.Conversation
+	if err = c.db.Where("id IN ?", conversationIDs).Find(&conversations).Error; err != nil {
+		return nil, err
+	}
+	lastIDs := make([]uint, 0, len(conversations))
+	for _, conversation := range conversations {
+		if conversation.LastMessageID > 0 {
+			lastIDs = append(lastIDs, conversation.LastMessageID)
+		}
+	}
+	lastMessages := make(map[uint]*api.ChatMessage, len(lastIDs))
+	if len(lastIDs) > 0 {
+		var messages []model2.Message
+		if err = c.db.Where("id IN ?", lastIDs).Find(&messages).Error; err != nil {
+			return nil, err
+		}
+		rendered, err := c.renderMessages(messages)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rendered {
+			lastMessages[rendered[i].ConversationID] = &rendered[i]
+		}
+	}
+
+	var unreadRows []struct {
+		ConversationID uint
+		Unread         int64
+	}
+	if err = c.db.Table("conversation_participants AS me").
+		Select("me.conversation_id, COUNT(messages.id) AS unread").
+		Joins("JOIN messages ON messages.conversation_id = me.conversation_id AND messages.id > me.last_read_message_id AND messages.sender_id <> me.user_id AND messages.deleted_at IS NULL").
+		Where("me.user_id = ? AND me.conversation_id IN ? AND me.deleted_at IS NULL", userID, conversationIDs).
+		Group("me.conversation_id").
+		Scan(&unreadRows).Error; err != nil {
+		return nil, err
+	}
+	unread := make(map[uint]int64, len(unreadRows))
+	for _, row := range unreadRows {
+		unread[row.ConversationID] = row.Unread
+	}
+	presence := c.presencesOf(ctx, userIDs)
+
+	for _, peer := range peers {
+		item := api.Conversation{
+			ID:         peer.ConversationID,
+			Unread:     unread[peer.ConversationID],
+			LastReadID: peer.LastReadMessageID,
+			Muted:      peer.Muted,
+		}
+		if user, ok := usersByID[peer.UserID]; ok {
+			item.Peer = toPublicUser(c.storageClient, user)
+		} else {
+			item.Peer = api.PublicUser{ID: peer.UserID}
+		}
+		item.LastMessage = lastMessages[peer.ConversationID]
+		if peer.GoodsID > 0 {
+			item.Goods = goods[peer.GoodsID]
+			if item.Goods == nil {
+				item.Goods = &api.GoodsCard{ID: peer.GoodsID, Removed: true}
+			}
+		}
+		item.Online, item.LastSeen = presence.of(peer.UserID)
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// userExists 用户存在且未注销
+func (c *ChatController) userExists(userID uint) (bool, error) {
+	var count int64
+	if err := c.db.Model(&model2.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// usersByID 批量查询用户，已注销的用户也要显示为占位名称
+func (c *ChatController) usersByID(ids []uint) (map[uint]*model2.User, error) {
+	usersByID := make(map[uint]*model2.User, len(ids))
+	if len(ids) == 0 {
+		return usersByID, nil
+	}
+
+	var users []model2.User
+	if err := c.db.Unscoped().Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for i := range users {
+		usersByID[users[i].ID] = &users[i]
+	}
+	return usersByID, nil
+}
+
+// peerPresence 会话列表中对方的在线状态，查询失败时都显示为离线
+type peerPresence map[uint]chat.UserStatus
+
+func (p peerPresence) of(userID uint) (bool, *time.Time) {
+	status := p[userID]
+	if status.LastSeen.IsZero() {
+		return status.Online, nil
+	}
+	return status.Online, &status.LastSeen
+}
+
+// presencesOf 批量查询在线状态，避免会话列表每个会话都访问一次 redis
+func (c *ChatController) presencesOf(ctx context.Context, userIDs []uint) peerPresence {
+	statuses, err := c.presence.StatusMany(ctx, userIDs)
+	if err != nil {
+		logger.SmileLog.WithContext(ctx).WithError(err).Errorf("redis query presence error: %d users", len(userIDs))
+		return nil
+	}
+	return statuses
+}
+
+// presenceOf 查询失败时按离线处理
+func (c *ChatController) presenceOf(ctx context.Context, userID uint) (bool, *time.Time) {
+	online, lastSeen, err := c.presence.Status(ctx, userID)
+	if err != nil {
+		logger.SmileLog.WithContext(ctx).WithError(err).Errorf("redis query presence error: %d", userID)
+		return false, nil
+	}
+	if lastSeen.IsZero() {
+		return online, nil
+	}
+	return online, &lastSeen
+}
@@ -1,30 +1,81 @@
 package controller
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
-	"smile.expression/destiny/pkg/database"
-	model2 "smile.expression/destiny/pkg/database/model"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/auth"
+	model2 "smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/notification"
+	"smile.expression/destiny/pkg/storage"
 )
 
+type OrderController struct {
+	options        *OrderControllerOptions
+	r              *gin.Engine
+	db             *gorm.DB
+	storageClient  *storage.Client
+	authController *AuthController
+	notifier       *notification.Notifier
+}
+
+type OrderControllerOptions struct {
+	AutoCompleteInterval int `json:"autoCompleteInterval"` // 检查超时未确认收货订单的间隔（秒），0 表示不自动完成
+	AutoCompleteAfter    int `json:"autoCompleteAfter"`    // 下单超过该时间（秒）自动确认收货，默认 15 天
+}
+
+func NewOrderController(options *OrderControllerOptions, r *gin.Engine, db *gorm.DB, storageClient *storage.Client, authController *AuthController, notifier *notification.Notifier) *OrderController {
+	if options == nil {
+		options = &OrderControllerOptions{}
+	}
+	if options.AutoCompleteAfter <= 0 {
+		options.AutoCompleteAfter = 15 * 24 * 3600
+	}
+
+	return &OrderController{
+		options:        options,
+		r:              r,
+		db:             db,
+		storageClient:  storageClient,
+		authController: authController,
+		notifier:       notifier,
+	}
+}
+
+func (c *OrderController) Register() {
+	rg := c.r.Group("/member", c.authController.AuthMiddleware())
+
+	rg.POST("/order", c.createOrder)
+	rg.GET("/order/:id", c.getOrder)
+	rg.POST("/order/:id/dispute", c.createDispute)
+	rg.POST("/order/:id/confirm", c.confirmOrder)
+	rg.GET("/order/pre", c.getFromCart)
+	rg.GET("/sold_order", c.soldList)
+	rg.GET("/get_order", c.boughtList)
+	rg.GET("/remain", c.saleList)
+}
+
 type OrderInfo struct {
 	Id        string `json:"goodId"` //goods_Id
 	AddressId string `json:"addressId"`
 }
 
-func CreateOrder(ctx *gin.Context) {
+func (c *OrderController) createOrder(ctx *gin.Context) {
 
-	user, isExist := ctx.Get("user")
+	userInfo, isExist := auth.UserFrom(ctx)
 	if !isExist {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
 		return
 	}
-	userInfo := user.(model2.User)
 
 	var orderInfo OrderInfo
 	//绑定结构体,接收body
@@ -35,9 +86,18 @@ func CreateOrder(ctx *gin.Context) {
 		return
 	}
 
+	//收货地址必须是自己的
+	var address model2.UserAddress
+	if err := c.db.Where("id = ? AND user_id = ?", orderInfo.AddressId, userInfo.ID).First(&address).Error; err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid address",
+		})
+		return
+	}
+
 	//生成订单
 	var good model2.Goods
-	DB := database.GetDB()
+	DB := c.db
 	DB.Where("ID=?", orderInfo.Id).Find(&good)
 	if good.IsSold {
 		ctx.JSON(200, gin.H{
@@ -54,6 +114,7 @@ func CreateOrder(ctx *gin.Context) {
 		AddressId: orderInfo.AddressId,
 		UserId:    userInfo.ID,
 		PayMoney:  pMoney,
+		Status:    model2.OrderPending,
 	}
 	if err := DB.Create(&order).Error; err != nil {
 		fmt.Println("插入失败", err)
@@ -61,6 +122,11 @@ func CreateOrder(ctx *gin.Context) {
 	}
 	//fmt.Print(good.Is_Sold)
 
+	//通知卖家
+	if sellerID, err := strconv.ParseUint(good.User, 10, 64); err == nil {
+		c.notifier.Notify(ctx.Request.Context(), uint(sellerID), notification.GoodsSold, order.ID, notification.Data{"GoodsName": good.Name})
+	}
+
 	//返回id
 	ctx.JSON(200, gin.H{
 		"code": "1",
@@ -70,11 +136,11 @@ func CreateOrder(ctx *gin.Context) {
 
 }
 
-func GetOrder(ctx *gin.Context) {
+func (c *OrderController) getOrder(ctx *gin.Context) {
 
 	orderId := ctx.Param("id")
 	fmt.Print(orderId)
-	DB := database.GetDB()
+	DB := c.db
 
 	var Order model2.Order
 	err := DB.Where("ID=?", orderId).Find(&Order)
@@ -97,12 +163,6 @@ func GetOrder(ctx *gin.Context) {
 	fmt.Print(Order.AddressId)
 }
 
-type SendAddress struct {
-	Id       string `json:"id"`
-	Receiver string `json:"receiver"`
-	Contact  string `json:"contact"`
-	Address  string `json:"address"`
-}
 type SendGood struct {
 	Id          string `json:"id"`
 	User        string `json:"user"`
@@ -112,23 +172,27 @@ type SendGood struct {
 	Price       string `json:"price"`
 }
 type Result struct {
-	UserAddresses []SendAddress `json:"userAddresses"`
-	Goods         SendGood      `json:"goods"`
-	Price         string        `json:"price"`
+	UserAddresses    []api.Address `json:"userAddresses"` // 默认地址排在最前
+	DefaultAddressId string        `json:"defaultAddressId"`
+	Goods            SendGood      `json:"goods"`
+	Price            string        `json:"price"`
 }
 
-func GetFromCart(ctx *gin.Context) {
+func (c *OrderController) getFromCart(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
 
 	idStr := ctx.Query("goodID")
 
-	user, isExist := ctx.Get("user")
+	userInfo, isExist := auth.UserFrom(ctx)
 	if !isExist {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
 		return
 	}
-	userInfo := user.(model2.User)
 
-	DB := database.GetDB()
+	DB := c.db
 
 	//获取数据库的相关数据
 	var good model2.Goods
@@ -136,10 +200,11 @@ func GetFromCart(ctx *gin.Context) {
 	if e.Error != nil {
 		fmt.Print(e.Error)
 	}
-	var address []model2.UserAddress
-	e2 := DB.Table("user_addresses").Where("user_id = ?", userInfo.ID).Find(&address)
-	if e2.Error != nil {
-		fmt.Print(e.Error)
+	address, err := listAddresses(DB, userInfo.ID)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query addresses error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query addresses error"})
+		return
 	}
 
 	//填充发送的具体数据
@@ -148,20 +213,16 @@ func GetFromCart(ctx *gin.Context) {
 	sendGood.Name = good.Name
 	sendGood.User = good.User
 	sendGood.Description = good.Description
-	sendGood.Picture = good.Picture
+	sendGood.Picture = c.storageClient.ObjectURL(good.Picture)
 	sendGood.Price = good.Price
 	//
-	addrNum := len(address)
-	sendAddress := make([]SendAddress, addrNum)
-	for i := 0; i < addrNum; i++ {
-		sendAddress[i].Id = strconv.Itoa(int(address[i].ID))
-		sendAddress[i].Receiver = address[i].Receiver
-		sendAddress[i].Contact = address[i].Contact
-		sendAddress[i].Address = address[i].Address
-	}
-	//
 	var result Result
-	result.UserAddresses = sendAddress
+	result.UserAddresses = toAddresses(address)
+	for _, a := range address {
+		if a.IsDefault {
+			result.DefaultAddressId = strconv.Itoa(int(a.ID))
+		}
+	}
 	result.Goods = sendGood
 	result.Price = good.Price
 
@@ -188,23 +249,26 @@ type summary struct {
 	PayMoney  float64
 }
 
-// SoldList 临时收录获取订单记录的所有接口
-func SoldList(c *gin.Context) {
+// soldList 临时收录获取订单记录的所有接口
+func (c *OrderController) soldList(ctx *gin.Context) {
 
-	user, _ := c.Get("user")
-	userinfo := user.(model2.User)
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
 	uId := userinfo.ID
-	db := database.GetDB()
-	p, _ := strconv.Atoi(c.Query("page"))
-	ps, _ := strconv.Atoi(c.Query("pageSize"))
+	db := c.db
+	p, _ := strconv.Atoi(ctx.Query("page"))
+	ps, _ := strconv.Atoi(ctx.Query("pageSize"))
 	var gList []model2.Goods
 	if err := db.Table("goods").Where("user = ? AND is_sold = ?", uId, 1).Find(&gList).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(404, gin.H{
+			ctx.JSON(404, gin.H{
 				"err warning": "Record of good no found",
 			})
 		} else {
-			c.JSON(500, gin.H{
+			ctx.JSON(500, gin.H{
 				"error warning": "unknown error",
 			})
 		}
@@ -221,7 +285,7 @@ func SoldList(c *gin.Context) {
 		b := (p - 1) * ps
 		e := b + ps
 		if (b + 1) > count {
-			c.JSON(200, gin.H{
+			ctx.JSON(200, gin.H{
 				"count":  count,
 				"result": result,
 			})
@@ -232,13 +296,13 @@ func SoldList(c *gin.Context) {
 				r.CreatTime = oList[i].CreatedAt.Format("2006-01-02 15:04:05")
 				r.Skus.Id = gList[i].ID
 				r.Skus.Name = gList[i].Name
-				r.Skus.Image = gList[i].Picture
+				r.Skus.Image = c.storageClient.ObjectURL(gList[i].Picture)
 				r.Skus.AttrsText = gList[i].Description
 				r.Skus.RealPay, _ = strconv.ParseFloat(gList[i].Price, 64)
 				r.PayMoney, _ = strconv.ParseFloat(gList[i].Price, 64)
 				result = append(result, r)
 			}
-			c.JSON(200, gin.H{
+			ctx.JSON(200, gin.H{
 				"count":  count,
 				"result": result,
 			})
@@ -247,22 +311,25 @@ func SoldList(c *gin.Context) {
 
 }
 
-func BoughtList(c *gin.Context) {
+func (c *OrderController) boughtList(ctx *gin.Context) {
 
-	user, _ := c.Get("user")
-	userinfo := user.(model2.User)
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
 	uId := userinfo.ID
-	db := database.GetDB()
-	p, _ := strconv.Atoi(c.Query("page"))
-	ps, _ := strconv.Atoi(c.Query("pageSize"))
+	db := c.db
+	p, _ := strconv.Atoi(ctx.Query("page"))
+	ps, _ := strconv.Atoi(ctx.Query("pageSize"))
 	var oList []model2.Order
 	if err := db.Table("orders").Where("user_id", uId).Find(&oList).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(404, gin.H{
+			ctx.JSON(404, gin.H{
 				"err warning": "Record of good no found",
 			})
 		} else {
-			c.JSON(500, gin.H{
+			ctx.JSON(500, gin.H{
 				"error warning": "unknown error",
 			})
 		}
@@ -280,7 +347,7 @@ func BoughtList(c *gin.Context) {
 		e := b + ps
 		println(e)
 		if (b + 1) > count {
-			c.JSON(200, gin.H{
+			ctx.JSON(200, gin.H{
 				"count":  count,
 				"result": result,
 			})
@@ -292,7 +359,7 @@ func BoughtList(c *gin.Context) {
 				r.CreatTime = oList[i].CreatedAt.Format("2006-01-02 15:04:05")
 				r.Skus.Id = gList[i].ID
 				r.Skus.Name = gList[i].Name
-				r.Skus.Image = gList[i].Picture
+				r.Skus.Image = c.storageClient.ObjectURL(gList[i].Picture)
 				r.Skus.AttrsText = gList[i].Description
 				r.Skus.RealPay, _ = strconv.ParseFloat(gList[i].Price, 64)
 				r.PayMoney, _ = strconv.ParseFloat(gList[i].Price, 64)
@@ -301,7 +368,7 @@ func BoughtList(c *gin.Context) {
 
 			}
 
-			c.JSON(200, gin.H{
+			ctx.JSON(200, gin.H{
 				"count":  count,
 				"result": result,
 			})
@@ -315,22 +382,25 @@ type summary2 struct {
 	Skus      gif
 }
 
-func SaleList(c *gin.Context) {
+func (c *OrderController) saleList(ctx *gin.Context) {
 
-	user, _ := c.Get("user")
-	userinfo := user.(model2.User)
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
 	uId := userinfo.ID
-	db := database.GetDB()
-	p, _ := strconv.Atoi(c.Query("page"))
-	ps, _ := strconv.Atoi(c.Query("pageSize"))
+	db := c.db
+	p, _ := strconv.Atoi(ctx.Query("page"))
+	ps, _ := strconv.Atoi(ctx.Query("pageSize"))
 	var gList []model2.Goods
 	if err := db.Table("goods").Where("user = ? AND is_sold = ?", uId, 0).Find(&gList).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(404, gin.H{
+			ctx.JSON(404, gin.H{
 				"err warning": "Record of good no found",
 			})
 		} else {
-			c.JSON(500, gin.H{
+			ctx.JSON(500, gin.H{
 				"error warning": "unknown error",
 			})
 		}
@@ -340,7 +410,7 @@ func SaleList(c *gin.Context) {
 		b := (p - 1) * ps
 		e := b + ps
 		if (b + 1) > count {
-			c.JSON(200, gin.H{
+			ctx.JSON(200, gin.H{
 				"count":  count,
 				"result": result,
 			})
@@ -350,12 +420,12 @@ func SaleList(c *gin.Context) {
 				r.CreatTime = gList[i].CreatedAt.Format("2006-01-02 15:04:05")
 				r.Skus.Id = gList[i].ID
 				r.Skus.Name = gList[i].Name
-				r.Skus.Image = gList[i].Picture
+				r.Skus.Image = c.storageClient.ObjectURL(gList[i].Picture)
 				r.Skus.AttrsText = gList[i].Description
 				r.Skus.RealPay, _ = strconv.ParseFloat(gList[i].Price, 64)
 				result = append(result, r)
 			}
-			c.JSON(200, gin.H{
+			ctx.JSON(200, gin.H{
 				"count":  count,
 				"result": result,
 			})
@@ -363,3 +433,198 @@ func SaleList(c *gin.Context) {
 	}
 
 }
+
+// confirmOrder 买家确认收货，订单完成后双方可以互相评价
+func (c *OrderController) confirmOrder(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not exist"})
+		return
+	}
+
+	var order model2.Order
+	if err := c.db.First(&order, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "order not found")
+		return
+	}
+
+	if order.UserId != userInfo.ID {
+		log.Errorf("confirm order forbidden: %d, order: %d", userInfo.ID, order.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return
+	}
+
+	result := c.db.Model(&order).Where("status = ?", model2.OrderPending).Update("status", model2.OrderCompleted)
+	if result.Error != nil {
+		log.WithError(result.Error).Errorf("mysql update order error: %d", order.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "order already completed"})
+		return
+	}
+
+	// 通知卖家可以评价买家了
+	var good model2.Goods
+	if err := c.db.Unscoped().Where("id = ?", order.GoodId).First(&good).Error; err != nil {
+		log.WithError(err).Errorf("mysql query goods error: %s", order.GoodId)
+	} else if sellerID, err := strconv.ParseUint(good.User, 10, 64); err == nil {
+		c.notifier.Notify(ctx0, uint(sellerID), notification.OrderCompleted, order.ID, notification.Data{"GoodsName": good.Name})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// RunAutoComplete 定期把超时未确认收货的订单自动完成。
+// 升级前的订单在迁移时已经标记为已完成，这里的待收货订单都会通知买卖双方
+func (c *OrderController) RunAutoComplete() {
+	if c.options.AutoCompleteInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(c.options.AutoCompleteInterval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.autoComplete(context.Background())
+	}
+}
+
+// autoComplete 每批 100 个，直到没有超时的订单
+func (c *OrderController) autoComplete(ctx context.Context) {
+	var (
+		log      = logger.SmileLog.WithContext(ctx)
+		deadline = time.Now().Add(-time.Duration(c.options.AutoCompleteAfter) * time.Second)
+		lastID   uint
+	)
+
+	for {
+		var orders []model2.Order
+		if err := c.db.Where("status = ? AND created_at < ? AND id > ?", model2.OrderPending, deadline, lastID).
+			Order("id").Limit(100).Find(&orders).Error; err != nil {
+			log.WithError(err).Error("mysql query unconfirmed orders error")
+			return
+		}
+		if len(orders) == 0 {
+			return
+		}
+		lastID = orders[len(orders)-1].ID
+
+		goods, err := orderGoods(c.db, orders)
+		if err != nil {
+			log.WithError(err).Error("mysql query goods error")
+			return
+		}
+
+		for _, order := range orders {
+			// 买家可能刚好在此时确认收货
+			result := c.db.Model(&order).Where("status = ?", model2.OrderPending).Update("status", model2.OrderCompleted)
+			if result.Error != nil {
+				log.WithError(result.Error).Errorf("mysql complete order error: %d", order.ID)
+				continue
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			log.Infof("order auto completed: %d", order.ID)
+
+			good, ok := goods[order.GoodId]
+			if !ok {
+				continue
+			}
+			data := notification.Data{"GoodsName": good.Name}
+			c.notifier.Notify(ctx, order.UserId, notification.OrderAutoCompleted, order.ID, data)
+			if sellerID, err := strconv.ParseUint(good.User, 10, 64); err == nil {
+				c.notifier.Notify(ctx, uint(sellerID), notification.OrderAutoCompleted, order.ID, data)
+			}
+		}
+	}
+}
+
+// orderGoods 订单对应的商品，包括已下架的，key 为订单中的商品 ID
+func orderGoods(db *gorm.DB, orders []model2.Order) (map[string]model2.Goods, error) {
+	goodsIDs := make([]string, 0, len(orders))
+	for _, order := range orders {
+		goodsIDs = append(goodsIDs, order.GoodId)
+	}
+
+	var goods []model2.Goods
+	if err := db.Unscoped().Where("id IN ?", goodsIDs).Find(&goods).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[string]model2.Goods, len(goods))
+	for _, g := range goods {
+		result[strconv.Itoa(int(g.ID))] = g
+	}
+	return result, nil
+}
+
+// createDispute 买家或卖家对订单发起纠纷，交给管理员处理
+func (c *OrderController) createDispute(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not exist"})
+		return
+	}
+
+	var req api.CreateDisputeRequest
+	if err := ctx.BindJSON(&req); err != nil || req.Reason == "" {
+		log.WithError(err).Error("create dispute bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid reason"})
+		return
+	}
+
+	var order model2.Order
+	if err := c.db.First(&order, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "order not found")
+		return
+	}
+
+	var good model2.Goods
+	if err := c.db.Unscoped().Where("id = ?", order.GoodId).First(&good).Error; err != nil {
+		abortQuery(ctx, err, "goods not found")
+		return
+	}
+
+	if order.UserId != userInfo.ID && good.User != strconv.Itoa(int(userInfo.ID)) {
+		log.Errorf("dispute forbidden: %d, order: %d", userInfo.ID, order.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return
+	}
+
+	var openCount int64
+	if err := c.db.Model(&model2.Dispute{}).Where("order_id = ? AND status = ?", order.ID, model2.DisputeOpen).Count(&openCount).Error; err != nil {
+		log.WithError(err).Errorf("mysql count dispute error: %d", order.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if openCount > 0 {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "dispute already open"})
+		return
+	}
+
+	dispute := model2.Dispute{
+		OrderID: order.ID,
+		UserID:  userInfo.ID,
+		Reason:  req.Reason,
+		Status:  model2.DisputeOpen,
+	}
+	if err := c.db.Create(&dispute).Error; err != nil {
+		log.WithError(err).Errorf("mysql create dispute error: %d", order.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": dispute})
+}
@@ -0,0 +1,345 @@
+package controller
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/auth"
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/storage"
+)
+
+// AccountController 个人数据导出和注销账号
+type AccountController struct {
+	r                 *gin.Engine
+	db                *gorm.DB
+	storageClient     *storage.Client
+	authController    *AuthController
+	storageController *StorageController
+}
+
+func NewAccountController(r *gin.Engine, db *gorm.DB, storageClient *storage.Client, authController *AuthController, storageController *StorageController) *AccountController {
+	return &AccountController{
+		r:                 r,
+		db:                db,
+		storageClient:     storageClient,
+		authController:    authController,
+		storageController: storageController,
+	}
+}
+
+func (c *AccountController) Register() {
+	rg := c.r.Group("/member", c.authController.AuthMiddleware())
+
+	rg.GET("/export", c.export)
+	rg.POST("/delete_account", c.deleteAccount)
+}
+
+// exportFile 导出压缩包中的一个 json 文件
+type exportFile struct {
+	name string
+	data interface{}
+}
+
+// export 把用户的个人数据打包成 zip 下载，上传的图片放在 images 目录
+func (c *AccountController) export(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	// 先查出所有数据，开始写响应之后就不能再返回错误状态码了
+	files, objects, err := c.collect(userInfo)
+	if err != nil {
+		log.WithError(err).Errorf("collect export data error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	filename := fmt.Sprintf("destiny-export-%d-%s.zip", userInfo.ID, time.Now().Format("20060102"))
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	ctx.Status(http.StatusOK)
+
+	archive := zip.NewWriter(ctx.Writer)
+	defer func() {
+		if err := archive.Close(); err != nil {
+			log.WithError(err).Errorf("close export archive error: %d", userInfo.ID)
+		}
+	}()
+
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.data, "", "  ")
+		if err != nil {
+			log.WithError(err).Errorf("marshal export file error: %s", file.name)
+			return
+		}
+		w, err := archive.Create(file.name)
+		if err != nil {
+			log.WithError(err).Errorf("create export file error: %s", file.name)
+			return
+		}
+		if _, err = w.Write(data); err != nil {
+			log.WithError(err).Errorf("write export file error: %s", file.name)
+			return
+		}
+	}
+
+	// 单个图片读取失败时跳过，不影响其余数据
+	for _, object := range objects {
+		if err = c.writeObject(ctx, archive, object); err != nil {
+			log.WithError(err).Errorf("export object error: %s/%s", object.Bucket, object.Object)
+		}
+	}
+}
+
+func (c *AccountController) collect(user *model.User) ([]exportFile, []model.StorageObject, error) {
+	userID := strconv.Itoa(int(user.ID))
+
+	addresses, err := listAddresses(c.db, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var listings []model.Goods
+	if err = c.db.Unscoped().Where("user = ?", userID).Find(&listings).Error; err != nil {
+		return nil, nil, err
+	}
+	goodsIDs := make([]string, 0, len(listings))
+	for i := range listings {
+		goodsIDs = append(goodsIDs, strconv.Itoa(int(listings[i].ID)))
+		listings[i].Picture = c.storageClient.ObjectURL(listings[i].Picture)
+	}
+
+	var bought []model.Order
+	if err = c.db.Where("user_id = ?", user.ID).Find(&bought).Error; err != nil {
+		return nil, nil, err
+	}
+	var sold []model.Order
+	if len(goodsIDs) > 0 {
+		if err = c.db.Where("good_id IN ?", goodsIDs).Find(&sold).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	joined := c.db.Model(&model.ConversationParticipant{}).Select("conversation_id").Where("user_id = ?", user.ID)
+	var messages []model.Message
+	if err = c.db.Where("conversation_id IN (?)", joined).Order("id").Find(&messages).Error; err != nil {
+		return nil, nil, err
+	}
+	chats := make([]api.ChatMessage, 0, len(messages))
+	for i := range messages {
+		chats = append(chats, toChatMessage(&messages[i]))
+	}
+
+	var given, received []model.Review
+	if err = c.db.Where("reviewer_id = ?", user.ID).Find(&given).Error; err != nil {
+		return nil, nil, err
+	}
+	if err = c.db.Where("reviewee_id = ?", user.ID).Find(&received).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var objects []model.StorageObject
+	if err = c.db.Where("user_id = ?", user.ID).Find(&objects).Error; err != nil {
+		return nil, nil, err
+	}
+
+	files := []exportFile{
+		{name: "profile.json", data: toProfile(c.storageClient, user)},
+		{name: "addresses.json", data: toAddresses(addresses)},
+		{name: "listings.json", data: listings},
+		{name: "orders.json", data: gin.H{"bought": bought, "sold": sold}},
+		{name: "chats.json", data: chats},
+		{name: "reviews.json", data: gin.H{"given": given, "received": received}},
+	}
+	return files, objects, nil
+}
+
+func (c *AccountController) writeObject(ctx *gin.Context, archive *zip.Writer, object model.StorageObject) error {
+	reader, _, err := c.storageClient.GetObject(ctx.Request.Context(), object.Bucket, object.Object)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	w, err := archive.Create(path.Join("images", object.Bucket, object.Object))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+// deleteAccount 注销账号：匿名化并软删除用户，删除地址、在售商品、购物车和推送设备，释放头像和在售商品图片，
+// 订单、已售商品和聊天记录保留给交易对方
+func (c *AccountController) deleteAccount(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	var req api.DeleteAccountRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		log.WithError(err).Error("delete account bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	// 注销不可恢复，需要再次输入密码
+	if err := bcrypt.CompareHashAndPassword([]byte(userInfo.Password), []byte(req.Password)); err != nil {
+		log.WithError(err).Errorf("delete account password error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "password error"})
+		return
+	}
+
+	// 作为买家或卖家还有未完成的订单时不能注销
+	userID := strconv.Itoa(int(userInfo.ID))
+	sellerGoods := c.db.Unscoped().Model(&model.Goods{}).Select("id").Where("user = ?", userID)
+
+	var pending int64
+	if err := c.db.Model(&model.Order{}).
+		Where("status = ? AND (user_id = ? OR good_id IN (?))", model.OrderPending, userInfo.ID, sellerGoods).
+		Count(&pending).Error; err != nil {
+		log.WithError(err).Errorf("mysql count orders error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if pending > 0 {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "pending orders must be completed first"})
+		return
+	}
+
+	images, err := c.imagesToRelease(userInfo)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query images error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	var released []model.StorageObject
+	if err = c.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if released, err = c.releaseImages(tx, userInfo, images); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userInfo.ID).Delete(&model.UserAddress{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user = ? AND is_sold = ?", userID, false).Delete(&model.Goods{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&model.Cart{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userInfo.ID).Delete(&model.DeviceToken{}).Error; err != nil {
+			return err
+		}
+
+		// 手机号改为占位值，原号码可以重新注册
+		if err := tx.Model(userInfo).Updates(map[string]interface{}{
+			"name":      "已注销用户",
+			"telephone": fmt.Sprintf("deleted_%d", userInfo.ID),
+			"password":  "",
+			"gender":    "",
+			"avatar":    "",
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.User{}, userInfo.ID).Error
+	}); err != nil {
+		log.WithError(err).Errorf("mysql delete account error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql delete error"})
+		return
+	}
+
+	c.authController.InvalidateUser(ctx0, userInfo.ID)
+	if err := c.authController.RevokeAll(ctx0, userInfo.ID); err != nil {
+		log.WithError(err).Errorf("revoke all tokens error: %d", userInfo.ID)
+	}
+
+	// 记录已经删除，对象删除失败只能留在存储中，不影响注销
+	for _, object := range released {
+		if err := c.storageClient.RemoveObject(ctx0, object.Bucket, object.Object); err != nil {
+			log.WithError(err).Errorf("remove object error: %s/%s", object.Bucket, object.Object)
+		}
+	}
+	log.Infof("account deleted: %d", userInfo.ID)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// imagesToRelease 注销时要释放的图片：头像和在售商品的图片，已售商品的图片还要给买家看
+func (c *AccountController) imagesToRelease(user *model.User) ([]string, error) {
+	var goods []model.Goods
+	if err := c.db.Where("user = ? AND is_sold = ?", strconv.Itoa(int(user.ID)), false).Find(&goods).Error; err != nil {
+		return nil, err
+	}
+
+	keys := []string{user.Avatar}
+	goodsIDs := make([]string, 0, len(goods))
+	for _, g := range goods {
+		keys = append(keys, g.Picture)
+		goodsIDs = append(goodsIDs, strconv.Itoa(int(g.ID)))
+	}
+
+	if len(goodsIDs) > 0 {
+		var pictures []model.Picture
+		if err := c.db.Where("good_id IN ?", goodsIDs).Find(&pictures).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range pictures {
+			keys = append(keys, p.Picture1, p.Picture2, p.Picture3, p.Picture4, p.Picture5)
+		}
+	}
+	return keys, nil
+}
+
+// releaseImages 释放用户对这些图片的引用，返回已经没有引用、需要从存储中删除的对象；
+// 外部地址和不属于该用户的对象跳过
+func (c *AccountController) releaseImages(tx *gorm.DB, user *model.User, keys []string) ([]model.StorageObject, error) {
+	var (
+		released []model.StorageObject
+		seen     = make(map[string]bool, len(keys))
+	)
+
+	for _, key := range keys {
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		bucketName, objectName, err := c.storageClient.ParseURL(key)
+		if err != nil {
+			continue
+		}
+		ok, err := c.storageController.releaseObject(tx, user, false, bucketName, objectName)
+		if errors.Is(err, errObjectForbidden) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			released = append(released, model.StorageObject{Bucket: bucketName, Object: objectName})
+		}
+	}
+	return released, nil
+}
@@ -0,0 +1,407 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/auth"
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/logger"
+)
+
+// contactPattern 收件人手机号，与账号一样使用 11 位大陆手机号
+var contactPattern = regexp.MustCompile(`^1[3-9]\d{9}$`)
+
+var errInvalidAddress = errors.New("invalid address")
+
+// AddressController 收货地址管理，所有操作都只作用于当前用户自己的地址
+type AddressController struct {
+	r              *gin.Engine
+	db             *gorm.DB
+	authController *AuthController
+}
+
+func NewAddressController(r *gin.Engine, db *gorm.DB, authController *AuthController) *AddressController {
+	return &AddressController{
+		r:              r,
+		db:             db,
+		authController: authController,
+	}
+}
+
+func (c *AddressController) Register() {
+	rg := c.r.Group("/member", c.authController.AuthMiddleware())
+
+	rg.GET("/addresses", c.list)
+	rg.POST("/addresses", c.create)
+	rg.PUT("/addresses/:id", c.update)
+	rg.DELETE("/addresses/:id", c.delete)
+	rg.POST("/addresses/:id/default", c.setDefault)
+
+	// 旧接口，返回格式保持不变
+	rg.POST("/add_address", c.legacyAdd)
+	rg.POST("/del_address", c.legacyDelete)
+}
+
+func (c *AddressController) list(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	addresses, err := listAddresses(c.db, userInfo.ID)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query user address error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": toAddresses(addresses)})
+}
+
+func (c *AddressController) create(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	var req api.AddressRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		log.WithError(err).Error("create address bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	address, err := c.createAddress(userInfo.ID, &req)
+	if err != nil {
+		log.WithError(err).Errorf("create address error: %d", userInfo.ID)
+		abortAddress(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": toAddress(address)})
+}
+
+func (c *AddressController) update(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	var req api.AddressRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		log.WithError(err).Error("update address bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	fields, err := addressFields(&req)
+	if err != nil {
+		abortAddress(ctx, err)
+		return
+	}
+
+	address, ok := c.loadOwnAddress(ctx, userInfo.ID)
+	if !ok {
+		return
+	}
+
+	if err = c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(address).Updates(map[string]interface{}{
+			"receiver": fields.Receiver,
+			"contact":  fields.Contact,
+			"province": fields.Province,
+			"city":     fields.City,
+			"district": fields.District,
+			"detail":   fields.Detail,
+			"address":  fields.Address,
+		}).Error; err != nil {
+			return err
+		}
+		if req.IsDefault {
+			return markDefault(tx, userInfo.ID, address.ID)
+		}
+		return nil
+	}); err != nil {
+		log.WithError(err).Errorf("mysql update address error: %d", address.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AddressController) delete(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	address, ok := c.loadOwnAddress(ctx, userInfo.ID)
+	if !ok {
+		return
+	}
+
+	if err := c.deleteAddress(address); err != nil {
+		log.WithError(err).Errorf("mysql delete address error: %d", address.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql delete error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AddressController) setDefault(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	address, ok := c.loadOwnAddress(ctx, userInfo.ID)
+	if !ok {
+		return
+	}
+
+	if err := c.db.Transaction(func(tx *gorm.DB) error {
+		return markDefault(tx, userInfo.ID, address.ID)
+	}); err != nil {
+		log.WithError(err).Errorf("mysql set default address error: %d", address.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AddressController) legacyAdd(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	var req api.AddressRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(422, gin.H{"code": 422, "msg": "获取失败"})
+		return
+	}
+
+	if _, err := c.createAddress(userInfo.ID, &req); err != nil {
+		log.WithError(err).Errorf("create address error: %d", userInfo.ID)
+		if errors.Is(err, errInvalidAddress) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "地址信息不完整，请重新填写"})
+		} else {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "新增收货地址失败"})
+		}
+		return
+	}
+
+	c.legacyRespond(ctx, userInfo.ID, "新增收货地址成功")
+}
+
+func (c *AddressController) legacyDelete(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	addressID := ctx.Query("id")
+	if len(addressID) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "id为空"})
+		return
+	}
+
+	// 只能删除自己的地址，别人的地址按不存在处理
+	var address model.UserAddress
+	if err := c.db.Where("id = ? AND user_id = ?", addressID, userInfo.ID).First(&address).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusOK, gin.H{"code": 200, "msg": "该记录不存在"})
+		} else {
+			log.WithError(err).Errorf("mysql query address error: %s", addressID)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "删除收货地址失败"})
+		}
+		return
+	}
+
+	if err := c.deleteAddress(&address); err != nil {
+		log.WithError(err).Errorf("mysql delete address error: %d", address.ID)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "删除收货地址失败"})
+		return
+	}
+
+	c.legacyRespond(ctx, userInfo.ID, "删除收货地址成功")
+}
+
+func (c *AddressController) legacyRespond(ctx *gin.Context, userID uint, msg string) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	addresses, err := listAddresses(c.db, userID)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query user address error: %d", userID)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code":          200,
+		"msg":           msg,
+		"userAddresses": toAddresses(addresses),
+	})
+}
+
+// createAddress 新建地址，用户的第一个地址自动成为默认地址
+func (c *AddressController) createAddress(userID uint, req *api.AddressRequest) (*model.UserAddress, error) {
+	address, err := addressFields(req)
+	if err != nil {
+		return nil, err
+	}
+	address.UserID = userID
+
+	if err = c.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&model.UserAddress{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(address).Error; err != nil {
+			return err
+		}
+		if req.IsDefault || count == 0 {
+			address.IsDefault = true
+			return markDefault(tx, userID, address.ID)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// deleteAddress 删除地址，删除的是默认地址时把最近添加的地址设为默认
+func (c *AddressController) deleteAddress(address *model.UserAddress) error {
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(address).Error; err != nil {
+			return err
+		}
+		if !address.IsDefault {
+			return nil
+		}
+
+		var next model.UserAddress
+		if err := tx.Where("user_id = ?", address.UserID).Order("id DESC").First(&next).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		return markDefault(tx, address.UserID, next.ID)
+	})
+}
+
+func (c *AddressController) loadOwnAddress(ctx *gin.Context, userID uint) (*model.UserAddress, bool) {
+	var address model.UserAddress
+	if err := c.db.Where("id = ? AND user_id = ?", ctx.Param("id"), userID).First(&address).Error; err != nil {
+		abortQuery(ctx, err, "address not found")
+		return nil, false
+	}
+	return &address, true
+}
+
+// markDefault 把地址设为默认，同一用户只有一个默认地址
+func markDefault(tx *gorm.DB, userID uint, addressID uint) error {
+	if err := tx.Model(&model.UserAddress{}).Where("user_id = ? AND id <> ?", userID, addressID).Update("is_default", false).Error; err != nil {
+		return err
+	}
+	return tx.Model(&model.UserAddress{}).Where("id = ? AND user_id = ?", addressID, userID).Update("is_default", true).Error
+}
+
+// addressFields 校验请求并生成地址，提交了结构化字段时完整地址由它们拼接
+func addressFields(req *api.AddressRequest) (*model.UserAddress, error) {
+	address := &model.UserAddress{
+		Receiver: strings.TrimSpace(req.Receiver),
+		Contact:  strings.TrimSpace(req.Contact),
+		Province: strings.TrimSpace(req.Province),
+		City:     strings.TrimSpace(req.City),
+		District: strings.TrimSpace(req.District),
+		Detail:   strings.TrimSpace(req.Detail),
+		Address:  strings.TrimSpace(req.Address),
+	}
+
+	if address.Receiver == "" || utf8.RuneCountInString(address.Receiver) > 50 {
+		return nil, errInvalidAddress
+	}
+	if !contactPattern.MatchString(address.Contact) {
+		return nil, errInvalidAddress
+	}
+
+	if address.Detail != "" {
+		if address.Province == "" || address.City == "" {
+			return nil, errInvalidAddress
+		}
+		address.Address = address.Province + address.City + address.District + address.Detail
+	}
+	if address.Address == "" || utf8.RuneCountInString(address.Address) > 1024 {
+		return nil, errInvalidAddress
+	}
+	return address, nil
+}
+
+// listAddresses 用户的所有地址，默认地址排在最前
+func listAddresses(db *gorm.DB, userID uint) ([]model.UserAddress, error) {
+	var addresses []model.UserAddress
+	if err := db.Where("user_id = ?", userID).Order("is_default DESC, id DESC").Find(&addresses).Error; err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+func toAddress(address *model.UserAddress) api.Address {
+	return api.Address{
+		AddressID: strconv.Itoa(int(address.ID)),
+		Receiver:  address.Receiver,
+		Contact:   address.Contact,
+		Province:  address.Province,
+		City:      address.City,
+		District:  address.District,
+		Detail:    address.Detail,
+		Address:   address.Address,
+		IsDefault: address.IsDefault,
+	}
+}
+
+func toAddresses(addresses []model.UserAddress) []api.Address {
+	result := make([]api.Address, 0, len(addresses))
+	for i := range addresses {
+		result = append(result, toAddress(&addresses[i]))
+	}
+	return result
+}
+
+func abortAddress(ctx *gin.Context, err error) {
+	if errors.Is(err, errInvalidAddress) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid address"})
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql error"})
+}
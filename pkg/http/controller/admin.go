@@ -0,0 +1,810 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/auth"
+	"smile.expression/destiny/pkg/cache"
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/notification"
+	"smile.expression/destiny/pkg/storage"
+)
+
+// AdminController 后台管理接口，所有接口都需要登录并拥有对应权限
+type AdminController struct {
+	r              *gin.Engine
+	db             *gorm.DB
+	cacheClient    *cache.Client
+	storageClient  *storage.Client
+	authController *AuthController
+	notifier       *notification.Notifier
+}
+
+func NewAdminController(r *gin.Engine, db *gorm.DB, cacheClient *cache.Client, storageClient *storage.Client, authController *AuthController, notifier *notification.Notifier) *AdminController {
+	return &AdminController{
+		r:              r,
+		db:             db,
+		cacheClient:    cacheClient,
+		storageClient:  storageClient,
+		authController: authController,
+		notifier:       notifier,
+	}
+}
+
+func (c *AdminController) Register() {
+	rg := c.r.Group("/admin", c.authController.AuthMiddleware())
+
+	users := rg.Group("/users", c.authController.RequirePermission(auth.PermManageUsers))
+	users.GET("", c.listUsers)
+	users.POST("/:id/ban", c.banUser)
+	users.POST("/:id/unban", c.unbanUser)
+	rg.PUT("/users/:id/role", c.authController.RequirePermission(auth.PermManageRoles), c.updateRole)
+
+	goods := rg.Group("/goods", c.authController.RequirePermission(auth.PermManageGoods))
+	goods.POST("/:id/takedown", c.takedownGoods)
+	goods.POST("/:id/restore", c.restoreGoods)
+
+	categories := rg.Group("/categories", c.authController.RequirePermission(auth.PermManageCategories))
+	categories.GET("", c.listCategories)
+	categories.POST("", c.createCategory)
+	categories.PUT("/:id", c.updateCategory)
+	categories.DELETE("/:id", c.deleteCategory)
+
+	banners := rg.Group("/banners", c.authController.RequirePermission(auth.PermManageBanners))
+	banners.GET("", c.listBanners)
+	banners.POST("", c.createBanner)
+	banners.PUT("/:id", c.updateBanner)
+	banners.DELETE("/:id", c.deleteBanner)
+
+	disputes := rg.Group("/disputes", c.authController.RequirePermission(auth.PermManageDisputes))
+	disputes.GET("", c.listDisputes)
+	disputes.POST("/:id/resolve", c.resolveDispute)
+
+	reports := rg.Group("/reports", c.authController.RequirePermission(auth.PermManageReports))
+	reports.GET("", c.listReports)
+	reports.POST("/:id/resolve", c.resolveReport)
+
+	flags := rg.Group("/flags", c.authController.RequirePermission(auth.PermManageReports))
+	flags.GET("", c.listFlags)
+	flags.POST("/:id/resolve", c.resolveFlag)
+}
+
+func (c *AdminController) listUsers(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	page, size := pageParams(ctx)
+	query := c.db.Model(&model.User{})
+	if keyword := ctx.Query("keyword"); keyword != "" {
+		query = query.Where("telephone = ? OR name LIKE ?", keyword, "%"+keyword+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithError(err).Error("mysql count users error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	var users []model.User
+	if err := query.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&users).Error; err != nil {
+		log.WithError(err).Error("mysql query users error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	items := make([]api.AdminUser, len(users))
+	for i := range users {
+		items[i] = toAdminUser(c.storageClient, &users[i])
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"total": total, "items": items}})
+}
+
+func (c *AdminController) banUser(ctx *gin.Context) {
+	var req api.BanUserRequest
+	// 理由可以不填
+	_ = ctx.ShouldBindJSON(&req)
+	c.setBanned(ctx, true, req.Reason)
+}
+
+func (c *AdminController) unbanUser(ctx *gin.Context) {
+	c.setBanned(ctx, false, "")
+}
+
+func (c *AdminController) setBanned(ctx *gin.Context, banned bool, reason string) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+	target, ok := c.loadTargetUser(ctx)
+	if !ok {
+		return
+	}
+
+	// 不能封禁自己，封禁其他管理员需要管理角色的权限
+	if target.ID == admin.ID || (target.Role != model.RoleUser && !auth.HasPermission(admin, auth.PermManageRoles)) {
+		log.Errorf("ban user forbidden: %d -> %d", admin.ID, target.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return
+	}
+
+	if err := c.db.Model(target).Update("banned", banned).Error; err != nil {
+		log.WithError(err).Errorf("mysql update user error: %d", target.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+	c.authController.InvalidateUser(ctx0, target.ID)
+
+	action := "unban_user"
+	if banned {
+		action = "ban_user"
+		// 封禁后立即让已登录的设备下线
+		if err := c.authController.RevokeAll(ctx0, target.ID); err != nil {
+			log.WithError(err).Errorf("revoke all tokens error: %d", target.ID)
+		}
+	}
+	c.audit(ctx0, admin, action, fmt.Sprintf("user:%d", target.ID), reason)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) updateRole(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var req api.UpdateRoleRequest
+	if err := ctx.BindJSON(&req); err != nil || !auth.ValidRole(req.Role) {
+		log.WithError(err).Errorf("invalid role: %s", req.Role)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+		return
+	}
+
+	target, ok := c.loadTargetUser(ctx)
+	if !ok {
+		return
+	}
+
+	// 防止管理员误操作把自己降级后没有人能管理角色
+	if target.ID == admin.ID {
+		log.Errorf("update own role: %d", admin.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "cannot change own role"})
+		return
+	}
+
+	if err := c.db.Model(target).Update("role", req.Role).Error; err != nil {
+		log.WithError(err).Errorf("mysql update user role error: %d", target.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+	c.authController.InvalidateUser(ctx0, target.ID)
+	c.audit(ctx0, admin, "update_role", fmt.Sprintf("user:%d", target.ID), fmt.Sprintf("%s -> %s", target.Role, req.Role))
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) takedownGoods(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var req api.TakedownGoodsRequest
+	_ = ctx.ShouldBindJSON(&req)
+
+	// 下架即软删除，所有商品查询都会自动排除，需要时可以恢复
+	var goods model.Goods
+	if err := c.db.First(&goods, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "goods not found")
+		return
+	}
+	if err := c.db.Delete(&goods).Error; err != nil {
+		log.WithError(err).Errorf("mysql delete goods error: %d", goods.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql delete error"})
+		return
+	}
+
+	c.invalidateHomeGoods(ctx0)
+	c.audit(ctx0, admin, "takedown_goods", fmt.Sprintf("goods:%d", goods.ID), req.Reason)
+	if sellerID, err := strconv.ParseUint(goods.User, 10, 64); err == nil {
+		c.notifier.Notify(ctx0, uint(sellerID), notification.GoodsTakenDown, goods.ID, notification.Data{
+			"GoodsName": goods.Name,
+			"Reason":    req.Reason,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) restoreGoods(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var goods model.Goods
+	if err := c.db.Unscoped().Where("deleted_at IS NOT NULL").First(&goods, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "goods not found")
+		return
+	}
+	if err := c.db.Unscoped().Model(&goods).Update("deleted_at", nil).Error; err != nil {
+		log.WithError(err).Errorf("mysql restore goods error: %d", goods.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+
+	c.invalidateHomeGoods(ctx0)
+	c.audit(ctx0, admin, "restore_goods", fmt.Sprintf("goods:%d", goods.ID), "")
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) listCategories(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	var categories []model.Category
+	if err := c.db.Find(&categories).Error; err != nil {
+		log.WithError(err).Error("mysql query category error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	for i := range categories {
+		categories[i].Picture = c.storageClient.ObjectURL(categories[i].Picture)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": categories})
+}
+
+func (c *AdminController) createCategory(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var req api.CategoryRequest
+	if err := ctx.BindJSON(&req); err != nil || req.Id == "" || req.Name == "" {
+		log.WithError(err).Error("create category bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid category"})
+		return
+	}
+
+	category := model.Category{
+		Id:      req.Id,
+		Name:    req.Name,
+		Picture: c.storageClient.ObjectKey(req.Picture),
+	}
+	before := c.categoryCount(ctx0)
+	if err := c.db.Create(&category).Error; err != nil {
+		log.WithError(err).Errorf("mysql create category error: %s", req.Id)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
+
+	c.invalidateHomeGoods(ctx0, before)
+	c.audit(ctx0, admin, "create_category", "category:"+category.Id, category.Name)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) updateCategory(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var req api.CategoryRequest
+	if err := ctx.BindJSON(&req); err != nil || req.Name == "" {
+		log.WithError(err).Error("update category bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid category"})
+		return
+	}
+
+	var category model.Category
+	if err := c.db.Where("id = ?", ctx.Param("id")).First(&category).Error; err != nil {
+		abortQuery(ctx, err, "category not found")
+		return
+	}
+
+	if err := c.db.Model(&category).Updates(map[string]interface{}{
+		"name":    req.Name,
+		"picture": c.storageClient.ObjectKey(req.Picture),
+	}).Error; err != nil {
+		log.WithError(err).Errorf("mysql update category error: %s", category.Id)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+
+	c.invalidateHomeGoods(ctx0)
+	c.audit(ctx0, admin, "update_category", "category:"+category.Id, req.Name)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) deleteCategory(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+	id := ctx.Param("id")
+
+	// 分类下还有商品时不允许删除，避免商品失去分类
+	var goodsCount int64
+	if err := c.db.Model(&model.Goods{}).Where("cate_id = ?", id).Count(&goodsCount).Error; err != nil {
+		log.WithError(err).Errorf("mysql count goods error: %s", id)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if goodsCount > 0 {
+		log.Errorf("category not empty: %s", id)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "category not empty"})
+		return
+	}
+
+	before := c.categoryCount(ctx0)
+	result := c.db.Where("id = ?", id).Delete(&model.Category{})
+	if result.Error != nil {
+		log.WithError(result.Error).Errorf("mysql delete category error: %s", id)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql delete error"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "category not found"})
+		return
+	}
+
+	c.invalidateHomeGoods(ctx0, before)
+	c.audit(ctx0, admin, "delete_category", "category:"+id, "")
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) listBanners(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	var banners []model.Banner
+	if err := c.db.Find(&banners).Error; err != nil {
+		log.WithError(err).Error("mysql query banners error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	for i := range banners {
+		banners[i].ImgUrl = c.storageClient.ObjectURL(banners[i].ImgUrl)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": banners})
+}
+
+func (c *AdminController) createBanner(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var req api.BannerRequest
+	if err := ctx.BindJSON(&req); err != nil || req.Id == "" || req.ImgUrl == "" {
+		log.WithError(err).Error("create banner bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid banner"})
+		return
+	}
+
+	banner := model.Banner{
+		Id:     req.Id,
+		ImgUrl: c.storageClient.ObjectKey(req.ImgUrl),
+		HreUrl: req.HreUrl,
+	}
+	if err := c.db.Create(&banner).Error; err != nil {
+		log.WithError(err).Errorf("mysql create banner error: %s", req.Id)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
+
+	c.invalidateBanners(ctx0)
+	c.audit(ctx0, admin, "create_banner", "banner:"+banner.Id, "")
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) updateBanner(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var req api.BannerRequest
+	if err := ctx.BindJSON(&req); err != nil || req.ImgUrl == "" {
+		log.WithError(err).Error("update banner bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid banner"})
+		return
+	}
+
+	var banner model.Banner
+	if err := c.db.Where("id = ?", ctx.Param("id")).First(&banner).Error; err != nil {
+		abortQuery(ctx, err, "banner not found")
+		return
+	}
+
+	if err := c.db.Model(&banner).Updates(map[string]interface{}{
+		"img_url": c.storageClient.ObjectKey(req.ImgUrl),
+		"hre_url": req.HreUrl,
+	}).Error; err != nil {
+		log.WithError(err).Errorf("mysql update banner error: %s", banner.Id)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+
+	c.invalidateBanners(ctx0)
+	c.audit(ctx0, admin, "update_banner", "banner:"+banner.Id, "")
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) deleteBanner(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+	id := ctx.Param("id")
+
+	result := c.db.Where("id = ?", id).Delete(&model.Banner{})
+	if result.Error != nil {
+		log.WithError(result.Error).Errorf("mysql delete banner error: %s", id)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql delete error"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "banner not found"})
+		return
+	}
+
+	c.invalidateBanners(ctx0)
+	c.audit(ctx0, admin, "delete_banner", "banner:"+id, "")
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) listDisputes(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	page, size := pageParams(ctx)
+	query := c.db.Model(&model.Dispute{})
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithError(err).Error("mysql count disputes error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	var disputes []model.Dispute
+	if err := query.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&disputes).Error; err != nil {
+		log.WithError(err).Error("mysql query disputes error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"total": total, "items": disputes}})
+}
+
+func (c *AdminController) resolveDispute(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var req api.ResolveDisputeRequest
+	if err := ctx.BindJSON(&req); err != nil || (req.Status != model.DisputeResolved && req.Status != model.DisputeRejected) {
+		log.WithError(err).Errorf("invalid dispute status: %s", req.Status)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+
+	var dispute model.Dispute
+	if err := c.db.First(&dispute, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "dispute not found")
+		return
+	}
+
+	// 只处理仍然打开的纠纷，防止两个管理员重复处理
+	result := c.db.Model(&dispute).Where("status = ?", model.DisputeOpen).Updates(map[string]interface{}{
+		"status":     req.Status,
+		"resolution": req.Resolution,
+		"handler_id": admin.ID,
+	})
+	if result.Error != nil {
+		log.WithError(result.Error).Errorf("mysql update dispute error: %d", dispute.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "dispute already closed"})
+		return
+	}
+
+	c.audit(ctx0, admin, "resolve_dispute", fmt.Sprintf("dispute:%d", dispute.ID), req.Status)
+	c.notifier.Notify(ctx0, dispute.UserID, notification.DisputeClosed, dispute.ID, notification.Data{
+		"OrderID":    dispute.OrderID,
+		"Status":     req.Status,
+		"Resolution": req.Resolution,
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// listReports 聊天举报审核队列
+func (c *AdminController) listReports(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	page, size := pageParams(ctx)
+	query := c.db.Model(&model.ChatReport{})
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithError(err).Error("mysql count reports error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	var reports []model.ChatReport
+	if err := query.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&reports).Error; err != nil {
+		log.WithError(err).Error("mysql query reports error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"total": total, "items": reports}})
+}
+
+func (c *AdminController) resolveReport(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var req api.ResolveReportRequest
+	if err := ctx.BindJSON(&req); err != nil || (req.Status != model.ReportResolved && req.Status != model.ReportRejected) {
+		log.WithError(err).Errorf("invalid report status: %s", req.Status)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+
+	var report model.ChatReport
+	if err := c.db.First(&report, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "report not found")
+		return
+	}
+
+	result := c.db.Model(&report).Where("status = ?", model.ReportOpen).Updates(map[string]interface{}{
+		"status":     req.Status,
+		"resolution": req.Resolution,
+		"handler_id": admin.ID,
+	})
+	if result.Error != nil {
+		log.WithError(result.Error).Errorf("mysql update report error: %d", report.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "report already closed"})
+		return
+	}
+
+	c.audit(ctx0, admin, "resolve_report", fmt.Sprintf("report:%d", report.ID), req.Status)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// listFlags 内容审核命中 flag 规则的复查队列，可按 scope 和 status 过滤
+func (c *AdminController) listFlags(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	page, size := pageParams(ctx)
+	query := c.db.Model(&model.ModerationFlag{})
+	if scope := ctx.Query("scope"); scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithError(err).Error("mysql count moderation flags error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	var flags []model.ModerationFlag
+	if err := query.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&flags).Error; err != nil {
+		log.WithError(err).Error("mysql query moderation flags error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"total": total, "items": flags}})
+}
+
+func (c *AdminController) resolveFlag(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	admin, _ := auth.UserFrom(ctx)
+
+	var req api.ResolveFlagRequest
+	if err := ctx.BindJSON(&req); err != nil || (req.Status != model.FlagResolved && req.Status != model.FlagRejected) {
+		log.WithError(err).Errorf("invalid flag status: %s", req.Status)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+
+	var flag model.ModerationFlag
+	if err := c.db.First(&flag, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "flag not found")
+		return
+	}
+
+	result := c.db.Model(&flag).Where("status = ?", model.FlagOpen).Updates(map[string]interface{}{
+		"status":     req.Status,
+		"resolution": req.Resolution,
+		"handler_id": admin.ID,
+	})
+	if result.Error != nil {
+		log.WithError(result.Error).Errorf("mysql update moderation flag error: %d", flag.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "flag already closed"})
+		return
+	}
+
+	c.audit(ctx0, admin, "resolve_flag", fmt.Sprintf("flag:%d", flag.ID), req.Status)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AdminController) loadTargetUser(ctx *gin.Context) (*model.User, bool) {
+	var user model.User
+	if err := c.db.First(&user, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "user not found")
+		return nil, false
+	}
+	return &user, true
+}
+
+// audit 记录后台操作，失败只写日志不影响操作本身
+func (c *AdminController) audit(ctx context.Context, admin *model.User, action string, target string, detail string) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if err := c.db.Create(&model.AdminAudit{
+		AdminID: admin.ID,
+		Action:  action,
+		Target:  target,
+		Detail:  detail,
+	}).Error; err != nil {
+		log.WithError(err).Errorf("mysql create admin audit error: %s %s", action, target)
+	}
+	log.Infof("admin %d %s %s", admin.ID, action, target)
+}
+
+func (c *AdminController) categoryCount(ctx context.Context) int64 {
+	var count int64
+	if err := c.db.Model(&model.Category{}).Count(&count).Error; err != nil {
+		logger.SmileLog.WithContext(ctx).WithError(err).Error("mysql count category error")
+	}
+	return count
+}
+
+// invalidateHomeGoods 删除首页分类商品缓存，分类数量变化时同时删除变化前的 key
+func (c *AdminController) invalidateHomeGoods(ctx context.Context, before ...int64) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	keys := []string{homeGoodsCacheKey(c.categoryCount(ctx))}
+	for _, n := range before {
+		keys = append(keys, homeGoodsCacheKey(n))
+	}
+	if err := c.cacheClient.Delete(ctx, keys...); err != nil {
+		log.WithError(err).Error("redis delete home goods error")
+	}
+}
+
+func (c *AdminController) invalidateBanners(ctx context.Context) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if err := c.cacheClient.Delete(ctx, bannerCacheKey); err != nil {
+		log.WithError(err).Error("redis delete banners error")
+	}
+}
+
+// pageParams 解析分页参数，page 从 1 开始，size 最大 100
+func pageParams(ctx *gin.Context) (int, int) {
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	size, err := strconv.Atoi(ctx.DefaultQuery("size", "20"))
+	if err != nil || size < 1 {
+		size = 20
+	}
+	if size > 100 {
+		size = 100
+	}
+	return page, size
+}
+
+// abortQuery 记录不存在返回 404，其他错误返回 500
+func abortQuery(ctx *gin.Context, err error, notFound string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": notFound})
+		return
+	}
+	logger.SmileLog.WithContext(ctx.Request.Context()).WithError(err).Error("mysql query error")
+	ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+}
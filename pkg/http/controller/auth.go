@@ -1,41 +1,253 @@
 package controller
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 
+	"smile.expression/destiny/pkg/auth"
 	"smile.expression/destiny/pkg/cache"
 	"smile.expression/destiny/pkg/constant"
-	"smile.expression/destiny/pkg/database"
 	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
 	"smile.expression/destiny/pkg/logger"
 	"smile.expression/destiny/pkg/utils"
 )
 
 type AuthController struct {
 	options     *AuthControllerOptions
+	r           *gin.Engine
 	cacheClient *cache.Client
 	db          *gorm.DB
+	jwt         *utils.JWT
 }
 
+// wsTicketTTL WebSocket 一次性凭证的有效期（秒）
+const wsTicketTTL = 30
+
 type AuthControllerOptions struct {
 	CacheExpiration int `json:"cacheExpiration"`
+	RefreshTTL      int `json:"refreshTTL"` // refresh token 有效期（秒），默认 30 天
+}
+
+// refreshRecord 保存在 redis 中的 refresh token，key 为 token 的哈希
+type refreshRecord struct {
+	UserID uint `json:"userId"`
 }
 
-func NewAuthController(options *AuthControllerOptions, cacheClient *cache.Client, db *gorm.DB) *AuthController {
+func NewAuthController(options *AuthControllerOptions, r *gin.Engine, cacheClient *cache.Client, db *gorm.DB, jwt *utils.JWT) *AuthController {
 	return &AuthController{
 		options:     options,
+		r:           r,
 		cacheClient: cacheClient,
 		db:          db,
+		jwt:         jwt,
+	}
+}
+
+func (c *AuthController) Register() {
+	// 只有配置了非对称 key 时才发布公钥，HMAC 密钥不能公开
+	if c.jwt.Asymmetric() {
+		c.r.GET("/.well-known/jwks.json", c.jwks)
+	}
+
+	rg := c.r.Group("")
+
+	rg.POST("/token/refresh", c.refresh)
+	rg.POST("/logout", c.AuthMiddleware(), c.logout)
+	rg.POST("/logout_all", c.AuthMiddleware(), c.logoutAll)
+}
+
+func (c *AuthController) jwks(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"keys": c.jwt.JWKS()})
+}
+
+// IssueTokens 为用户签发 access token 和 refresh token
+func (c *AuthController) IssueTokens(ctx context.Context, user model.User) (*api.TokenResponse, error) {
+	token, err := c.jwt.ReleaseToken(user)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return nil, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(buf)
+
+	data, err := json.Marshal(&refreshRecord{UserID: user.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	hash := tokenHash(refreshToken)
+	if err = c.cacheClient.Set(ctx, refreshTokenKey(hash), data, c.refreshTTL()); err != nil {
+		return nil, err
+	}
+	if err = c.cacheClient.SAdd(ctx, userRefreshTokensKey(user.ID), hash, c.refreshTTL()); err != nil {
+		return nil, err
+	}
+
+	return &api.TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// RevokeAll 吊销用户所有的 token，用于注销所有设备和修改密码
+func (c *AuthController) RevokeAll(ctx context.Context, userID uint) error {
+	// 在此之前签发的 access token 都失效，记录保留到它们全部过期
+	validAfter := []byte(strconv.FormatInt(time.Now().Unix(), 10))
+	if err := c.cacheClient.Set(ctx, tokenValidAfterKey(userID), validAfter, int(c.jwt.TTL().Seconds())); err != nil {
+		return err
 	}
+
+	hashes, err := c.cacheClient.SMembers(ctx, userRefreshTokensKey(userID))
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(hashes)+1)
+	for _, hash := range hashes {
+		keys = append(keys, refreshTokenKey(hash))
+	}
+	keys = append(keys, userRefreshTokensKey(userID))
+
+	return c.cacheClient.Delete(ctx, keys...)
 }
 
+func (c *AuthController) refresh(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	var req api.RefreshTokenRequest
+	if err := ctx.BindJSON(&req); err != nil || req.RefreshToken == "" {
+		log.WithError(err).Error("refresh bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"msg": "invalid json"})
+		return
+	}
+
+	// refresh token 只能使用一次，每次刷新都换发新的
+	hash := tokenHash(req.RefreshToken)
+	data, err := c.cacheClient.GetDel(ctx0, refreshTokenKey(hash))
+	if err != nil {
+		if !cache.IsMiss(err) {
+			log.WithError(err).Error("redis get refresh token error")
+		}
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+		return
+	}
+
+	var record refreshRecord
+	if err = json.Unmarshal(data, &record); err != nil {
+		log.WithError(err).Error("failed to unmarshal refresh token")
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+		return
+	}
+	if err = c.cacheClient.SRem(ctx0, userRefreshTokensKey(record.UserID), hash); err != nil {
+		log.WithError(err).Error("redis remove refresh token error")
+	}
+
+	user, err := c.loadUser(ctx0, record.UserID)
+	if err != nil {
+		log.WithError(err).Errorf("load user error: %d", record.UserID)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+		return
+	}
+
+	if user.Banned {
+		log.Errorf("banned user refresh token: %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "user banned"})
+		return
+	}
+
+	resp, err := c.IssueTokens(ctx0, *user)
+	if err != nil {
+		log.WithError(err).Error("issue tokens error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "issue tokens error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": resp})
+}
+
+func (c *AuthController) logout(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	claims, exists := auth.ClaimsFrom(ctx)
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+		return
+	}
+
+	// 吊销当前 access token，记录保留到它自然过期
+	if ttl := claims.ExpiresAt - time.Now().Unix(); ttl > 0 && claims.Id != "" {
+		if err := c.cacheClient.Set(ctx0, revokedTokenKey(claims.Id), []byte("1"), int(ttl)); err != nil {
+			log.WithError(err).Error("redis revoke token error")
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "logout error"})
+			return
+		}
+	}
+
+	// 同时吊销客户端提交的 refresh token，请求体可以为空
+	var req api.RefreshTokenRequest
+	if err := ctx.ShouldBindJSON(&req); err == nil && req.RefreshToken != "" {
+		hash := tokenHash(req.RefreshToken)
+		data, err := c.cacheClient.Get(ctx0, refreshTokenKey(hash))
+		var record refreshRecord
+		if err == nil && json.Unmarshal(data, &record) == nil && record.UserID == claims.UserID {
+			if err = c.cacheClient.Delete(ctx0, refreshTokenKey(hash)); err != nil {
+				log.WithError(err).Error("redis delete refresh token error")
+			}
+			if err = c.cacheClient.SRem(ctx0, userRefreshTokensKey(record.UserID), hash); err != nil {
+				log.WithError(err).Error("redis remove refresh token error")
+			}
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *AuthController) logoutAll(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+		return
+	}
+
+	if err := c.RevokeAll(ctx0, userInfo.ID); err != nil {
+		log.WithError(err).Errorf("revoke all tokens error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "logout error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// AuthMiddleware 校验 token 并把当前用户写入上下文，handler 通过 auth.UserFrom 获取
 func (c *AuthController) AuthMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var (
@@ -53,39 +265,28 @@ func (c *AuthController) AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString = tokenString[7:]
-		token, claims, err := utils.ParseToken(tokenString)
+		token, claims, err := c.jwt.ParseToken(tokenString)
 		if err != nil || !token.Valid {
 			log.Error("invalid token")
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
 			return
 		}
 
-		//验证通过后获取claim中的userid
-		userid := claims.UserID
-		key := fmt.Sprintf("user_%d", userid)
-
-		var user model.User
-
-		data, err := c.cacheClient.Get(ctx0, key)
-		if err == nil {
-			if err = json.Unmarshal(data, &user); err != nil {
-				log.WithError(err).Error("failed to unmarshal user")
-			} else {
-				ctx.Set("user", &user)
-				ctx.Next()
-				return
-			}
+		revoked, err := c.isRevoked(ctx0, claims)
+		if err != nil {
+			log.WithError(err).Error("check token revocation error")
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "check token error"})
+			return
+		}
+		if revoked {
+			log.Errorf("token revoked: %s", claims.Id)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+			return
 		}
 
-		var cacheData []byte
-		defer func() {
-			cacheData, err = json.Marshal(user)
-			if err = c.cacheClient.Set(ctx0, key, cacheData, c.options.CacheExpiration); err != nil {
-				log.WithError(err).Error("redis set user error")
-			}
-		}()
-
-		if err = c.db.First(&user, userid).Error; err != nil {
+		//验证通过后获取claim中的userid
+		user, err := c.loadUser(ctx0, claims.UserID)
+		if err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				log.WithError(err).Error("mysql not found user")
 			} else {
@@ -95,48 +296,212 @@ func (c *AuthController) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if user.Banned {
+			log.Errorf("banned user: %d", user.ID)
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "user banned"})
+			return
+		}
+
 		//用户存在 将user的信息写入上下文
-		ctx.Set("user", &user)
+		auth.SetUser(ctx, user)
+		auth.SetClaims(ctx, claims)
 		ctx.Next()
 	}
 }
 
-func AuthMiddleware() gin.HandlerFunc {
+// IssueWebSocketTicket 签发一次性的 WebSocket 连接凭证，浏览器建立 WebSocket 时不能设置 header，
+// 凭证放在 URL 中会写进访问日志，所以只能使用一次且很快过期
+func (c *AuthController) IssueWebSocketTicket(ctx context.Context, userID uint) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(buf)
+
+	if err := c.cacheClient.Set(ctx, wsTicketKey(tokenHash(ticket)), []byte(strconv.Itoa(int(userID))), wsTicketTTL); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// WebSocketAuth 有 ticket 参数时用一次性凭证校验，否则和 AuthMiddleware 相同
+func (c *AuthController) WebSocketAuth() gin.HandlerFunc {
+	authMiddleware := c.AuthMiddleware()
+
 	return func(ctx *gin.Context) {
-		//获取authorization header
-		tokenString := ctx.GetHeader(constant.Authorization)
-		//验证token格式
-		if tokenString == "" || !strings.HasPrefix(tokenString, "Bearer ") {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
-			ctx.Abort()
+		var (
+			ctx0 = ctx.Request.Context()
+			log  = logger.SmileLog.WithContext(ctx0)
+		)
+
+		ticket := ctx.Query("ticket")
+		if ticket == "" || !websocket.IsWebSocketUpgrade(ctx.Request) {
+			authMiddleware(ctx)
 			return
 		}
 
-		tokenString = tokenString[7:]
-		token, claims, err := utils.ParseToken(tokenString)
-		if err != nil || !token.Valid {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
-			ctx.Abort()
+		data, err := c.cacheClient.GetDel(ctx0, wsTicketKey(tokenHash(ticket)))
+		if err != nil {
+			log.WithError(err).Error("invalid websocket ticket")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+			return
+		}
+		userID, err := strconv.ParseUint(string(data), 10, 64)
+		if err != nil {
+			log.WithError(err).Error("invalid websocket ticket")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
 			return
 		}
 
-		//验证通过后获取claim中的userid
-		userid := claims.UserID
+		user, err := c.loadUser(ctx0, uint(userID))
+		if err != nil {
+			log.WithError(err).Errorf("mysql query user error: %d", userID)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+			return
+		}
+		if user.Banned {
+			log.Errorf("banned user: %d", user.ID)
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "user banned"})
+			return
+		}
 
-		DB := database.GetDB()
-		var user model.User
-		DB.First(&user, userid)
+		auth.SetUser(ctx, user)
+		ctx.Next()
+	}
+}
+
+// RequirePermission 要求当前用户拥有权限，需要放在 AuthMiddleware 之后
+func (c *AuthController) RequirePermission(perm auth.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var (
+			ctx0 = ctx.Request.Context()
+			log  = logger.SmileLog.WithContext(ctx0)
+		)
 
-		//用户不存在
-		if user.ID == 0 {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "权限不足"})
-			ctx.Abort()
+		user, exists := auth.UserFrom(ctx)
+		if !exists {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
 			return
 		}
 
-		//用户存在 将user的信息写入上下文
-		ctx.Set("user", user)
+		if !auth.HasPermission(user, perm) {
+			log.Errorf("permission denied: %d, %s", user.ID, perm)
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "permission denied"})
+			return
+		}
 
 		ctx.Next()
 	}
 }
+
+// InvalidateUser 用户信息修改后删除缓存，下一次请求重新从数据库加载
+func (c *AuthController) InvalidateUser(ctx context.Context, userID uint) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if err := c.cacheClient.Delete(ctx, userCacheKey(userID)); err != nil {
+		log.WithError(err).Errorf("redis delete user error: %d", userID)
+	}
+}
+
+// cachedUser 缓存中的用户，model.User 的密码哈希不参与序列化，修改密码和注销时还要用到，单独保存
+type cachedUser struct {
+	model.User
+	Password string `json:"password"`
+}
+
+// loadUser 先查缓存，未命中时查数据库并写回缓存
+func (c *AuthController) loadUser(ctx context.Context, userID uint) (*model.User, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+		key = userCacheKey(userID)
+	)
+
+	var cached cachedUser
+
+	data, err := c.cacheClient.Get(ctx, key)
+	if err == nil {
+		if err = json.Unmarshal(data, &cached); err != nil {
+			log.WithError(err).Error("failed to unmarshal user")
+		} else {
+			user := cached.User
+			user.Password = cached.Password
+			return &user, nil
+		}
+	}
+
+	var user model.User
+	if err = c.db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	cacheData, err := json.Marshal(&cachedUser{User: user, Password: user.Password})
+	if err != nil {
+		log.WithError(err).Error("failed to marshal user")
+	} else if err = c.cacheClient.Set(ctx, key, cacheData, c.options.CacheExpiration); err != nil {
+		log.WithError(err).Error("redis set user error")
+	}
+
+	return &user, nil
+}
+
+// isRevoked 检查 token 是否被单独吊销，或者签发于用户注销所有设备之前
+func (c *AuthController) isRevoked(ctx context.Context, claims *utils.Claims) (bool, error) {
+	if claims.Id != "" {
+		revoked, err := c.cacheClient.Exists(ctx, revokedTokenKey(claims.Id))
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	data, err := c.cacheClient.Get(ctx, tokenValidAfterKey(claims.UserID))
+	if err != nil {
+		if cache.IsMiss(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	validAfter, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return claims.IssuedAt < validAfter, nil
+}
+
+func (c *AuthController) refreshTTL() int {
+	if c.options.RefreshTTL > 0 {
+		return c.options.RefreshTTL
+	}
+	return int((30 * 24 * time.Hour).Seconds())
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func wsTicketKey(hash string) string {
+	return fmt.Sprintf("chat/ws_ticket/%s", hash)
+}
+
+func userCacheKey(userID uint) string {
+	return fmt.Sprintf("user_%d", userID)
+}
+
+func refreshTokenKey(hash string) string {
+	return fmt.Sprintf("auth/refresh/%s", hash)
+}
+
+func userRefreshTokensKey(userID uint) string {
+	return fmt.Sprintf("auth/refresh_user/%d", userID)
+}
+
+func revokedTokenKey(jti string) string {
+	return fmt.Sprintf("auth/revoked/%s", jti)
+}
+
+func tokenValidAfterKey(userID uint) string {
+	return fmt.Sprintf("auth/valid_after/%d", userID)
+}
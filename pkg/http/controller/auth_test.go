@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/utils"
+)
+
+func newTestJWT(t *testing.T) *utils.JWT {
+	t.Helper()
+
+	jwt, err := utils.NewJWT(&utils.JWTOptions{Keys: []utils.JWTKeyOptions{{ID: "a", Secret: "secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwt
+}
+
+func newTestAuthController(t *testing.T) (*AuthController, *gin.Engine) {
+	t.Helper()
+
+	cacheClient, _ := newTestCache(t)
+
+	r := gin.New()
+	c := NewAuthController(&AuthControllerOptions{}, r, cacheClient, newTestDB(t, &model.User{}), newTestJWT(t))
+	c.Register()
+	return c, r
+}
+
+func createTestUser(t *testing.T, c *AuthController, telephone string) model.User {
+	t.Helper()
+
+	user := model.User{Name: "user", Telephone: telephone}
+	if err := c.db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func issueTestTokens(t *testing.T, c *AuthController, user model.User) *api.TokenResponse {
+	t.Helper()
+
+	tokens, err := c.IssueTokens(context.Background(), user)
+	if err != nil {
+		t.Fatalf("IssueTokens error: %v", err)
+	}
+	return tokens
+}
+
+func refreshTokens(t *testing.T, r *gin.Engine, refreshToken string, want int) *api.TokenResponse {
+	t.Helper()
+
+	w := serve(r, http.MethodPost, "/token/refresh", "", api.RefreshTokenRequest{RefreshToken: refreshToken})
+	assertStatus(t, w, want)
+	if want != http.StatusOK {
+		return nil
+	}
+
+	var resp struct {
+		Result api.TokenResponse `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp.Result
+}
+
+func TestRefreshRotation(t *testing.T) {
+	c, r := newTestAuthController(t)
+	user := createTestUser(t, c, "13800000001")
+	tokens := issueTestTokens(t, c, user)
+
+	rotated := refreshTokens(t, r, tokens.RefreshToken, http.StatusOK)
+	if rotated.RefreshToken == tokens.RefreshToken || rotated.Token == "" {
+		t.Fatalf("refresh did not rotate tokens: %+v", rotated)
+	}
+
+	// 旧的 refresh token 只能用一次
+	refreshTokens(t, r, tokens.RefreshToken, http.StatusUnauthorized)
+	refreshTokens(t, r, rotated.RefreshToken, http.StatusOK)
+	refreshTokens(t, r, "unknown", http.StatusUnauthorized)
+}
+
+func TestRefreshBannedUser(t *testing.T) {
+	c, r := newTestAuthController(t)
+	user := createTestUser(t, c, "13800000001")
+	tokens := issueTestTokens(t, c, user)
+
+	c.db.Model(&user).Update("banned", true)
+	c.InvalidateUser(context.Background(), user.ID)
+
+	refreshTokens(t, r, tokens.RefreshToken, http.StatusForbidden)
+	// 被拒绝的 refresh token 同样作废
+	c.db.Model(&user).Update("banned", false)
+	refreshTokens(t, r, tokens.RefreshToken, http.StatusUnauthorized)
+}
+
+func TestLogout(t *testing.T) {
+	c, r := newTestAuthController(t)
+	user := createTestUser(t, c, "13800000001")
+	tokens := issueTestTokens(t, c, user)
+	other := issueTestTokens(t, c, user)
+
+	w := serve(r, http.MethodPost, "/logout", tokens.Token, api.RefreshTokenRequest{RefreshToken: tokens.RefreshToken})
+	assertStatus(t, w, http.StatusOK)
+
+	// 当前 access token 和提交的 refresh token 失效，其他设备不受影响
+	assertStatus(t, serve(r, http.MethodPost, "/logout", tokens.Token, nil), http.StatusUnauthorized)
+	refreshTokens(t, r, tokens.RefreshToken, http.StatusUnauthorized)
+	refreshTokens(t, r, other.RefreshToken, http.StatusOK)
+}
+
+func TestLogoutForeignRefreshToken(t *testing.T) {
+	c, r := newTestAuthController(t)
+	alice := createTestUser(t, c, "13800000001")
+	bob := createTestUser(t, c, "13800000002")
+	aliceTokens := issueTestTokens(t, c, alice)
+	bobTokens := issueTestTokens(t, c, bob)
+
+	// 不能用自己的 access token 吊销别人的 refresh token
+	w := serve(r, http.MethodPost, "/logout", aliceTokens.Token, api.RefreshTokenRequest{RefreshToken: bobTokens.RefreshToken})
+	assertStatus(t, w, http.StatusOK)
+	refreshTokens(t, r, bobTokens.RefreshToken, http.StatusOK)
+}
+
+func TestRevokeAll(t *testing.T) {
+	var (
+		c, r = newTestAuthController(t)
+		ctx  = context.Background()
+	)
+	user := createTestUser(t, c, "13800000001")
+	first := issueTestTokens(t, c, user)
+	second := issueTestTokens(t, c, user)
+
+	if err := c.RevokeAll(ctx, user.ID); err != nil {
+		t.Fatalf("RevokeAll error: %v", err)
+	}
+	refreshTokens(t, r, first.RefreshToken, http.StatusUnauthorized)
+	refreshTokens(t, r, second.RefreshToken, http.StatusUnauthorized)
+
+	// 吊销之前签发的 access token 失效，之后签发的不受影响
+	now := time.Now().Unix()
+	tests := []struct {
+		name     string
+		claims   *utils.Claims
+		wantFail bool
+	}{
+		{name: "issued before", claims: testClaims(user.ID, now-60), wantFail: true},
+		{name: "issued after", claims: testClaims(user.ID, now+1)},
+		{name: "other user", claims: testClaims(user.ID+1, now-60)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked, err := c.isRevoked(ctx, tt.claims)
+			if err != nil {
+				t.Fatalf("isRevoked error: %v", err)
+			}
+			if revoked != tt.wantFail {
+				t.Errorf("isRevoked = %v, want %v", revoked, tt.wantFail)
+			}
+		})
+	}
+}
+
+func testClaims(userID uint, issuedAt int64) *utils.Claims {
+	claims := &utils.Claims{UserID: userID}
+	claims.IssuedAt = issuedAt
+	return claims
+}
@@ -10,25 +10,30 @@ import (
 	"smile.expression/destiny/pkg/cache"
 	"smile.expression/destiny/pkg/database/model"
 	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/storage"
 )
 
 type BannerController struct {
-	options     *BannerControllerOptions
-	r           *gin.Engine
-	db          *gorm.DB
-	cacheClient *cache.Client
+	options       *BannerControllerOptions
+	r             *gin.Engine
+	db            *gorm.DB
+	cacheClient   *cache.Client
+	storageClient *storage.Client
 }
 
+const bannerCacheKey = "home/banner"
+
 type BannerControllerOptions struct {
 	CacheExpiration int `json:"cacheExpiration"`
 }
 
-func NewBannerController(options *BannerControllerOptions, r *gin.Engine, db *gorm.DB, cacheClient *cache.Client) *BannerController {
+func NewBannerController(options *BannerControllerOptions, r *gin.Engine, db *gorm.DB, cacheClient *cache.Client, storageClient *storage.Client) *BannerController {
 	return &BannerController{
-		options:     options,
-		r:           r,
-		db:          db,
-		cacheClient: cacheClient,
+		options:       options,
+		r:             r,
+		db:            db,
+		cacheClient:   cacheClient,
+		storageClient: storageClient,
 	}
 }
 
@@ -44,7 +49,7 @@ func (c *BannerController) getBanners(ctx *gin.Context) {
 		log  = logger.SmileLog.WithContext(ctx0)
 	)
 
-	key := "home/banner"
+	key := bannerCacheKey
 	var banners []model.Banner
 
 	data, err := c.cacheClient.Get(ctx0, key)
@@ -52,7 +57,7 @@ func (c *BannerController) getBanners(ctx *gin.Context) {
 		if err = json.Unmarshal(data, &banners); err != nil {
 			log.WithError(err).Error("failed to unmarshal banners")
 		} else {
-			ctx.JSON(http.StatusOK, gin.H{"result": banners})
+			ctx.JSON(http.StatusOK, gin.H{"result": c.renderBanners(banners)})
 			return
 		}
 	}
@@ -72,7 +77,17 @@ func (c *BannerController) getBanners(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"result": banners,
+		"result": c.renderBanners(banners),
 	})
 	return
 }
+
+// renderBanners 返回图片渲染成访问地址的副本，缓存中保存的仍然是对象key
+func (c *BannerController) renderBanners(banners []model.Banner) []model.Banner {
+	rendered := make([]model.Banner, len(banners))
+	for i, banner := range banners {
+		banner.ImgUrl = c.storageClient.ObjectURL(banner.ImgUrl)
+		rendered[i] = banner
+	}
+	return rendered
+}
@@ -0,0 +1,261 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"smile.expression/destiny/pkg/auth"
+	model2 "smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/logger"
+)
+
+var errBlocked = errors.New("blocked")
+
+// listBlocks 自己拉黑的用户
+func (c *ChatController) listBlocks(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	var blocks []model2.UserBlock
+	if err := c.db.Where("user_id = ?", userinfo.ID).Order("id DESC").Find(&blocks).Error; err != nil {
+		log.WithError(err).Errorf("mysql query blocks error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ids := make([]uint, 0, len(blocks))
+	for _, block := range blocks {
+		ids = append(ids, block.BlockedID)
+	}
+	users, err := c.usersByID(ids)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query users error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	items := make([]api.PublicUser, 0, len(blocks))
+	for _, block := range blocks {
+		if user, ok := users[block.BlockedID]; ok {
+			items = append(items, toPublicUser(c.storageClient, user))
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": items})
+}
+
+// blockUser 拉黑后双方不能互发消息，会话从自己的列表中隐藏
+func (c *ChatController) blockUser(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	var req api.BlockUserRequest
+	if err := ctx.BindJSON(&req); err != nil || req.UserID == 0 || req.UserID == userinfo.ID {
+		log.WithError(err).Error("block user bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+		return
+	}
+
+	exists, err := c.userExists(req.UserID)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query user error: %d", req.UserID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	// 重复拉黑直接忽略
+	block := model2.UserBlock{UserID: userinfo.ID, BlockedID: req.UserID}
+	if err = c.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error; err != nil {
+		log.WithError(err).Errorf("mysql create block error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
+	log.Infof("user %d blocked %d", userinfo.ID, req.UserID)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *ChatController) unblockUser(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	if err := c.db.Where("user_id = ? AND blocked_id = ?", userinfo.ID, ctx.Param("id")).Delete(&model2.UserBlock{}).Error; err != nil {
+		log.WithError(err).Errorf("mysql delete block error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql delete error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *ChatController) muteConversation(ctx *gin.Context) {
+	c.setMuted(ctx, true)
+}
+
+func (c *ChatController) unmuteConversation(ctx *gin.Context) {
+	c.setMuted(ctx, false)
+}
+
+// setMuted 免打扰只影响自己，消息照常接收
+func (c *ChatController) setMuted(ctx *gin.Context, muted bool) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	participant, ok := c.loadParticipant(ctx, userinfo.ID)
+	if !ok {
+		return
+	}
+
+	if err := c.db.Model(participant).Update("muted", muted).Error; err != nil {
+		log.WithError(err).Errorf("mysql update muted error: %d", participant.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// createReport 举报用户或消息，进入后台审核队列
+func (c *ChatController) createReport(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userinfo, isExist := auth.UserFrom(ctx)
+	if !isExist {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
+		return
+	}
+
+	var req api.ReportRequest
+	if err := ctx.BindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" || utf8.RuneCountInString(req.Reason) > 1024 {
+		log.WithError(err).Error("create report bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid report"})
+		return
+	}
+
+	report := model2.ChatReport{
+		ReporterID: userinfo.ID,
+		UserID:     req.UserID,
+		Reason:     req.Reason,
+		Status:     model2.ReportOpen,
+	}
+
+	if req.MessageID > 0 {
+		// 只能举报自己所在会话中别人发的消息
+		joined := c.db.Model(&model2.ConversationParticipant{}).Select("conversation_id").Where("user_id = ?", userinfo.ID)
+
+		var message model2.Message
+		if err := c.db.Where("id = ? AND sender_id <> ? AND conversation_id IN (?)", req.MessageID, userinfo.ID, joined).
+			First(&message).Error; err != nil {
+			abortQuery(ctx, err, "message not found")
+			return
+		}
+		report.UserID = message.SenderID
+		report.MessageID = message.ID
+		// 保存举报时的消息内容，发送者之后无法再修改
+		report.Snapshot = message.Content
+		if message.Type != model2.MessageText {
+			report.Snapshot = fmt.Sprintf("%s %s#%d", messagePreview(&message), message.Content, message.RefID)
+		}
+	}
+
+	if report.UserID == 0 || report.UserID == userinfo.ID {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+		return
+	}
+	exists, err := c.userExists(report.UserID)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query user error: %d", report.UserID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	// 同一目标还有未处理的举报时不重复提交
+	var openCount int64
+	if err = c.db.Model(&model2.ChatReport{}).
+		Where("reporter_id = ? AND user_id = ? AND message_id = ? AND status = ?", userinfo.ID, report.UserID, report.MessageID, model2.ReportOpen).
+		Count(&openCount).Error; err != nil {
+		log.WithError(err).Errorf("mysql count reports error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if openCount > 0 {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "report already open"})
+		return
+	}
+
+	if err = c.db.Create(&report).Error; err != nil {
+		log.WithError(err).Errorf("mysql create report error: %d", userinfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
+	log.Infof("user %d reported %d, report: %d", userinfo.ID, report.UserID, report.ID)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"id": report.ID}})
+}
+
+// isBlocked 任意一方拉黑了对方
+func (c *ChatController) isBlocked(a, b uint) (bool, error) {
+	var count int64
+	if err := c.db.Model(&model2.UserBlock{}).
+		Where("(user_id = ? AND blocked_id = ?) OR (user_id = ? AND blocked_id = ?)", a, b, b, a).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// blockedBy 用户拉黑的人，会话列表中隐藏与他们的会话
+func (c *ChatController) blockedBy(userID uint) *gorm.DB {
+	return c.db.Model(&model2.UserBlock{}).Select("blocked_id").Where("user_id = ?", userID)
+}
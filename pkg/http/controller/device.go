@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"smile.expression/destiny/pkg/auth"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/push"
+)
+
+// DeviceController 登记离线推送的设备令牌
+type DeviceController struct {
+	r              *gin.Engine
+	pushClient     *push.Client
+	authController *AuthController
+}
+
+func NewDeviceController(r *gin.Engine, pushClient *push.Client, authController *AuthController) *DeviceController {
+	return &DeviceController{
+		r:              r,
+		pushClient:     pushClient,
+		authController: authController,
+	}
+}
+
+func (c *DeviceController) Register() {
+	rg := c.r.Group("/member/devices", c.authController.AuthMiddleware())
+
+	rg.POST("", c.registerDevice)
+	rg.DELETE("", c.unregisterDevice)
+}
+
+// registerDevice 客户端启动或令牌刷新时上报，重复上报只更新归属
+func (c *DeviceController) registerDevice(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	var req api.RegisterDeviceRequest
+	if err := ctx.BindJSON(&req); err != nil || req.Token == "" || len(req.Token) > 255 || !push.ValidPlatform(req.Platform) {
+		log.WithError(err).Error("register device bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid device"})
+		return
+	}
+
+	if err := c.pushClient.Register(userInfo.ID, req.Platform, req.Token); err != nil {
+		log.WithError(err).Errorf("mysql save device token error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// unregisterDevice 退出登录时调用，之后该设备不再收到推送
+func (c *DeviceController) unregisterDevice(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	var req api.UnregisterDeviceRequest
+	if err := ctx.BindJSON(&req); err != nil || req.Token == "" {
+		log.WithError(err).Error("unregister device bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid device"})
+		return
+	}
+
+	if err := c.pushClient.Unregister(userInfo.ID, req.Token); err != nil {
+		log.WithError(err).Errorf("mysql delete device token error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql delete error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/storage"
+)
+
+// model.User 包含密码哈希等字段，响应中只能使用下面转换出的 DTO
+
+func toPublicUser(storageClient *storage.Client, user *model.User) api.PublicUser {
+	return api.PublicUser{
+		ID:       user.ID,
+		Nickname: user.Name,
+		Avatar:   storageClient.ObjectURL(user.Avatar),
+		JoinedAt: user.CreatedAt,
+	}
+}
+
+func toProfile(storageClient *storage.Client, user *model.User) api.Profile {
+	return api.Profile{
+		ID:       user.ID,
+		Account:  user.Telephone,
+		Nickname: user.Name,
+		Gender:   user.Gender,
+		Avatar:   storageClient.ObjectURL(user.Avatar),
+		Role:     user.Role,
+		JoinedAt: user.CreatedAt,
+	}
+}
+
+func toAdminUser(storageClient *storage.Client, user *model.User) api.AdminUser {
+	return api.AdminUser{
+		Profile: toProfile(storageClient, user),
+		Banned:  user.Banned,
+	}
+}
@@ -13,11 +13,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"smile.expression/destiny/pkg/auth"
 	"smile.expression/destiny/pkg/cache"
-	"smile.expression/destiny/pkg/database"
 	"smile.expression/destiny/pkg/database/model"
 	"smile.expression/destiny/pkg/http/api"
 	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/moderation"
 	"smile.expression/destiny/pkg/storage"
 )
 
@@ -29,12 +30,14 @@ type SingleIdle struct {
 }
 
 type GoodsController struct {
-	options        *GoodsControllerOptions
-	r              *gin.Engine
-	db             *gorm.DB
-	cacheClient    *cache.Client
-	storageClient  *storage.Client
-	authController *AuthController
+	options          *GoodsControllerOptions
+	r                *gin.Engine
+	db               *gorm.DB
+	cacheClient      *cache.Client
+	storageClient    *storage.Client
+	reviewController *ReviewController
+	authController   *AuthController
+	moderator        *moderation.Pipeline
 }
 
 type GoodsControllerOptions struct {
@@ -43,14 +46,16 @@ type GoodsControllerOptions struct {
 	CacheExpiration int `json:"cacheExpiration"`
 }
 
-func NewGoodsController(options *GoodsControllerOptions, r *gin.Engine, db *gorm.DB, cacheClient *cache.Client, storageClient *storage.Client, authController *AuthController) *GoodsController {
+func NewGoodsController(options *GoodsControllerOptions, r *gin.Engine, db *gorm.DB, cacheClient *cache.Client, storageClient *storage.Client, reviewController *ReviewController, authController *AuthController, moderator *moderation.Pipeline) *GoodsController {
 	return &GoodsController{
-		options:        options,
-		r:              r,
-		db:             db,
-		cacheClient:    cacheClient,
-		storageClient:  storageClient,
-		authController: authController,
+		options:          options,
+		r:                r,
+		db:               db,
+		cacheClient:      cacheClient,
+		storageClient:    storageClient,
+		reviewController: reviewController,
+		authController:   authController,
+		moderator:        moderator,
 	}
 }
 
@@ -63,6 +68,12 @@ func (c *GoodsController) Register() {
 	rg2 := c.r.Group("/member")
 
 	rg2.POST("/release", c.authController.AuthMiddleware(), c.release)
+
+	rg3 := c.r.Group("")
+
+	rg3.GET("/category", c.category)
+	rg3.GET("/goods", c.getOne)
+	rg3.GET("/goods/relevant", c.authController.AuthMiddleware(), c.relevant)
 }
 
 func (c *GoodsController) release(ctx *gin.Context) {
@@ -71,13 +82,12 @@ func (c *GoodsController) release(ctx *gin.Context) {
 		log  = logger.SmileLog.WithContext(ctx0)
 	)
 
-	user, exists := ctx.Get("user")
+	userInfo, exists := auth.UserFrom(ctx)
 	if !exists {
 		log.Error("need to login")
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "need to login"})
 		return
 	}
-	userInfo := user.(*model.User)
 
 	//绑定body
 	var goodInfo GoodInfo
@@ -88,14 +98,34 @@ func (c *GoodsController) release(ctx *gin.Context) {
 		return
 	}
 
+	if len(goodInfo.Picture) == 0 || len(goodInfo.Picture) > 5 {
+		log.Errorf("invalid picture count: %d", len(goodInfo.Picture))
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "picture count must be between 1 and 5"})
+		return
+	}
+
+	//商品名和描述过审核，命中 block 规则时拒绝发布
+	nameVerdict := c.moderator.Moderate(ctx0, moderation.ScopeGoods, goodInfo.Name)
+	descVerdict := c.moderator.Moderate(ctx0, moderation.ScopeGoods, goodInfo.Description)
+	if nameVerdict.Action == moderation.ActionBlock || descVerdict.Action == moderation.ActionBlock {
+		log.Errorf("goods content rejected: %d, rules: %v", userInfo.ID, append(nameVerdict.Rules, descVerdict.Rules...))
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errModerated.Error()})
+		return
+	}
+
+	//数据库中只保存对象key，访问地址在返回时生成
+	for i := range goodInfo.Picture {
+		goodInfo.Picture[i] = c.storageClient.ObjectKey(goodInfo.Picture[i])
+	}
+
 	//生成good
 	good := model.Goods{
 		CateId:      goodInfo.CateId,
 		User:        strconv.Itoa(int(userInfo.ID)), //之前将good表的User字段定义成了string
-		Name:        goodInfo.Name,
+		Name:        nameVerdict.Text,
 		Picture:     goodInfo.Picture[0],
 		Price:       goodInfo.Price,
-		Description: goodInfo.Description,
+		Description: descVerdict.Text,
 		IsSold:      false,
 	}
 	if err := c.db.Create(&good).Error; err != nil {
@@ -121,6 +151,8 @@ func (c *GoodsController) release(ctx *gin.Context) {
 		return
 	}
 
+	flagContent(ctx0, c.db, moderation.ScopeGoods, userInfo.ID, good.ID, goodInfo.Name+"\n"+goodInfo.Description, mergeVerdicts(nameVerdict, descVerdict))
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"result": "ok",
 	})
@@ -142,14 +174,14 @@ func (c *GoodsController) goods(ctx *gin.Context) {
 
 	result := make([]api.Goods, len(cate))
 	// 接入redis
-	key := fmt.Sprintf("home/goods_%d", len(cate))
+	key := homeGoodsCacheKey(int64(len(cate)))
 
 	data, err := c.cacheClient.Get(ctx0, key)
 	if err == nil {
 		if err = json.Unmarshal(data, &result); err != nil {
 			log.WithError(err).Error("failed to unmarshal home goods data")
 		} else {
-			ctx.JSON(http.StatusOK, gin.H{"result": result})
+			ctx.JSON(http.StatusOK, gin.H{"result": c.renderHomeGoods(result)})
 			return
 		}
 	}
@@ -171,11 +203,11 @@ func (c *GoodsController) goods(ctx *gin.Context) {
 
 		result[i].Id = g.Id
 		result[i].Name = g.Name
-		result[i].Picture = c.storageClient.SetEndpoint(g.Picture)
+		result[i].Picture = g.Picture
 	}
 
 	ctx.JSON(200, gin.H{
-		"result": result,
+		"result": c.renderHomeGoods(result),
 	})
 	return
 }
@@ -195,69 +227,129 @@ func (c *GoodsController) new(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"result": recentGoods})
+	ctx.JSON(http.StatusOK, gin.H{"result": c.renderGoods(recentGoods)})
 	return
 }
 
-//暂且不考虑id转换错误
+func (c *GoodsController) getOne(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	id, err := strconv.ParseUint(ctx.Query("id"), 10, 64)
+	if err != nil {
+		log.WithError(err).Errorf("invalid goods id: %s", ctx.Query("id"))
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid goods id"})
+		return
+	}
 
-func GetOneGood(c *gin.Context) {
-	db := database.GetDB()
-	idStr := c.Query("id")
-	id, _ := strconv.ParseInt(idStr, 10, 64)
 	var target model.Goods
-	if err := db.Table("goods").Where("id = ?", id).First(&target).Error; err != nil {
+	if err = c.db.Where("id = ?", id).First(&target).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(404, gin.H{
-				"err warning": "Record of good no found",
-			})
-		}
-	} else {
-		var picTarget model.Picture
-		if err = db.Table("pictures").Where("good_id = ?", target.ID).First(&picTarget).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				c.JSON(404, gin.H{
-					"err warning": "There is a error in pictures database,please contact the administrator",
-				})
-			}
+			log.WithError(err).Errorf("mysql not found goods: %d", id)
+			ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "goods not found"})
 		} else {
-			//用户头像一般不会出错 简化代码不处理
-			var user model.User
-			db.Table("users").First(&user, target.User)
-			p := [5]string{picTarget.Picture1, picTarget.Picture2, picTarget.Picture3, picTarget.Picture4, picTarget.Picture5}
-			c.JSON(200, gin.H{
-				"result":   target,
-				"pictures": p,
-				"user":     user,
-			})
+			log.WithError(err).Errorf("mysql query goods error: %d", id)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query goods error"})
 		}
+		return
+	}
+
+	var picTarget model.Picture
+	if err = c.db.Where("good_id = ?", target.ID).First(&picTarget).Error; err != nil {
+		log.WithError(err).Errorf("mysql query pictures error: %d", target.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query pictures error"})
+		return
 	}
 
+	var user model.User
+	// 卖家注销后商品仍可能出现在订单和聊天中，需要查出已删除的用户
+	if err = c.db.Unscoped().First(&user, target.User).Error; err != nil {
+		log.WithError(err).Errorf("mysql query seller error: %s", target.User)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query seller error"})
+		return
+	}
+
+	// 卖家评分获取失败不影响商品展示
+	sellerRating, err := c.reviewController.Summary(ctx0, user.ID)
+	if err != nil {
+		log.WithError(err).Errorf("query rating summary error: %d", user.ID)
+		sellerRating = &api.RatingSummary{}
+	}
+	sellerReviews, err := c.reviewController.RecentReviews(user.ID, 3)
+	if err != nil {
+		log.WithError(err).Errorf("query recent reviews error: %d", user.ID)
+	}
+
+	p := [5]string{picTarget.Picture1, picTarget.Picture2, picTarget.Picture3, picTarget.Picture4, picTarget.Picture5}
+	for i := range p {
+		p[i] = c.storageClient.ObjectURL(p[i])
+	}
+	target.Picture = c.storageClient.ObjectURL(target.Picture)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"result":        target,
+		"pictures":      p,
+		"user":          toPublicUser(c.storageClient, &user),
+		"sellerRating":  sellerRating,
+		"sellerReviews": sellerReviews,
+	})
 }
 
-func ChooseCategory(ctx *gin.Context) {
+func (c *GoodsController) category(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
 
-	DB := database.GetDB()
 	var result SingleIdle
 
 	CateId := ctx.DefaultQuery("id", "3")
 
 	var category model.Category
-	DB.Table("categories").Where("id = ?", CateId).Find(&category)
+	if err := c.db.Where("id = ?", CateId).Find(&category).Error; err != nil {
+		log.WithError(err).Errorf("mysql query category error: %s", CateId)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query category error"})
+		return
+	}
 	result.Id = category.Id
 	result.Name = category.Name
-	result.Picture = category.Picture
+	result.Picture = c.storageClient.ObjectURL(category.Picture)
 
 	var goods []model.Goods
-	DB.Table("goods").Where("cate_Id = ? AND is_sold=?", CateId, false).Find(&goods)
-	result.Goods = append(result.Goods, goods...)
+	if err := c.db.Where("cate_id = ? AND is_sold = ?", CateId, false).Find(&goods).Error; err != nil {
+		log.WithError(err).Errorf("mysql query goods error: %s", CateId)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query goods error"})
+		return
+	}
+	result.Goods = append(result.Goods, c.renderGoods(goods)...)
 
 	ctx.JSON(200, gin.H{
 		"code":   "1",
 		"msg":    "获取分类下属物品成功",
 		"result": result,
 	})
+}
 
+// renderGoods 返回图片渲染成访问地址的商品副本，数据库和缓存中保存的仍然是对象key
+func (c *GoodsController) renderGoods(goods []model.Goods) []model.Goods {
+	rendered := make([]model.Goods, len(goods))
+	for i, g := range goods {
+		g.Picture = c.storageClient.ObjectURL(g.Picture)
+		rendered[i] = g
+	}
+	return rendered
+}
+
+func (c *GoodsController) renderHomeGoods(result []api.Goods) []api.Goods {
+	rendered := make([]api.Goods, len(result))
+	for i, g := range result {
+		g.Picture = c.storageClient.ObjectURL(g.Picture)
+		g.Goods = c.renderGoods(g.Goods)
+		rendered[i] = g
+	}
+	return rendered
 }
 
 type GoodInfo struct { //用于接收body参数
@@ -280,8 +372,8 @@ func transApiGood(good model.Goods) apiGood {
 	return apiGood{Id: good.ID, Name: good.Name, Desc: good.Name, Price: good.Price, Picture: good.Picture}
 }
 
-func RecommendGoods(ctx *gin.Context) {
-	DB := database.GetDB()
+func (c *GoodsController) relevant(ctx *gin.Context) {
+	DB := c.db
 	strLimit := ctx.DefaultQuery("limit", "4")
 	intLimit, err := strconv.Atoi(strLimit)
 	if err != nil {
@@ -323,6 +415,7 @@ func RecommendGoods(ctx *gin.Context) {
 				idRecord[i] = id
 				notSoldGoodArray[index].IsSold = true
 				result[i] = transApiGood(notSoldGoodArray[index])
+				result[i].Picture = c.storageClient.ObjectURL(result[i].Picture)
 				break
 			}
 		}
@@ -355,3 +448,8 @@ func minNum(limit int, total int) int {
 		return total
 	}
 }
+
+// homeGoodsCacheKey 首页分类商品的缓存 key，分类数量变化时自动换用新的 key
+func homeGoodsCacheKey(categories int64) string {
+	return fmt.Sprintf("home/goods_%d", categories)
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+
+	"smile.expression/destiny/pkg/cache"
+	"smile.expression/destiny/pkg/constant"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/storage"
+)
+
+func TestMain(m *testing.M) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	logger.SmileLog = &logger.SmileLogger{Logger: log}
+	gin.SetMode(gin.TestMode)
+
+	os.Exit(m.Run())
+}
+
+// newTestDB 每个测试使用单独的 sqlite 文件，models 为需要建的表
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestStorageClient 本地磁盘存储，只有默认桶 picture
+func newTestStorageClient(t *testing.T) *storage.Client {
+	t.Helper()
+
+	return storage.NewClient(&storage.Options{
+		Driver:  storage.DriverLocal,
+		Buckets: []string{"picture"},
+		Local: &storage.LocalOptions{
+			Root:    t.TempDir(),
+			BaseURL: "http://localhost:8080/storage/file",
+		},
+	})
+}
+
+// newTestCache 返回内存 redis，用 miniredis.FastForward 模拟过期
+func newTestCache(t *testing.T) (*cache.Client, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	return cache.NewClient(&cache.Options{Addr: mr.Addr()}), mr
+}
+
+// serve 发送 JSON 请求，token 为空时不带 Authorization
+func serve(r *gin.Engine, method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set(constant.Authorization, "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// assertStatus 状态码不对时打印响应体
+func assertStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d, body: %s", w.Code, want, w.Body.String())
+	}
+}
@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	model2 "smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+)
+
+var (
+	errInvalidRecipient = errors.New("invalid recipient")
+	errInvalidContent   = errors.New("invalid content")
+	errInvalidImage     = errors.New("invalid image")
+	errInvalidGoods     = errors.New("goods not found")
+	errInvalidOrder     = errors.New("invalid order")
+	errNoConversation   = errors.New("conversation not found")
+)
+
+// messageInput 客户端发送的消息，Content 是文本或图片地址，RefID 是商品或订单 ID
+type messageInput struct {
+	Type    string
+	Content string
+	RefID   uint
+}
+
+// isMessageError 是否为客户端输入错误，这类错误原样返回给客户端
+func isMessageError(err error) bool {
+	for _, target := range []error{errInvalidRecipient, errInvalidContent, errInvalidImage, errInvalidGoods, errInvalidOrder, errNoConversation, errBlocked, errModerated} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildMessage 校验消息内容，返回待保存的记录
+func (c *ChatController) buildMessage(from uint, to uint, input *messageInput) (*model2.Message, error) {
+	message := &model2.Message{SenderID: from, Type: input.Type}
+
+	switch input.Type {
+	case "", model2.MessageText:
+		if strings.TrimSpace(input.Content) == "" || utf8.RuneCountInString(input.Content) > c.options.MaxTextLength {
+			return nil, errInvalidContent
+		}
+		message.Type = model2.MessageText
+		message.Content = input.Content
+
+	case model2.MessageImage:
+		key, err := c.imageKey(from, input.Content)
+		if err != nil {
+			return nil, err
+		}
+		message.Content = key
+
+	case model2.MessageGoods:
+		// 已下架的商品不能再分享
+		var goods model2.Goods
+		if err := c.db.Where("id = ?", input.RefID).First(&goods).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errInvalidGoods
+			}
+			return nil, err
+		}
+		message.RefID = goods.ID
+
+	case model2.MessageOrder:
+		if err := c.checkOrder(from, to, input.RefID); err != nil {
+			return nil, err
+		}
+		message.RefID = input.RefID
+
+	default:
+		return nil, errInvalidContent
+	}
+	return message, nil
+}
+
+// imageKey 图片必须是发送方自己上传的对象，数据库中只保存对象 key
+func (c *ChatController) imageKey(userID uint, value string) (string, error) {
+	key := c.storageClient.ObjectKey(value)
+	if key == "" || strings.Contains(key, "://") {
+		return "", errInvalidImage
+	}
+
+	bucketName, objectName, err := c.storageClient.ParseURL(value)
+	if err != nil {
+		return "", errInvalidImage
+	}
+
+	// 旧对象的上传记录在迁移时已经补上
+	var count int64
+	if err = c.db.Model(&model2.StorageObject{}).
+		Where("user_id = ? AND bucket = ? AND object = ?", userID, bucketName, objectName).
+		Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", errInvalidImage
+	}
+	return key, nil
+}
+
+// checkOrder 订单只能在买卖双方之间分享
+func (c *ChatController) checkOrder(from uint, to uint, orderID uint) error {
+	var order model2.Order
+	if err := c.db.First(&order, orderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidOrder
+		}
+		return err
+	}
+
+	var goods model2.Goods
+	if err := c.db.Unscoped().Where("id = ?", order.GoodId).First(&goods).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidOrder
+		}
+		return err
+	}
+	sellerID, err := strconv.ParseUint(goods.User, 10, 64)
+	if err != nil {
+		return errInvalidOrder
+	}
+
+	parties := map[uint]bool{order.UserId: true, uint(sellerID): true}
+	if !parties[from] || !parties[to] {
+		return errInvalidOrder
+	}
+	return nil
+}
+
+// renderMessages 转换为响应，图片生成访问地址，商品和订单卡片批量查询最新数据
+func (c *ChatController) renderMessages(messages []model2.Message) ([]api.ChatMessage, error) {
+	var goodsIDs, orderIDs []uint
+	for _, message := range messages {
+		switch message.Type {
+		case model2.MessageGoods:
+			goodsIDs = append(goodsIDs, message.RefID)
+		case model2.MessageOrder:
+			orderIDs = append(orderIDs, message.RefID)
+		}
+	}
+
+	orders := make(map[uint]*model2.Order, len(orderIDs))
+	if len(orderIDs) > 0 {
+		var rows []model2.Order
+		if err := c.db.Where("id IN ?", orderIDs).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			orders[rows[i].ID] = &rows[i]
+			if goodsID, err := strconv.ParseUint(rows[i].GoodId, 10, 64); err == nil {
+				goodsIDs = append(goodsIDs, uint(goodsID))
+			}
+		}
+	}
+
+	goods, err := c.goodsCards(goodsIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]api.ChatMessage, 0, len(messages))
+	for i := range messages {
+		item := toChatMessage(&messages[i])
+		switch messages[i].Type {
+		case model2.MessageImage:
+			item.Image = c.storageClient.ObjectURL(messages[i].Content)
+		case model2.MessageGoods:
+			item.Goods = goods[messages[i].RefID]
+			if item.Goods == nil {
+				item.Goods = &api.GoodsCard{ID: messages[i].RefID, Removed: true}
+			}
+		case model2.MessageOrder:
+			if order, ok := orders[messages[i].RefID]; ok {
+				item.Order = &api.OrderCard{ID: order.ID, Status: order.Status, PayMoney: order.PayMoney}
+				if goodsID, err := strconv.ParseUint(order.GoodId, 10, 64); err == nil {
+					item.Order.Goods = goods[uint(goodsID)]
+				}
+			} else {
+				item.Order = &api.OrderCard{ID: messages[i].RefID}
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// goodsCards 批量查询商品卡片，已删除的商品也要返回并标记 Removed
+func (c *ChatController) goodsCards(ids []uint) (map[uint]*api.GoodsCard, error) {
+	cards := make(map[uint]*api.GoodsCard, len(ids))
+	if len(ids) == 0 {
+		return cards, nil
+	}
+
+	var rows []model2.Goods
+	if err := c.db.Unscoped().Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		cards[row.ID] = &api.GoodsCard{
+			ID:      row.ID,
+			Name:    row.Name,
+			Price:   row.Price,
+			Picture: c.storageClient.ObjectURL(row.Picture),
+			IsSold:  row.IsSold,
+			Removed: row.DeletedAt.Valid,
+		}
+	}
+	return cards, nil
+}
+
+// messagePreview 会话列表和旧版接口中展示的文字
+func messagePreview(message *model2.Message) string {
+	switch message.Type {
+	case model2.MessageImage:
+		return "[图片]"
+	case model2.MessageGoods:
+		return "[商品]"
+	case model2.MessageOrder:
+		return "[订单]"
+	default:
+		return message.Content
+	}
+}
+
+func toChatMessage(message *model2.Message) api.ChatMessage {
+	return api.ChatMessage{
+		ID:             message.ID,
+		MessageID:      message.UUID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		Type:           message.Type,
+		Content:        messagePreview(message),
+		Delivered:      message.Delivered,
+		CreatedAt:      message.CreatedAt,
+	}
+}
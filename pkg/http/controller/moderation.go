@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/moderation"
+)
+
+var errModerated = errors.New("content not allowed")
+
+// flagContent 命中 flag 规则的内容记录到审核队列，content 保存打码前的原文
+func flagContent(ctx context.Context, db *gorm.DB, scope moderation.Scope, userID, targetID uint, content string, result *moderation.Result) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if result.Action != moderation.ActionFlag {
+		return
+	}
+
+	flag := model.ModerationFlag{
+		Scope:    string(scope),
+		UserID:   userID,
+		TargetID: targetID,
+		Content:  content,
+		Rules:    strings.Join(result.Rules, ","),
+		Status:   model.FlagOpen,
+	}
+	if err := db.Create(&flag).Error; err != nil {
+		log.WithError(err).Errorf("mysql create moderation flag error: %s/%d", scope, targetID)
+		return
+	}
+	log.Infof("content flagged: %s/%d, rules: %s", scope, targetID, flag.Rules)
+}
+
+// mergeVerdicts 合并同一内容多个字段的审核结果，任一字段需要复查时整体复查
+func mergeVerdicts(results ...*moderation.Result) *moderation.Result {
+	merged := &moderation.Result{Action: moderation.ActionAllow}
+	for _, result := range results {
+		merged.Rules = append(merged.Rules, result.Rules...)
+		if result.Action == moderation.ActionFlag {
+			merged.Action = moderation.ActionFlag
+		}
+	}
+	return merged
+}
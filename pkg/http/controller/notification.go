@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/auth"
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/notification"
+)
+
+// NotificationController 系统通知收件箱
+type NotificationController struct {
+	options        *NotificationControllerOptions
+	r              *gin.Engine
+	db             *gorm.DB
+	notifier       *notification.Notifier
+	authController *AuthController
+}
+
+type NotificationControllerOptions struct {
+	ReminderInterval int `json:"reminderInterval"` // 检查未确认收货订单的间隔（秒），0 表示不检查
+	UnconfirmedAfter int `json:"unconfirmedAfter"` // 下单超过该时间（秒）仍未确认收货时提醒买家，默认 7 天
+}
+
+func NewNotificationController(options *NotificationControllerOptions, r *gin.Engine, db *gorm.DB, notifier *notification.Notifier, authController *AuthController) *NotificationController {
+	if options == nil {
+		options = &NotificationControllerOptions{}
+	}
+	if options.UnconfirmedAfter <= 0 {
+		options.UnconfirmedAfter = 7 * 24 * 3600
+	}
+
+	return &NotificationController{
+		options:        options,
+		r:              r,
+		db:             db,
+		notifier:       notifier,
+		authController: authController,
+	}
+}
+
+func (c *NotificationController) Register() {
+	rg := c.r.Group("/member/notifications", c.authController.AuthMiddleware())
+
+	rg.GET("", c.listNotifications)
+	rg.GET("/unread_count", c.unreadCount)
+	rg.POST("/:id/read", c.readNotification)
+	rg.POST("/read_all", c.readAll)
+}
+
+// listNotifications 按时间倒序分页，unread=1 时只返回未读
+func (c *NotificationController) listNotifications(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	page, size := pageParams(ctx)
+	query := c.db.Model(&model.Notification{}).Where("user_id = ?", userInfo.ID)
+	if ctx.Query("unread") == "1" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithError(err).Errorf("mysql count notifications error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	var records []model.Notification
+	if err := query.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&records).Error; err != nil {
+		log.WithError(err).Errorf("mysql query notifications error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	items := make([]api.Notification, 0, len(records))
+	for i := range records {
+		items = append(items, notification.ToAPI(&records[i]))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"total": total, "items": items}})
+}
+
+// unreadCount 未读数，用于角标
+func (c *NotificationController) unreadCount(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	var count int64
+	if err := c.db.Model(&model.Notification{}).Where("user_id = ? AND read_at IS NULL", userInfo.ID).Count(&count).Error; err != nil {
+		log.WithError(err).Errorf("mysql count unread notifications error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"count": count}})
+}
+
+func (c *NotificationController) readNotification(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	var record model.Notification
+	if err := c.db.Where("id = ? AND user_id = ?", ctx.Param("id"), userInfo.ID).First(&record).Error; err != nil {
+		abortQuery(ctx, err, "notification not found")
+		return
+	}
+
+	if record.ReadAt == nil {
+		if err := c.db.Model(&record).Update("read_at", time.Now()).Error; err != nil {
+			log.WithError(err).Errorf("mysql update notification error: %d", record.ID)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (c *NotificationController) readAll(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, _ := auth.UserFrom(ctx)
+
+	if err := c.db.Model(&model.Notification{}).Where("user_id = ? AND read_at IS NULL", userInfo.ID).
+		Update("read_at", time.Now()).Error; err != nil {
+		log.WithError(err).Errorf("mysql update notifications error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// RunReminders 定期提醒买家确认收货，每个订单只提醒一次
+func (c *NotificationController) RunReminders() {
+	if c.options.ReminderInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(c.options.ReminderInterval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.remindUnconfirmed(context.Background())
+	}
+}
+
+func (c *NotificationController) remindUnconfirmed(ctx context.Context) {
+	var (
+		log   = logger.SmileLog.WithContext(ctx)
+		after = time.Now().Add(-time.Duration(c.options.UnconfirmedAfter) * time.Second)
+	)
+
+	var orders []model.Order
+	if err := c.db.Where("status = ? AND created_at < ? AND id NOT IN (?)", model.OrderPending, after, c.remindedOrders()).
+		Order("id").Limit(100).Find(&orders).Error; err != nil {
+		log.WithError(err).Error("mysql query unconfirmed orders error")
+		return
+	}
+	if len(orders) == 0 {
+		return
+	}
+
+	goods, err := orderGoods(c.db, orders)
+	if err != nil {
+		log.WithError(err).Error("mysql query goods error")
+		return
+	}
+
+	for _, order := range orders {
+		c.notifier.Notify(ctx, order.UserId, notification.OrderUnconfirmed, order.ID, notification.Data{
+			"GoodsName": goods[order.GoodId].Name,
+			"Days":      int(time.Since(order.CreatedAt).Hours() / 24),
+		})
+	}
+}
+
+func (c *NotificationController) remindedOrders() *gorm.DB {
+	return c.db.Model(&model.Notification{}).Select("ref_id").Where("type = ?", notification.OrderUnconfirmed)
+}
@@ -0,0 +1,354 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/auth"
+	"smile.expression/destiny/pkg/cache"
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/notification"
+	"smile.expression/destiny/pkg/storage"
+)
+
+type ReviewController struct {
+	options        *ReviewControllerOptions
+	r              *gin.Engine
+	db             *gorm.DB
+	cacheClient    *cache.Client
+	storageClient  *storage.Client
+	authController *AuthController
+	notifier       *notification.Notifier
+}
+
+type ReviewControllerOptions struct {
+	EditWindow      int `json:"editWindow"`      // 评价发布后允许修改的时间（秒），默认 7 天
+	CacheExpiration int `json:"cacheExpiration"` // 评分汇总的缓存时间（秒）
+}
+
+func NewReviewController(options *ReviewControllerOptions, r *gin.Engine, db *gorm.DB, cacheClient *cache.Client, storageClient *storage.Client, authController *AuthController, notifier *notification.Notifier) *ReviewController {
+	if options == nil {
+		options = &ReviewControllerOptions{CacheExpiration: -1}
+	}
+
+	return &ReviewController{
+		options:        options,
+		r:              r,
+		db:             db,
+		cacheClient:    cacheClient,
+		storageClient:  storageClient,
+		authController: authController,
+		notifier:       notifier,
+	}
+}
+
+func (c *ReviewController) Register() {
+	rg := c.r.Group("")
+
+	rg.GET("/user/:id/reviews", c.listReviews)
+
+	rg2 := c.r.Group("/member", c.authController.AuthMiddleware())
+
+	rg2.POST("/order/:id/review", c.createReview)
+	rg2.PUT("/review/:id", c.updateReview)
+}
+
+func (c *ReviewController) createReview(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not exist"})
+		return
+	}
+
+	var req api.ReviewRequest
+	if err := ctx.BindJSON(&req); err != nil || !validReview(&req) {
+		log.WithError(err).Error("create review bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid review"})
+		return
+	}
+
+	var order model.Order
+	if err := c.db.First(&order, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "order not found")
+		return
+	}
+
+	if order.Status != model.OrderCompleted {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "order not completed"})
+		return
+	}
+
+	var goods model.Goods
+	if err := c.db.Unscoped().Where("id = ?", order.GoodId).First(&goods).Error; err != nil {
+		abortQuery(ctx, err, "goods not found")
+		return
+	}
+	sellerID, err := strconv.ParseUint(goods.User, 10, 64)
+	if err != nil {
+		log.WithError(err).Errorf("invalid seller id: %s", goods.User)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "invalid seller"})
+		return
+	}
+
+	// 买家评价卖家，卖家评价买家
+	review := model.Review{
+		OrderID:    order.ID,
+		ReviewerID: userInfo.ID,
+		Rating:     req.Rating,
+		Content:    req.Content,
+	}
+	switch userInfo.ID {
+	case order.UserId:
+		review.Role = model.ReviewerBuyer
+		review.RevieweeID = uint(sellerID)
+	case uint(sellerID):
+		review.Role = model.ReviewerSeller
+		review.RevieweeID = order.UserId
+	default:
+		log.Errorf("review forbidden: %d, order: %d", userInfo.ID, order.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return
+	}
+
+	var count int64
+	if err = c.db.Model(&model.Review{}).Where("order_id = ? AND reviewer_id = ?", order.ID, userInfo.ID).Count(&count).Error; err != nil {
+		log.WithError(err).Errorf("mysql count review error: %d", order.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if count > 0 {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "already reviewed"})
+		return
+	}
+
+	if err = c.db.Create(&review).Error; err != nil {
+		log.WithError(err).Errorf("mysql create review error: %d", order.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create error"})
+		return
+	}
+	c.invalidateSummary(ctx0, review.RevieweeID)
+	c.notifier.Notify(ctx0, review.RevieweeID, notification.ReviewReceived, review.ID, notification.Data{
+		"Reviewer": userInfo.Name,
+		"Rating":   review.Rating,
+		"Content":  review.Content,
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"id": review.ID}})
+}
+
+func (c *ReviewController) updateReview(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not exist"})
+		return
+	}
+
+	var req api.ReviewRequest
+	if err := ctx.BindJSON(&req); err != nil || !validReview(&req) {
+		log.WithError(err).Error("update review bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid review"})
+		return
+	}
+
+	var review model.Review
+	if err := c.db.First(&review, ctx.Param("id")).Error; err != nil {
+		abortQuery(ctx, err, "review not found")
+		return
+	}
+
+	if review.ReviewerID != userInfo.ID {
+		log.Errorf("update review forbidden: %d, review: %d", userInfo.ID, review.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return
+	}
+
+	if time.Since(review.CreatedAt) > c.editWindow() {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "review can no longer be edited"})
+		return
+	}
+
+	if err := c.db.Model(&review).Updates(map[string]interface{}{
+		"rating":  req.Rating,
+		"content": req.Content,
+	}).Error; err != nil {
+		log.WithError(err).Errorf("mysql update review error: %d", review.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+	c.invalidateSummary(ctx0, review.RevieweeID)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// listReviews 用户收到的评价，按时间倒序分页
+func (c *ReviewController) listReviews(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	page, size := pageParams(ctx)
+	query := c.db.Model(&model.Review{}).Where("reviewee_id = ?", userID)
+
+	var total int64
+	if err = query.Count(&total).Error; err != nil {
+		log.WithError(err).Errorf("mysql count reviews error: %d", userID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	var reviews []model.Review
+	if err = query.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&reviews).Error; err != nil {
+		log.WithError(err).Errorf("mysql query reviews error: %d", userID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	items, err := c.toReviews(reviews)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query reviewers error: %d", userID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": gin.H{"total": total, "items": items}})
+}
+
+// Summary 用户收到的评分汇总，结果缓存在 redis 中，评价变化时删除
+func (c *ReviewController) Summary(ctx context.Context, userID uint) (*api.RatingSummary, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+		key = ratingSummaryKey(userID)
+	)
+
+	var summary api.RatingSummary
+
+	data, err := c.cacheClient.Get(ctx, key)
+	if err == nil {
+		if err = json.Unmarshal(data, &summary); err != nil {
+			log.WithError(err).Error("failed to unmarshal rating summary")
+		} else {
+			return &summary, nil
+		}
+	}
+
+	var row struct {
+		Rating float64
+		Count  int64
+	}
+	if err = c.db.Model(&model.Review{}).
+		Select("COALESCE(AVG(rating), 0) AS rating, COUNT(*) AS count").
+		Where("reviewee_id = ?", userID).
+		Scan(&row).Error; err != nil {
+		return nil, err
+	}
+	summary.Rating = row.Rating
+	summary.Count = row.Count
+
+	cacheData, err := json.Marshal(&summary)
+	if err != nil {
+		log.WithError(err).Error("failed to marshal rating summary")
+		return &summary, nil
+	}
+	if err = c.cacheClient.Set(ctx, key, cacheData, c.options.CacheExpiration); err != nil {
+		log.WithError(err).Error("redis set rating summary error")
+	}
+	return &summary, nil
+}
+
+// RecentReviews 用户最近收到的评价
+func (c *ReviewController) RecentReviews(userID uint, limit int) ([]api.Review, error) {
+	var reviews []model.Review
+	if err := c.db.Where("reviewee_id = ?", userID).Order("id DESC").Limit(limit).Find(&reviews).Error; err != nil {
+		return nil, err
+	}
+	return c.toReviews(reviews)
+}
+
+// toReviews 转换为响应并附上评价人的公开信息
+func (c *ReviewController) toReviews(reviews []model.Review) ([]api.Review, error) {
+	ids := make([]uint, 0, len(reviews))
+	for _, review := range reviews {
+		ids = append(ids, review.ReviewerID)
+	}
+
+	reviewers := make(map[uint]*model.User, len(ids))
+	if len(ids) > 0 {
+		var users []model.User
+		if err := c.db.Where("id IN ?", ids).Find(&users).Error; err != nil {
+			return nil, err
+		}
+		for i := range users {
+			reviewers[users[i].ID] = &users[i]
+		}
+	}
+
+	items := make([]api.Review, 0, len(reviews))
+	for _, review := range reviews {
+		item := api.Review{
+			ID:        review.ID,
+			OrderID:   review.OrderID,
+			Role:      review.Role,
+			Rating:    review.Rating,
+			Content:   review.Content,
+			CreatedAt: review.CreatedAt,
+			UpdatedAt: review.UpdatedAt,
+		}
+		if reviewer, ok := reviewers[review.ReviewerID]; ok {
+			item.Reviewer = toPublicUser(c.storageClient, reviewer)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (c *ReviewController) invalidateSummary(ctx context.Context, userID uint) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if err := c.cacheClient.Delete(ctx, ratingSummaryKey(userID)); err != nil {
+		log.WithError(err).Errorf("redis delete rating summary error: %d", userID)
+	}
+}
+
+func (c *ReviewController) editWindow() time.Duration {
+	if c.options.EditWindow > 0 {
+		return time.Duration(c.options.EditWindow) * time.Second
+	}
+	return 7 * 24 * time.Hour
+}
+
+func validReview(req *api.ReviewRequest) bool {
+	return req.Rating >= 1 && req.Rating <= 5 && utf8.RuneCountInString(req.Content) <= 1024
+}
+
+func ratingSummaryKey(userID uint) string {
+	return fmt.Sprintf("review/score/%d", userID)
+}
@@ -1,17 +1,26 @@
 package controller
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/minio/minio-go/v7"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"smile.expression/destiny/pkg/auth"
 	"smile.expression/destiny/pkg/constant"
 	"smile.expression/destiny/pkg/database/model"
 	"smile.expression/destiny/pkg/http/api"
@@ -19,15 +28,27 @@ import (
 	"smile.expression/destiny/pkg/storage"
 )
 
+var errObjectForbidden = errors.New("object does not belong to user")
+
+// maxPartNumber S3 协议允许的最大分片序号
+const maxPartNumber = 10000
+
 type StorageController struct {
+	options        *StorageControllerOptions
 	r              *gin.Engine
 	db             *gorm.DB
 	storageClient  *storage.Client
 	authController *AuthController
 }
 
-func NewStorageController(r *gin.Engine, db *gorm.DB, storageClient *storage.Client, authController *AuthController) *StorageController {
+type StorageControllerOptions struct {
+	GCInterval          int `json:"gcInterval"`          // 清理无引用对象和过期分片上传的间隔（秒），0 表示不清理
+	MultipartExpiration int `json:"multipartExpiration"` // 分片上传超过该时间（秒）未完成视为过期，0 表示不过期
+}
+
+func NewStorageController(options *StorageControllerOptions, r *gin.Engine, db *gorm.DB, storageClient *storage.Client, authController *AuthController) *StorageController {
 	return &StorageController{
+		options:        options,
 		r:              r,
 		db:             db,
 		storageClient:  storageClient,
@@ -38,20 +59,64 @@ func NewStorageController(r *gin.Engine, db *gorm.DB, storageClient *storage.Cli
 func (c *StorageController) Register() {
 	rg := c.r.Group("/storage")
 
-	rg.PUT("/upload", c.upload)
-	rg.DELETE("/remove", c.remove)
+	rg.PUT("/upload", c.authController.AuthMiddleware(), c.upload)
+	rg.DELETE("/remove", c.authController.AuthMiddleware(), c.remove)
+	// 对象按公开读处理，与 MinIO 公开桶的直链保持一致
+	rg.GET("/file/:bucket/*object", c.file)
+
+	// 分片上传：初始化、上传分片、查询已上传分片、完成、取消
+	rg.POST("/multipart", c.authController.AuthMiddleware(), c.initiateMultipart)
+	rg.PUT("/multipart/:uploadId/:partNumber", c.authController.AuthMiddleware(), c.uploadPart)
+	rg.GET("/multipart/:uploadId", c.authController.AuthMiddleware(), c.listParts)
+	rg.POST("/multipart/:uploadId/complete", c.authController.AuthMiddleware(), c.completeMultipart)
+	rg.DELETE("/multipart/:uploadId", c.authController.AuthMiddleware(), c.abortMultipart)
 
 	rg2 := c.r.Group("/image")
 	rg2.POST("/upload", c.authController.AuthMiddleware(), c.multiUpload)
-	rg2.GET("/get", c.get)
-	rg2.DELETE("/delete", c.delete)
+	rg2.GET("/get", c.authController.AuthMiddleware(), c.get)
+	rg2.DELETE("/delete", c.authController.AuthMiddleware(), c.delete)
 }
 
 func (c *StorageController) get(ctx *gin.Context) {
 	// 从上下文中获取查询参数"id"
 	uri := ctx.Query("id")
 
-	ctx.JSON(http.StatusOK, gin.H{"id": c.storageClient.SetEndpoint(uri)})
+	ctx.JSON(http.StatusOK, gin.H{"id": c.storageClient.ObjectURL(uri)})
+}
+
+// file 通过应用下载对象，本地存储驱动的对象地址指向这里
+func (c *StorageController) file(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	bucketName := ctx.Param("bucket")
+	objectName := strings.TrimPrefix(ctx.Param("object"), "/")
+	if objectName == "" {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid object name"})
+		return
+	}
+	// 只开放配置的桶，本地驱动的内部目录不能通过这里读取
+	if !c.storageClient.IsBucket(bucketName) {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "object not found"})
+		return
+	}
+
+	reader, info, err := c.storageClient.GetObject(ctx0, bucketName, objectName)
+	if err != nil {
+		log.WithError(err).Errorf("error getting object %s/%s", bucketName, objectName)
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "object not found"})
+		return
+	}
+	defer func() {
+		if err = reader.Close(); err != nil {
+			log.WithError(err).Error("error closing object")
+		}
+	}()
+
+	ctx.Header("ETag", info.ETag)
+	ctx.DataFromReader(http.StatusOK, info.Size, info.ContentType, reader, nil)
 }
 
 func (c *StorageController) multiUpload(ctx *gin.Context) {
@@ -60,13 +125,12 @@ func (c *StorageController) multiUpload(ctx *gin.Context) {
 		log  = logger.SmileLog.WithContext(ctx0)
 	)
 
-	user, exists := ctx.Get("user")
+	userInfo, exists := auth.UserFrom(ctx)
 	if !exists {
 		log.Error("need to login")
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "need to login"})
 		return
 	}
-	userInfo := user.(*model.User)
 	// 使用c.MultipartForm()从上下文中检索多部分表单数据
 	form, err := ctx.MultipartForm()
 	if err != nil {
@@ -89,18 +153,19 @@ func (c *StorageController) multiUpload(ctx *gin.Context) {
 				continue
 			}
 
+			// 对象名以用户ID开头，删除时据此校验归属
+			objectName := fmt.Sprintf("%d/%s/%s", userInfo.ID, time.Now().Format("2006-01-02"), uuid.New().String())
 			var resp *api.PutObjectResponse
-			objectName := fmt.Sprintf("%s/%s/%s", userInfo.Name, time.Now().Format("2006-01-02"), uuid.New().String())
-			resp, err = c.storageClient.PutObject(ctx0, "picture", objectName, content, fileHeader.Size, minio.PutObjectOptions{
-				ContentType: fileHeader.Header.Get(constant.ContentType),
-			})
-
-			if err = content.Close(); err != nil {
-				log.WithError(err).Error("failed to close multipart file")
-				return
+			resp, err = c.putObject(ctx0, userInfo, "picture", objectName, content, fileHeader)
+			if errClose := content.Close(); errClose != nil {
+				log.WithError(errClose).Error("failed to close multipart file")
+			}
+			if err != nil {
+				log.WithError(err).Error("failed to upload multipart file")
+				continue
 			}
 
-			// 将成功上传的图像ID添加到imageIds切片中
+			// 将成功上传的图像ID添加到imageIds切片中，内容重复时是已有对象的ID
 			URLs = append(URLs, c.storageClient.GetObjectName(resp.URL))
 		}
 	}
@@ -113,16 +178,25 @@ func (c *StorageController) upload(ctx *gin.Context) {
 		log  = logger.SmileLog.WithContext(ctx0)
 	)
 
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		log.Error("need to login")
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "need to login"})
+		return
+	}
+
 	file, err := ctx.FormFile("file")
 	if err != nil {
 		log.WithError(err).Error("error getting file from form")
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	content, err := file.Open()
 	if err != nil {
 		log.WithError(err).Error("error opening file")
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 	defer func(content multipart.File) {
 		if err = content.Close(); err != nil {
@@ -130,12 +204,13 @@ func (c *StorageController) upload(ctx *gin.Context) {
 		}
 	}(content)
 
-	resp, err := c.storageClient.PutObject(ctx0, "picture", file.Filename, content, file.Size, minio.PutObjectOptions{
-		ContentType: file.Header.Get(constant.ContentType),
-	})
+	// 对象名不能用原文件名，同名不同内容的文件会覆盖已去重的对象
+	objectName := fmt.Sprintf("%d/%s/%s%s", userInfo.ID, time.Now().Format("2006-01-02"), uuid.New().String(), path.Ext(file.Filename))
+	resp, err := c.putObject(ctx0, userInfo, "picture", objectName, content, file)
 	if err != nil {
 		log.WithError(err).Error("error uploading file")
 		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"result": resp})
@@ -147,21 +222,23 @@ func (c *StorageController) delete(ctx *gin.Context) {
 		log  = logger.SmileLog.WithContext(ctx0)
 	)
 
-	username := ctx.GetString("user")
-	// TODO
-	username = "smile"
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		log.Error("need to login")
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "need to login"})
+		return
+	}
 
 	bucketName := "picture"
 	objectName := ctx.Query("id")
-	if objectName == "" || !strings.HasPrefix(objectName, username+"/") {
+	if objectName == "" {
 		log.Errorf("invalid object name %s", objectName)
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid object name " + objectName})
 		return
 	}
 
-	if err := c.storageClient.RemoveObject(ctx0, bucketName, objectName, minio.RemoveObjectOptions{}); err != nil {
-		log.WithError(err).Error("error deleting file")
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := c.removeObject(ctx0, userInfo, bucketName, objectName); err != nil {
+		c.abortRemove(ctx, err)
 		return
 	}
 
@@ -174,20 +251,530 @@ func (c *StorageController) remove(ctx *gin.Context) {
 		log  = logger.SmileLog.WithContext(ctx0)
 	)
 
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		log.Error("need to login")
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "need to login"})
+		return
+	}
+
 	var req api.RemoveObjectRequest
 	if err := ctx.BindJSON(&req); err != nil {
 		log.WithError(err).Error("error parsing request")
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	bucketName, objectName, err := c.storageClient.ParseURL(req.URL)
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = c.removeObject(ctx0, userInfo, bucketName, objectName); err != nil {
+		c.abortRemove(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// putObject 上传对象并记录上传者，内容相同的对象只保存一份，引用计数加一
+func (c *StorageController) putObject(ctx context.Context, user *model.User, bucketName string, objectName string, content multipart.File, fileHeader *multipart.FileHeader) (*api.PutObjectResponse, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	hash, err := contentHash(content)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.reuseBlob(user, bucketName, hash)
+	if err != nil {
+		return nil, err
+	}
+	if resp != nil {
+		log.Infof("reuse object %s/%s for hash %s", bucketName, c.storageClient.GetObjectName(resp.URL), hash)
+		return resp, nil
+	}
+
+	resp, err = c.storageClient.PutObject(ctx, bucketName, objectName, content, fileHeader.Size, storage.PutOptions{
+		ContentType: fileHeader.Header.Get(constant.ContentType),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&model.StorageBlob{
+			Bucket:   bucketName,
+			Hash:     hash,
+			Object:   objectName,
+			ETag:     resp.ETag,
+			Size:     resp.Size,
+			RefCount: 1,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.StorageObject{
+			UserID: user.ID,
+			Bucket: bucketName,
+			Object: objectName,
+			Hash:   hash,
+		}).Error
+	}); err != nil {
+		// 记录写入失败时对象没有任何引用，直接删掉
+		if errRemove := c.storageClient.RemoveObject(ctx, bucketName, objectName); errRemove != nil {
+			log.WithError(errRemove).Error("failed to remove unreferenced object")
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// reuseBlob 已经存在相同内容的对象时增加引用并返回该对象，不存在时返回 nil
+func (c *StorageController) reuseBlob(user *model.User, bucketName string, hash string) (*api.PutObjectResponse, error) {
+	var blob model.StorageBlob
+	if err := c.db.Where("bucket = ? AND hash = ?", bucketName, hash).First(&blob).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	reused := false
+	if err := c.db.Transaction(func(tx *gorm.DB) error {
+		// blob 可能刚被 GC 删除，此时按新对象上传
+		result := tx.Model(&model.StorageBlob{}).Where("id = ?", blob.ID).Update("ref_count", gorm.Expr("ref_count + 1"))
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		reused = true
+		return tx.Create(&model.StorageObject{
+			UserID: user.ID,
+			Bucket: bucketName,
+			Object: blob.Object,
+			Hash:   hash,
+		}).Error
+	}); err != nil || !reused {
+		return nil, err
+	}
+
+	return &api.PutObjectResponse{
+		URL:  c.storageClient.URL(bucketName, blob.Object),
+		ETag: blob.ETag,
+		Size: blob.Size,
+	}, nil
+}
+
+// removeObject 校验归属后释放调用者对对象的引用并写审计记录，引用归零时才真正删除对象；
+// 管理员可以删除任意对象，并清除所有引用
+func (c *StorageController) removeObject(ctx context.Context, user *model.User, bucketName string, objectName string) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
+			constant.Route:  constant.RemoveObject,
+			constant.Bucket: bucketName,
+			constant.Object: objectName,
+			"user":          user.ID,
+		})
+	)
+
+	admin := auth.HasPermission(user, auth.PermManageStorage)
+	released := false
+	if err := c.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		released, err = c.releaseObject(tx, user, admin, bucketName, objectName)
+		return err
+	}); err != nil {
+		if errors.Is(err, errObjectForbidden) {
+			log.Warn("remove object forbidden")
+		}
+		return err
+	}
+
+	if released {
+		if err := c.storageClient.RemoveObject(ctx, bucketName, objectName); err != nil {
+			return err
+		}
+	}
+
+	if err := c.db.Create(&model.StorageAudit{
+		UserID: user.ID,
+		Action: constant.RemoveObject,
+		Bucket: bucketName,
+		Object: objectName,
+		Admin:  admin,
+	}).Error; err != nil {
+		log.WithError(err).Error("mysql create storage audit error")
+	}
+	log.WithFields(logrus.Fields{
+		"admin":    admin,
+		"released": released,
+	}).Info("audit: object removed")
+
+	return nil
+}
+
+// releaseObject 删除调用者的引用记录，返回对象是否已经没有引用、可以从存储中删除
+func (c *StorageController) releaseObject(tx *gorm.DB, user *model.User, admin bool, bucketName string, objectName string) (bool, error) {
+	query := tx.Where("bucket = ? AND object = ?", bucketName, objectName)
+	if admin {
+		if err := query.Delete(&model.StorageObject{}).Error; err != nil {
+			return false, err
+		}
+		if err := tx.Where("bucket = ? AND object = ?", bucketName, objectName).Delete(&model.StorageBlob{}).Error; err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	// 旧对象的上传记录在迁移时已经补上，没有记录说明不属于调用者
+	var record model.StorageObject
+	err := query.Where("user_id = ?", user.ID).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, errObjectForbidden
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = tx.Delete(&record).Error; err != nil {
+		return false, err
+	}
+
+	var blob model.StorageBlob
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("bucket = ? AND object = ?", bucketName, objectName).First(&blob).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 去重之前上传的对象没有 blob，按剩余的上传记录判断
+		var count int64
+		if err = tx.Model(&model.StorageObject{}).Where("bucket = ? AND object = ?", bucketName, objectName).Count(&count).Error; err != nil {
+			return false, err
+		}
+		return count == 0, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	blob.RefCount--
+	if blob.RefCount > 0 {
+		return false, tx.Model(&blob).Update("ref_count", blob.RefCount).Error
+	}
+	return true, tx.Delete(&blob).Error
+}
+
+// RunGC 定期删除引用计数已经归零的对象
+func (c *StorageController) RunGC() {
+	if c.options == nil || c.options.GCInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(c.options.GCInterval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.collectGarbage(context.Background())
+	}
+}
+
+func (c *StorageController) collectGarbage(ctx context.Context) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	c.abortStaleUploads(ctx)
+
+	var blobs []model.StorageBlob
+	if err := c.db.Where("ref_count <= 0").Find(&blobs).Error; err != nil {
+		log.WithError(err).Error("mysql query unreferenced blobs error")
+		return
+	}
+
+	for _, blob := range blobs {
+		// 先删除记录，删除成功说明期间没有新的引用，再删对象
+		result := c.db.Where("id = ? AND ref_count <= 0", blob.ID).Delete(&model.StorageBlob{})
+		if result.Error != nil {
+			log.WithError(result.Error).Errorf("mysql delete blob error: %d", blob.ID)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := c.storageClient.RemoveObject(ctx, blob.Bucket, blob.Object); err != nil {
+			log.WithError(err).Errorf("gc remove object error: %s/%s", blob.Bucket, blob.Object)
+			continue
+		}
+		log.Infof("gc removed object %s/%s", blob.Bucket, blob.Object)
+	}
+}
+
+func (c *StorageController) initiateMultipart(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		log.Error("need to login")
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "need to login"})
+		return
+	}
+
+	var req api.InitiateMultipartRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		log.WithError(err).Error("error parsing request")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	if err = c.storageClient.RemoveObject(ctx0, bucketName, objectName, minio.RemoveObjectOptions{}); err != nil {
+	bucketName := "picture"
+	objectName := fmt.Sprintf("%d/%s/%s%s", userInfo.ID, time.Now().Format("2006-01-02"), uuid.New().String(), path.Ext(req.Filename))
+	uploadID, err := c.storageClient.NewMultipartUpload(ctx0, bucketName, objectName, storage.PutOptions{
+		ContentType: req.ContentType,
+	})
+	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = c.db.Create(&model.MultipartUpload{
+		UploadID:    uploadID,
+		UserID:      userInfo.ID,
+		Bucket:      bucketName,
+		Object:      objectName,
+		ContentType: req.ContentType,
+	}).Error; err != nil {
+		log.WithError(err).Error("mysql create multipart upload error")
+		if errAbort := c.storageClient.AbortMultipartUpload(ctx0, bucketName, objectName, uploadID); errAbort != nil {
+			log.WithError(errAbort).Error("failed to abort multipart upload")
+		}
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql create multipart upload error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": &api.MultipartUploadResponse{
+		UploadID: uploadID,
+		ImageID:  objectName,
+	}})
+}
+
+func (c *StorageController) uploadPart(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	upload, ok := c.loadUpload(ctx)
+	if !ok {
+		return
+	}
+
+	partNumber, err := strconv.Atoi(ctx.Param("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		log.Errorf("invalid part number: %s", ctx.Param("partNumber"))
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid part number"})
+		return
+	}
+
+	if ctx.Request.ContentLength <= 0 {
+		ctx.AbortWithStatusJSON(http.StatusLengthRequired, gin.H{"error": "content length required"})
+		return
+	}
+
+	part, err := c.storageClient.PutObjectPart(ctx0, upload.Bucket, upload.Object, upload.UploadID, partNumber, ctx.Request.Body, ctx.Request.ContentLength)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": part})
+}
+
+func (c *StorageController) listParts(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	upload, ok := c.loadUpload(ctx)
+	if !ok {
+		return
+	}
+
+	parts, err := c.storageClient.ListObjectParts(ctx0, upload.Bucket, upload.Object, upload.UploadID)
+	if err != nil {
+		log.WithError(err).Errorf("error listing parts: %s", upload.UploadID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"result": &api.MultipartUploadResponse{
+			UploadID: upload.UploadID,
+			ImageID:  upload.Object,
+		},
+		"parts": parts,
+	})
+}
+
+func (c *StorageController) completeMultipart(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	upload, ok := c.loadUpload(ctx)
+	if !ok {
+		return
+	}
+
+	// 以服务端记录的分片为准，客户端只需在全部分片上传完成后调用
+	parts, err := c.storageClient.ListObjectParts(ctx0, upload.Bucket, upload.Object, upload.UploadID)
+	if err != nil {
+		log.WithError(err).Errorf("error listing parts: %s", upload.UploadID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(parts) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no parts uploaded"})
+		return
+	}
+	for i, part := range parts {
+		if part.PartNumber != i+1 {
+			log.Errorf("missing part %d: %s", i+1, upload.UploadID)
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("missing part %d", i+1)})
+			return
+		}
+	}
+
+	resp, err := c.storageClient.CompleteMultipartUpload(ctx0, upload.Bucket, upload.Object, upload.UploadID, parts)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&model.StorageObject{
+			UserID: upload.UserID,
+			Bucket: upload.Bucket,
+			Object: upload.Object,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(upload).Error
+	}); err != nil {
+		log.WithError(err).Error("mysql complete multipart upload error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql complete multipart upload error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": resp, "imageId": upload.Object})
+}
+
+func (c *StorageController) abortMultipart(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	upload, ok := c.loadUpload(ctx)
+	if !ok {
+		return
+	}
+
+	// 存储中已经没有这个上传（例如已经过期清理）时只需要删除记录
+	err := c.storageClient.AbortMultipartUpload(ctx0, upload.Bucket, upload.Object, upload.UploadID)
+	if err != nil && !errors.Is(err, storage.ErrUploadNotFound) {
+		log.WithError(err).Errorf("abort multipart upload error: %s", upload.UploadID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "abort multipart upload error"})
+		return
+	}
+
+	if err := c.db.Delete(upload).Error; err != nil {
+		log.WithError(err).Error("mysql delete multipart upload error")
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
 }
+
+// loadUpload 查询调用者自己的分片上传，失败时已经写好响应
+func (c *StorageController) loadUpload(ctx *gin.Context) (*model.MultipartUpload, bool) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		log.Error("need to login")
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "need to login"})
+		return nil, false
+	}
+
+	var upload model.MultipartUpload
+	if err := c.db.Where("upload_id = ? AND user_id = ?", ctx.Param("uploadId"), userInfo.ID).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		} else {
+			log.WithError(err).Error("mysql query multipart upload error")
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query multipart upload error"})
+		}
+		return nil, false
+	}
+	return &upload, true
+}
+
+// abortStaleUploads 取消超过 MultipartExpiration 仍未完成的分片上传
+func (c *StorageController) abortStaleUploads(ctx context.Context) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if c.options.MultipartExpiration <= 0 {
+		return
+	}
+
+	deadline := time.Now().Add(-time.Duration(c.options.MultipartExpiration) * time.Second)
+	var uploads []model.MultipartUpload
+	if err := c.db.Where("created_at < ?", deadline).Find(&uploads).Error; err != nil {
+		log.WithError(err).Error("mysql query stale multipart uploads error")
+		return
+	}
+
+	for i := range uploads {
+		upload := &uploads[i]
+		// 存储中已经没有这个上传时只需要删除记录
+		err := c.storageClient.AbortMultipartUpload(ctx, upload.Bucket, upload.Object, upload.UploadID)
+		if err != nil && !errors.Is(err, storage.ErrUploadNotFound) {
+			log.WithError(err).Errorf("gc abort multipart upload error: %s", upload.UploadID)
+			continue
+		}
+		if err := c.db.Delete(upload).Error; err != nil {
+			log.WithError(err).Errorf("mysql delete multipart upload error: %s", upload.UploadID)
+			continue
+		}
+		log.Infof("gc aborted stale multipart upload %s", upload.UploadID)
+	}
+}
+
+func contentHash(content io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (c *StorageController) abortRemove(ctx *gin.Context, err error) {
+	if errors.Is(err, errObjectForbidden) {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	logger.SmileLog.WithContext(ctx.Request.Context()).WithError(err).Error("error deleting file")
+	ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/textproto"
+	"testing"
+
+	"smile.expression/destiny/pkg/database/model"
+)
+
+type testFile struct {
+	*bytes.Reader
+}
+
+func (testFile) Close() error {
+	return nil
+}
+
+func newTestStorageController(t *testing.T) *StorageController {
+	t.Helper()
+
+	db := newTestDB(t, &model.StorageObject{}, &model.StorageBlob{}, &model.StorageAudit{})
+	return NewStorageController(&StorageControllerOptions{}, nil, db, newTestStorageClient(t), nil)
+}
+
+func testUser(id uint) *model.User {
+	user := &model.User{}
+	user.ID = id
+	return user
+}
+
+func putTestObject(t *testing.T, c *StorageController, user *model.User, objectName string, content string) string {
+	t.Helper()
+
+	header := &multipart.FileHeader{Size: int64(len(content)), Header: textproto.MIMEHeader{}}
+	resp, err := c.putObject(context.Background(), user, "picture", objectName, testFile{bytes.NewReader([]byte(content))}, header)
+	if err != nil {
+		t.Fatalf("putObject error: %v", err)
+	}
+	return c.storageClient.GetObjectName(resp.URL)
+}
+
+func TestStorageBlobRefCount(t *testing.T) {
+	var (
+		c     = newTestStorageController(t)
+		ctx   = context.Background()
+		alice = testUser(1)
+		bob   = testUser(2)
+		eve   = testUser(3)
+	)
+
+	first := putTestObject(t, c, alice, "a", "same content")
+	second := putTestObject(t, c, bob, "b", "same content")
+	if first != "a" || second != "a" {
+		t.Fatalf("objects = %q, %q, want both a", first, second)
+	}
+	if _, err := c.storageClient.StatObject(ctx, "picture", "b"); err == nil {
+		t.Error("duplicate content uploaded again")
+	}
+	assertRefCount(t, c, "a", 2)
+
+	if err := c.removeObject(ctx, eve, "picture", "a"); !errors.Is(err, errObjectForbidden) {
+		t.Fatalf("removeObject by other user error = %v, want %v", err, errObjectForbidden)
+	}
+	assertRefCount(t, c, "a", 2)
+
+	if err := c.removeObject(ctx, alice, "picture", "a"); err != nil {
+		t.Fatalf("removeObject error: %v", err)
+	}
+	assertRefCount(t, c, "a", 1)
+	if _, err := c.storageClient.StatObject(ctx, "picture", "a"); err != nil {
+		t.Fatalf("object removed while still referenced: %v", err)
+	}
+
+	// 同一个用户不能重复释放
+	if err := c.removeObject(ctx, alice, "picture", "a"); !errors.Is(err, errObjectForbidden) {
+		t.Fatalf("second removeObject error = %v, want %v", err, errObjectForbidden)
+	}
+
+	if err := c.removeObject(ctx, bob, "picture", "a"); err != nil {
+		t.Fatalf("removeObject error: %v", err)
+	}
+	assertRefCount(t, c, "a", 0)
+	if _, err := c.storageClient.StatObject(ctx, "picture", "a"); err == nil {
+		t.Error("object not removed after last reference released")
+	}
+
+	var audits int64
+	c.db.Model(&model.StorageAudit{}).Count(&audits)
+	if audits != 2 {
+		t.Errorf("audits = %d, want 2", audits)
+	}
+}
+
+// assertRefCount want 为 0 时 blob 应该已经删除
+func assertRefCount(t *testing.T, c *StorageController, objectName string, want int) {
+	t.Helper()
+
+	var blobs []model.StorageBlob
+	if err := c.db.Where("bucket = ? AND object = ?", "picture", objectName).Find(&blobs).Error; err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case want == 0 && len(blobs) != 0:
+		t.Errorf("blob %s still exists with ref count %d", objectName, blobs[0].RefCount)
+	case want > 0 && len(blobs) != 1:
+		t.Errorf("blob %s not found", objectName)
+	case want > 0 && blobs[0].RefCount != want:
+		t.Errorf("blob %s ref count = %d, want %d", objectName, blobs[0].RefCount, want)
+	}
+}
+
+func TestReleaseObject(t *testing.T) {
+	tests := []struct {
+		name         string
+		blob         *model.StorageBlob
+		owners       []uint
+		user         uint
+		admin        bool
+		wantReleased bool
+		wantErr      error
+		wantRecords  int64
+	}{
+		{
+			name:         "last reference",
+			blob:         &model.StorageBlob{RefCount: 1},
+			owners:       []uint{1},
+			user:         1,
+			wantReleased: true,
+		},
+		{
+			name:        "shared blob",
+			blob:        &model.StorageBlob{RefCount: 2},
+			owners:      []uint{1, 2},
+			user:        1,
+			wantRecords: 1,
+		},
+		{
+			name:        "not owner",
+			blob:        &model.StorageBlob{RefCount: 1},
+			owners:      []uint{1},
+			user:        2,
+			wantErr:     errObjectForbidden,
+			wantRecords: 1,
+		},
+		{
+			name:         "admin clears all references",
+			blob:         &model.StorageBlob{RefCount: 2},
+			owners:       []uint{1, 2},
+			user:         3,
+			admin:        true,
+			wantReleased: true,
+		},
+		{
+			name:        "legacy object still referenced",
+			owners:      []uint{1, 2},
+			user:        1,
+			wantRecords: 1,
+		},
+		{
+			name:         "legacy object last reference",
+			owners:       []uint{1},
+			user:         1,
+			wantReleased: true,
+		},
+		{
+			name:    "legacy object without record",
+			user:    1,
+			wantErr: errObjectForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestStorageController(t)
+			if tt.blob != nil {
+				tt.blob.Bucket, tt.blob.Object, tt.blob.Hash = "picture", "a", "hash"
+				if err := c.db.Create(tt.blob).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, owner := range tt.owners {
+				if err := c.db.Create(&model.StorageObject{UserID: owner, Bucket: "picture", Object: "a"}).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			released, err := c.releaseObject(c.db, testUser(tt.user), tt.admin, "picture", "a")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("releaseObject error = %v, want %v", err, tt.wantErr)
+			}
+			if released != tt.wantReleased {
+				t.Errorf("released = %v, want %v", released, tt.wantReleased)
+			}
+
+			var records int64
+			c.db.Model(&model.StorageObject{}).Where("bucket = ? AND object = ?", "picture", "a").Count(&records)
+			if records != tt.wantRecords {
+				t.Errorf("records = %d, want %d", records, tt.wantRecords)
+			}
+		})
+	}
+}
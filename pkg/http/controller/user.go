@@ -1,31 +1,73 @@
 package controller
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
-	"smile.expression/destiny/pkg/database"
+	"smile.expression/destiny/pkg/auth"
+	"smile.expression/destiny/pkg/cache"
 	"smile.expression/destiny/pkg/database/model"
 	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/http/middleware"
 	"smile.expression/destiny/pkg/logger"
-	"smile.expression/destiny/pkg/utils"
+	"smile.expression/destiny/pkg/storage"
+	"smile.expression/destiny/pkg/verification"
 )
 
 type UserController struct {
-	r  *gin.Engine
-	db *gorm.DB
+	options            *UserControllerOptions
+	r                  *gin.Engine
+	db                 *gorm.DB
+	cacheClient        *cache.Client
+	storageClient      *storage.Client
+	verificationClient *verification.Client
+	reviewController   *ReviewController
+	authController     *AuthController
 }
 
-func NewUserController(r *gin.Engine, db *gorm.DB) *UserController {
+type UserControllerOptions struct {
+	LoginMaxAttempts   int                          `json:"loginMaxAttempts"`   // 窗口内单个账号允许失败的次数
+	LoginIPMaxAttempts int                          `json:"loginIPMaxAttempts"` // 窗口内单个 IP 允许失败的次数
+	LoginWindow        int                          `json:"loginWindow"`        // 失败计数的窗口（秒）
+	LoginLockout       int                          `json:"loginLockout"`       // 第一次锁定的时长（秒），之后每次翻倍
+	LoginMaxLockout    int                          `json:"loginMaxLockout"`    // 锁定时长上限（秒）
+	RegisterRateLimit  *middleware.RateLimitOptions `json:"registerRateLimit"`
+	SendCodeRateLimit  *middleware.RateLimitOptions `json:"sendCodeRateLimit"`
+	ResetRateLimit     *middleware.RateLimitOptions `json:"resetRateLimit"`
+}
+
+func NewUserController(options *UserControllerOptions, r *gin.Engine, db *gorm.DB, cacheClient *cache.Client, storageClient *storage.Client, verificationClient *verification.Client, reviewController *ReviewController, authController *AuthController) *UserController {
+	if options == nil {
+		options = &UserControllerOptions{}
+	}
+	if options.RegisterRateLimit == nil {
+		options.RegisterRateLimit = &middleware.RateLimitOptions{Limit: 5, Window: 3600}
+	}
+	if options.SendCodeRateLimit == nil {
+		options.SendCodeRateLimit = &middleware.RateLimitOptions{Limit: 10, Window: 3600}
+	}
+	if options.ResetRateLimit == nil {
+		options.ResetRateLimit = &middleware.RateLimitOptions{Limit: 10, Window: 3600}
+	}
+
 	return &UserController{
-		r:  r,
-		db: db,
+		options:            options,
+		r:                  r,
+		db:                 db,
+		cacheClient:        cacheClient,
+		storageClient:      storageClient,
+		verificationClient: verificationClient,
+		reviewController:   reviewController,
+		authController:     authController,
 	}
 }
 
@@ -33,7 +75,18 @@ func (c *UserController) Register() {
 	rg := c.r.Group("")
 
 	rg.POST("/login", c.login)
-	rg.POST("/register", c.register)
+	rg.POST("/register", middleware.RateLimit(c.cacheClient, "register", c.options.RegisterRateLimit, middleware.ByIP), c.register)
+	rg.GET("/info", c.authController.AuthMiddleware(), c.info)
+	rg.GET("/user/:id/profile", c.profile)
+	rg.POST("/sms/send", middleware.RateLimit(c.cacheClient, "send_code", c.options.SendCodeRateLimit, middleware.ByIP), c.sendCode)
+	rg.POST("/reset_password", middleware.RateLimit(c.cacheClient, "reset_password", c.options.ResetRateLimit, middleware.ByIP), c.resetPassword)
+
+	rg2 := c.r.Group("/member", c.authController.AuthMiddleware())
+
+	rg2.POST("/update_avatar", c.updateAvatar)
+	rg2.POST("/change_password", c.changePassword)
+	rg2.POST("/change_info", c.changeInfo)
+	rg2.POST("/change_telephone", c.changeTelephone)
 }
 
 // login 登录接口函数
@@ -44,7 +97,7 @@ func (c *UserController) login(ctx *gin.Context) {
 	)
 
 	//获取参数
-	var receiveUser model.User
+	var receiveUser api.LoginRequest
 	if err := ctx.BindJSON(&receiveUser); err != nil {
 		log.WithError(err).Error("login bind json error")
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"msg": "invalid json"})
@@ -59,17 +112,31 @@ func (c *UserController) login(ctx *gin.Context) {
 	}
 
 	if len(receiveUser.Password) < 6 || len(receiveUser.Password) > 14 {
-		log.Errorf("invalid password: %s", receiveUser.Password)
+		log.Errorf("invalid password length: %s", receiveUser.Telephone)
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"msg": "invalid password"})
 		return
 	}
 
-	//验证手机号对应的用户是否存在
+	//账号被锁定或 IP 失败次数过多时直接拒绝
+	retryAfter, err := c.loginRetryAfter(ctx0, receiveUser.Telephone, ctx.ClientIP())
+	if err != nil {
+		// redis 故障时不阻塞登录
+		log.WithError(err).Errorf("check login lock error: %s", receiveUser.Telephone)
+	}
+	if retryAfter > 0 {
+		log.Errorf("login locked: %s, ip: %s", receiveUser.Telephone, ctx.ClientIP())
+		ctx.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"msg": "too many login attempts"})
+		return
+	}
+
+	//验证手机号对应的用户是否存在，用户不存在和密码错误返回相同的结果，避免探测已注册的手机号
 	var user model.User
-	if err := c.db.Where("telephone = ?", receiveUser.Telephone).First(&user).Error; err != nil {
+	if err = c.db.Where("telephone = ?", receiveUser.Telephone).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			log.WithError(err).Errorf("mysql not found user: %s", receiveUser.Telephone)
-			ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"msg": "user not found"})
+			c.recordLoginFailure(ctx0, receiveUser.Telephone, ctx.ClientIP())
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "invalid telephone or password"})
 		} else {
 			log.WithError(err).Errorf("mysql query error: %s", receiveUser.Telephone)
 			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "mysql query error"})
@@ -78,14 +145,22 @@ func (c *UserController) login(ctx *gin.Context) {
 	}
 
 	//验证密码是否正确
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(receiveUser.Password)); err != nil {
-		log.WithError(err).Errorf("password error: %s", receiveUser.Password)
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "password error"})
+	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(receiveUser.Password)); err != nil {
+		log.WithError(err).Errorf("password error: %s", receiveUser.Telephone)
+		c.recordLoginFailure(ctx0, receiveUser.Telephone, ctx.ClientIP())
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "invalid telephone or password"})
+		return
+	}
+	c.resetLoginFailures(ctx0, receiveUser.Telephone)
+
+	if user.Banned {
+		log.Errorf("banned user login: %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "user banned"})
 		return
 	}
 
 	//发放token
-	token, err := utils.ReleaseToken(user)
+	tokens, err := c.authController.IssueTokens(ctx0, user)
 	if err != nil {
 		log.WithError(err).Error("release token error")
 		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "release token error"})
@@ -93,35 +168,25 @@ func (c *UserController) login(ctx *gin.Context) {
 	}
 
 	//获取user对应的所有地址
-	var addressArray []model.UserAddress
-	if err = c.db.Where("user_id = ?", user.ID).Find(&addressArray).Error; err != nil {
+	addresses, err := listAddresses(c.db, user.ID)
+	if err != nil {
 		log.WithError(err).Errorf("mysql query user address error: %d", user.ID)
 		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "mysql query user address error"})
 		return
 	}
 
 	userResp := api.UserResponse{
-		ID:       user.ID,
-		Account:  user.Telephone,
-		Token:    token,
-		Avatar:   user.Avatar,
-		Nickname: user.Name,
-		Gender:   user.Gender,
-	}
-
-	if len(addressArray) > 0 {
-		//创建响应数组
-		var apiAddresses []api.Address
-		for _, address := range addressArray {
-			resAddress := api.Address{
-				AddressID: strconv.Itoa(int(address.ID)),
-				Receiver:  address.Receiver,
-				Contact:   address.Contact,
-				Address:   address.Address,
-			}
-			apiAddresses = append(apiAddresses, resAddress)
-		}
-		userResp.UserAddress = apiAddresses
+		ID:           user.ID,
+		Account:      user.Telephone,
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
+		Avatar:       c.storageClient.ObjectURL(user.Avatar),
+		Nickname:     user.Name,
+		Gender:       user.Gender,
+	}
+
+	if len(addresses) > 0 {
+		userResp.UserAddress = toAddresses(addresses)
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
@@ -138,7 +203,7 @@ func (c *UserController) register(ctx *gin.Context) {
 	)
 
 	//获取数据
-	var receiveUser model.User
+	var receiveUser api.RegisterRequest
 	if err := ctx.BindJSON(&receiveUser); err != nil {
 		log.WithError(err).Error()
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -159,18 +224,31 @@ func (c *UserController) register(ctx *gin.Context) {
 	}
 
 	if len(receiveUser.Password) < 6 || len(receiveUser.Password) > 14 {
-		log.Errorf("invalid password: %s", receiveUser.Password)
+		log.Errorf("invalid password length: %s", receiveUser.Telephone)
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid password"})
 		return
 	}
 
 	//验证手机号是否被注册过
-	if err := c.isTelephoneExist(receiveUser.Telephone); err != nil {
+	exists, err := c.telephoneExists(receiveUser.Telephone)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query error: %s", receiveUser.Telephone)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if exists {
 		log.Errorf("telephone number exists: %s", receiveUser.Telephone)
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "telephone number exists"})
 		return
 	}
 
+	//验证短信验证码，证明手机号属于注册者
+	if err = c.verificationClient.Verify(ctx0, verification.PurposeRegister, receiveUser.Telephone, receiveUser.Code); err != nil {
+		log.WithError(err).Errorf("verify code error: %s", receiveUser.Telephone)
+		abortVerification(ctx, err)
+		return
+	}
+
 	//对密码进行加密处理
 	hashPassword, err := bcrypt.GenerateFromPassword([]byte(receiveUser.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -184,7 +262,8 @@ func (c *UserController) register(ctx *gin.Context) {
 		Telephone: receiveUser.Telephone,
 		Password:  string(hashPassword),
 		Gender:    receiveUser.Gender,
-		Avatar:    receiveUser.Avatar,
+		Avatar:    c.storageClient.ObjectKey(receiveUser.Avatar),
+		Role:      model.RoleUser,
 	}
 	if err = c.db.Create(&newUser).Error; err != nil {
 		log.WithError(err).Error("create user failed")
@@ -193,46 +272,430 @@ func (c *UserController) register(ctx *gin.Context) {
 	}
 
 	//发放Token
-	token, err := utils.ReleaseToken(newUser)
+	tokens, err := c.authController.IssueTokens(ctx0, newUser)
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"result": &api.RegisterResponse{
-		Token: token,
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
 	}})
 }
 
-// 验证手机号是否已被注册
-func (c *UserController) isTelephoneExist(telephone string) error {
+// loginRetryAfter 返回还需要等待多久才能再次尝试登录，0 表示不限制
+func (c *UserController) loginRetryAfter(ctx context.Context, telephone string, ip string) (time.Duration, error) {
+	ttl, err := c.cacheClient.TTL(ctx, loginLockKey(telephone))
+	if err != nil || ttl > 0 {
+		return ttl, err
+	}
+
+	count, err := c.cacheClient.CountEvents(ctx, loginIPFailureKey(ip), c.loginWindow())
+	if err != nil {
+		return 0, err
+	}
+	if count >= int64(c.loginIPMaxAttempts()) {
+		return c.loginWindow(), nil
+	}
+	return 0, nil
+}
+
+// recordLoginFailure 记录一次失败的登录，账号失败次数达到上限时锁定，连续锁定时长翻倍
+func (c *UserController) recordLoginFailure(ctx context.Context, telephone string, ip string) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if _, err := c.cacheClient.RecordEvent(ctx, loginIPFailureKey(ip), c.loginWindow()); err != nil {
+		log.WithError(err).Errorf("record login failure error: %s", ip)
+	}
+
+	count, err := c.cacheClient.RecordEvent(ctx, loginFailureKey(telephone), c.loginWindow())
+	if err != nil {
+		log.WithError(err).Errorf("record login failure error: %s", telephone)
+		return
+	}
+	if count < int64(c.loginMaxAttempts()) {
+		return
+	}
+
+	// 锁定次数保留一天，一天内反复被锁定的账号锁定时间越来越长
+	lockCount, err := c.cacheClient.Incr(ctx, loginLockCountKey(telephone), int((24 * time.Hour).Seconds()))
+	if err != nil {
+		log.WithError(err).Errorf("incr login lock count error: %s", telephone)
+		return
+	}
+
+	lockout := c.loginLockout()
+	for i := int64(1); i < lockCount && lockout < c.loginMaxLockout(); i++ {
+		lockout *= 2
+	}
+	if lockout > c.loginMaxLockout() {
+		lockout = c.loginMaxLockout()
+	}
+
+	if err = c.cacheClient.Set(ctx, loginLockKey(telephone), []byte("1"), int(lockout.Seconds())); err != nil {
+		log.WithError(err).Errorf("set login lock error: %s", telephone)
+		return
+	}
+	// 锁定结束后重新计数
+	if err = c.cacheClient.Delete(ctx, loginFailureKey(telephone)); err != nil {
+		log.WithError(err).Errorf("delete login failures error: %s", telephone)
+	}
+	log.Errorf("account locked for %s: %s", lockout, telephone)
+}
+
+// resetLoginFailures 登录成功后清空账号的失败记录
+func (c *UserController) resetLoginFailures(ctx context.Context, telephone string) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if err := c.cacheClient.Delete(ctx, loginFailureKey(telephone), loginLockCountKey(telephone)); err != nil {
+		log.WithError(err).Errorf("reset login failures error: %s", telephone)
+	}
+}
+
+func (c *UserController) loginMaxAttempts() int {
+	if c.options.LoginMaxAttempts > 0 {
+		return c.options.LoginMaxAttempts
+	}
+	return 5
+}
+
+func (c *UserController) loginIPMaxAttempts() int {
+	if c.options.LoginIPMaxAttempts > 0 {
+		return c.options.LoginIPMaxAttempts
+	}
+	return 20
+}
+
+func (c *UserController) loginWindow() time.Duration {
+	if c.options.LoginWindow > 0 {
+		return time.Duration(c.options.LoginWindow) * time.Second
+	}
+	return 15 * time.Minute
+}
+
+func (c *UserController) loginLockout() time.Duration {
+	if c.options.LoginLockout > 0 {
+		return time.Duration(c.options.LoginLockout) * time.Second
+	}
+	return time.Minute
+}
+
+func (c *UserController) loginMaxLockout() time.Duration {
+	if c.options.LoginMaxLockout > 0 {
+		return time.Duration(c.options.LoginMaxLockout) * time.Second
+	}
+	return time.Hour
+}
+
+func loginFailureKey(telephone string) string {
+	return fmt.Sprintf("login/fail/account/%s", telephone)
+}
+
+func loginIPFailureKey(ip string) string {
+	return fmt.Sprintf("login/fail/ip/%s", ip)
+}
+
+func loginLockKey(telephone string) string {
+	return fmt.Sprintf("login/lock/%s", telephone)
+}
+
+func loginLockCountKey(telephone string) string {
+	return fmt.Sprintf("login/lock_count/%s", telephone)
+}
+
+// telephoneExists 验证手机号是否已被注册
+func (c *UserController) telephoneExists(telephone string) (bool, error) {
 	var user model.User
 	if err := c.db.Where("telephone = ?", telephone).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// sendCode 发送短信验证码
+func (c *UserController) sendCode(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	var req api.SendCodeRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		log.WithError(err).Error("send code bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	if len(req.Telephone) != 11 {
+		log.Errorf("invalid telephone number: %s", req.Telephone)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid telephone number"})
+		return
+	}
+
+	purpose, err := verification.ParsePurpose(req.Purpose)
+	if err != nil {
+		log.WithError(err).Errorf("invalid purpose: %s", req.Purpose)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid purpose"})
+		return
+	}
+
+	exists, err := c.telephoneExists(req.Telephone)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query error: %s", req.Telephone)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	switch purpose {
+	case verification.PurposeRegister, verification.PurposeChangeTelephone:
+		if exists {
+			log.Errorf("telephone number exists: %s", req.Telephone)
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "telephone number exists"})
+			return
+		}
+	case verification.PurposeResetPassword:
+		// 号码未注册时同样返回成功，避免被用来探测账号
+		if !exists {
+			log.Errorf("reset password for unknown telephone: %s", req.Telephone)
+			ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+			return
 		}
-		return err
 	}
-	return nil
+
+	if err = c.verificationClient.Send(ctx0, purpose, req.Telephone); err != nil {
+		log.WithError(err).Errorf("send code error: %s", req.Telephone)
+		abortVerification(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
 }
 
-func Info(ctx *gin.Context) {
-	user, _ := ctx.Get("user")
-	userinfo := user.(model.User)
-	id := userinfo.ID
-	fmt.Println(id)
-	ctx.JSON(http.StatusOK, gin.H{"code": 200, "data": gin.H{"user": user}})
+// resetPassword 通过短信验证码重置忘记的密码
+func (c *UserController) resetPassword(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	var req api.ResetPasswordRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		log.WithError(err).Error("reset password bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	if len(req.NewPassword) < 6 || len(req.NewPassword) > 14 {
+		log.Errorf("invalid password length: %s", req.Telephone)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid password"})
+		return
+	}
+
+	if err := c.verificationClient.Verify(ctx0, verification.PurposeResetPassword, req.Telephone, req.Code); err != nil {
+		log.WithError(err).Errorf("verify code error: %s", req.Telephone)
+		abortVerification(ctx, err)
+		return
+	}
+
+	var user model.User
+	if err := c.db.Where("telephone = ?", req.Telephone).First(&user).Error; err != nil {
+		log.WithError(err).Errorf("mysql query user error: %s", req.Telephone)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid verification code"})
+		return
+	}
+
+	hashPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.WithError(err).Error("generate password error")
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "generate password error"})
+		return
+	}
+
+	if err = c.db.Model(&user).Update("password", string(hashPassword)).Error; err != nil {
+		log.WithError(err).Errorf("mysql update password error: %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+	c.authController.InvalidateUser(ctx0, user.ID)
+
+	//重置密码后之前登录的设备全部下线，并解除登录锁定
+	if err = c.authController.RevokeAll(ctx0, user.ID); err != nil {
+		log.WithError(err).Errorf("revoke all tokens error: %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "revoke tokens error"})
+		return
+	}
+	c.resetLoginFailures(ctx0, user.Telephone)
+	if err = c.cacheClient.Delete(ctx0, loginLockKey(user.Telephone)); err != nil {
+		log.WithError(err).Errorf("delete login lock error: %s", user.Telephone)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
 }
 
-func UpdateAvatar(ctx *gin.Context) {
-	DB := database.GetDB()
+// changeTelephone 更换绑定的手机号，需要新号码的验证码
+func (c *UserController) changeTelephone(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	userInfo, exists := auth.UserFrom(ctx)
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not exist"})
+		return
+	}
+
+	var req api.ChangeTelephoneRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		log.WithError(err).Error("change telephone bind json error")
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	if len(req.Telephone) != 11 {
+		log.Errorf("invalid telephone number: %s", req.Telephone)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid telephone number"})
+		return
+	}
+
+	if err := c.verificationClient.Verify(ctx0, verification.PurposeChangeTelephone, req.Telephone, req.Code); err != nil {
+		log.WithError(err).Errorf("verify code error: %s", req.Telephone)
+		abortVerification(ctx, err)
+		return
+	}
+
+	taken, err := c.telephoneExists(req.Telephone)
+	if err != nil {
+		log.WithError(err).Errorf("mysql query error: %s", req.Telephone)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if taken {
+		log.Errorf("telephone number exists: %s", req.Telephone)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "telephone number exists"})
+		return
+	}
+
+	if err = c.db.Model(userInfo).Update("telephone", req.Telephone).Error; err != nil {
+		log.WithError(err).Errorf("mysql update telephone error: %d", userInfo.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql update error"})
+		return
+	}
+	c.authController.InvalidateUser(ctx0, userInfo.ID)
+
+	ctx.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// abortVerification 把验证码错误转换为响应
+func abortVerification(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, verification.ErrInvalidCode):
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid verification code"})
+	case errors.Is(err, verification.ErrTooManyAttempts):
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "too many attempts, please request a new code"})
+	case errors.Is(err, verification.ErrTooFrequent):
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "verification code requested too frequently"})
+	default:
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "verification error"})
+	}
+}
+
+func (c *UserController) info(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	user, exists := auth.UserFrom(ctx)
+	if !exists {
+		log.Error("need to login")
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "need to login"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"code": 200, "data": gin.H{"user": toProfile(c.storageClient, user)}})
+}
+
+// profile 卖家公开主页，只返回可以公开的字段
+func (c *UserController) profile(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	var user model.User
+	if err := c.db.First(&user, ctx.Param("id")).Error; err != nil {
+		log.WithError(err).Errorf("mysql query user error: %s", ctx.Param("id"))
+		abortQuery(ctx, err, "user not found")
+		return
+	}
+
+	// 被封禁用户的主页不再公开
+	if user.Banned {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	sellerID := strconv.Itoa(int(user.ID))
+	resp := api.SellerProfile{
+		PublicUser: toPublicUser(c.storageClient, &user),
+	}
+
+	page, size := pageParams(ctx)
+	active := c.db.Model(&model.Goods{}).Where("user = ? AND is_sold = ?", sellerID, false)
+	if err := active.Count(&resp.ListingCount).Error; err != nil {
+		log.WithError(err).Errorf("mysql count goods error: %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	if err := active.Order("created_at DESC").Offset((page - 1) * size).Limit(size).Find(&resp.Listings).Error; err != nil {
+		log.WithError(err).Errorf("mysql query goods error: %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	for i := range resp.Listings {
+		resp.Listings[i].Picture = c.storageClient.ObjectURL(resp.Listings[i].Picture)
+	}
+
+	if err := c.db.Model(&model.Goods{}).Where("user = ? AND is_sold = ?", sellerID, true).Count(&resp.SoldCount).Error; err != nil {
+		log.WithError(err).Errorf("mysql count sold goods error: %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	summary, err := c.reviewController.Summary(ctx0, user.ID)
+	if err != nil {
+		log.WithError(err).Errorf("query rating summary error: %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+	resp.Rating = summary.Rating
+	resp.RatingCount = summary.Count
+
+	if resp.Reviews, err = c.reviewController.RecentReviews(user.ID, 5); err != nil {
+		log.WithError(err).Errorf("query recent reviews error: %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "mysql query error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"result": resp})
+}
+
+func (c *UserController) updateAvatar(ctx *gin.Context) {
+	DB := c.db
 	pictureID, isSuccess := ctx.GetQuery("pictureID")
-	user, isExist := ctx.Get("user")
+	userInfo, isExist := auth.UserFrom(ctx)
 	if !isExist {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
 		return
 	}
-	userInfo := user.(model.User)
 	if !isSuccess {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"code": 400,
@@ -247,10 +710,12 @@ func UpdateAvatar(ctx *gin.Context) {
 		})
 		return
 	}
+	pictureID = c.storageClient.ObjectKey(pictureID)
 	if userInfo.Avatar != pictureID {
 		userInfo.Avatar = pictureID
 	}
-	DB.Model(&userInfo).Where("id=?", userInfo.ID).Update("avatar", userInfo.Avatar)
+	DB.Model(userInfo).Where("id=?", userInfo.ID).Update("avatar", userInfo.Avatar)
+	c.authController.InvalidateUser(ctx.Request.Context(), userInfo.ID)
 	ctx.JSON(200, gin.H{
 		"code":     200,
 		"msg":      "更换头像成功",
@@ -263,19 +728,18 @@ type onPassword struct {
 	NewPassword string `json:"new_password"`
 }
 
-func ChangePassword(ctx *gin.Context) {
-	DB := database.GetDB()
+func (c *UserController) changePassword(ctx *gin.Context) {
+	DB := c.db
 	var receivePassword onPassword
 	if err := ctx.BindJSON(&receivePassword); err != nil {
 		ctx.JSON(422, gin.H{"code": 422, "msg": "获取失败"})
 		return
 	}
-	user, isExist := ctx.Get("user")
+	userInfo, isExist := auth.UserFrom(ctx)
 	if !isExist {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
 		return
 	}
-	userInfo := user.(model.User)
 
 	//验证新旧密码长度
 	if len(receivePassword.OldPassword) < 6 || len(receivePassword.OldPassword) > 14 {
@@ -308,17 +772,35 @@ func ChangePassword(ctx *gin.Context) {
 	//println("old password:", userInfo.Password)
 	userInfo.Password = string(HashPassword)
 	//println("new password:", userInfo.Password)
-	DB.Model(&userInfo).Where("id=?", userInfo.ID).Update("password", userInfo.Password)
+	DB.Model(userInfo).Where("id=?", userInfo.ID).Update("password", userInfo.Password)
+	c.authController.InvalidateUser(ctx.Request.Context(), userInfo.ID)
+
+	//修改密码后吊销所有已签发的token，并为当前设备重新签发
+	if err = c.authController.RevokeAll(ctx.Request.Context(), userInfo.ID); err != nil {
+		ctx.JSON(500, gin.H{"code": 500, "msg": "吊销token失败"})
+		return
+	}
+	tokens, err := c.authController.IssueTokens(ctx.Request.Context(), *userInfo)
+	if err != nil {
+		ctx.JSON(500, gin.H{"code": 500, "msg": "签发token失败"})
+		return
+	}
+
 	//返回响应
 	ctx.JSON(200, gin.H{
-		"code": 200,
-		"msg":  "更换密码成功",
+		"code":   200,
+		"msg":    "更换密码成功",
+		"result": tokens,
 	})
 }
 
-func ChangeInfo(ctx *gin.Context) {
-	DB := database.GetDB()
-	var receiveInfo model.User
+func (c *UserController) changeInfo(ctx *gin.Context) {
+	var (
+		ctx0 = ctx.Request.Context()
+		log  = logger.SmileLog.WithContext(ctx0)
+	)
+
+	var receiveInfo api.ChangeInfoRequest
 	if err := ctx.BindJSON(&receiveInfo); err != nil {
 		ctx.JSON(422, gin.H{"code": 422, "msg": "获取失败"})
 		return
@@ -334,17 +816,30 @@ func ChangeInfo(ctx *gin.Context) {
 		})
 		return
 	}
-	user, isExist := ctx.Get("user")
+	// 与 users 表的 varchar(30) 和 varchar(10) 一致
+	if utf8.RuneCountInString(newName) > 30 || utf8.RuneCountInString(newGender) > 10 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "昵称或性别过长",
+		})
+		return
+	}
+	userInfo, isExist := auth.UserFrom(ctx)
 	if !isExist {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
 		return
 	}
-	userInfo := user.(model.User)
 
 	if userInfo.Name != newName || userInfo.Gender != newGender {
+		if err := c.db.Model(userInfo).Where("id=?", userInfo.ID).
+			Updates(map[string]interface{}{"name": newName, "gender": newGender}).Error; err != nil {
+			log.WithError(err).Errorf("mysql update user info error: %d", userInfo.ID)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "mysql update error"})
+			return
+		}
 		userInfo.Name = newName
 		userInfo.Gender = newGender
-		DB.Model(&userInfo).Where("id=?", userInfo.ID).Updates(map[string]interface{}{"name": userInfo.Name, "gender": userInfo.Gender})
+		c.authController.InvalidateUser(ctx0, userInfo.ID)
 	}
 	ctx.JSON(200, gin.H{
 		"code": 200,
@@ -355,113 +850,3 @@ func ChangeInfo(ctx *gin.Context) {
 		},
 	})
 }
-
-func AddAddress(ctx *gin.Context) {
-	DB := database.GetDB()
-	var receiveAddress model.UserAddress
-	if err := ctx.BindJSON(&receiveAddress); err != nil {
-		ctx.JSON(422, gin.H{"code": 422, "msg": "获取失败"})
-		return
-	}
-
-	if len(receiveAddress.Receiver) == 0 || len(receiveAddress.Contact) == 0 || len(receiveAddress.Address) == 0 {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "地址信息不完整，请重新填写",
-		})
-		return
-	}
-
-	user, isExist := ctx.Get("user")
-	if !isExist {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"msg": "user not exist"})
-		return
-	}
-	userInfo := user.(model.User)
-	receiveAddress.UserID = userInfo.ID
-
-	DB.Create(&receiveAddress)
-
-	var addressArray []model.UserAddress
-	DB.Model(&model.UserAddress{}).Where("user_id=?", userInfo.ID).Find(&addressArray)
-
-	//for _, v := range addressArray {
-	//	println("id", v.ID)
-	//	println("Receiver", v.Receiver)
-	//	println("Contact", v.Contact)
-	//	println("Address", v.Address)
-	//	println("UserID", v.UserID)
-	//
-	//}
-
-	var apiAddresses []api.Address
-	for _, address := range addressArray {
-		resAddress := api.Address{AddressID: strconv.Itoa(int(address.ID)), Receiver: address.Receiver, Contact: address.Contact, Address: address.Address}
-		apiAddresses = append(apiAddresses, resAddress)
-	}
-	ctx.JSON(200, gin.H{
-		"code":          200,
-		"msg":           "新增收货地址成功",
-		"userAddresses": apiAddresses,
-	},
-	)
-}
-
-func DeleteAddress(ctx *gin.Context) {
-	DB := database.GetDB()
-	addressID, isExist := ctx.GetQuery("id")
-
-	if !isExist {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "没有传入参数id",
-		})
-		return
-	}
-	if len(addressID) == 0 {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "id为空",
-		})
-		return
-	}
-
-	user, isExist := ctx.Get("user")
-	if !isExist {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"code": 400, "msg": "user not exist"})
-		return
-	}
-	userInfo := user.(model.User)
-
-	if err := DB.Where("id = ?", addressID).First(&model.UserAddress{}).Error; errors.Is(err, gorm.ErrRecordNotFound) {
-		//记录为空
-		ctx.JSON(http.StatusOK, gin.H{"code": 200, "msg": "该记录不存在"})
-		return
-	}
-
-	DB.Where("id=?", addressID).Delete(&model.UserAddress{})
-
-	var addressArray []model.UserAddress
-	DB.Model(&model.UserAddress{}).Where("user_id=?", userInfo.ID).Find(&addressArray)
-
-	//for _, v := range addressArray {
-	//	println("id", v.ID)
-	//	println("Receiver", v.Receiver)
-	//	println("Contact", v.Contact)
-	//	println("Address", v.Address)
-	//	println("UserID", v.UserID)
-	//
-	//}
-
-	var apiAddresses []api.Address
-	for _, address := range addressArray {
-		resAddress := api.Address{AddressID: strconv.Itoa(int(address.ID)), Receiver: address.Receiver, Contact: address.Contact, Address: address.Address}
-		apiAddresses = append(apiAddresses, resAddress)
-	}
-	ctx.JSON(200, gin.H{
-		"code":          200,
-		"msg":           "删除收货地址成功",
-		"userAddresses": apiAddresses,
-	},
-	)
-}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+)
+
+func newTestUserController(t *testing.T, options *UserControllerOptions) (*UserController, *gin.Engine, *miniredis.Miniredis) {
+	t.Helper()
+
+	var (
+		r               = gin.New()
+		db              = newTestDB(t, &model.User{}, &model.UserAddress{})
+		cacheClient, mr = newTestCache(t)
+		authController  = NewAuthController(&AuthControllerOptions{}, r, cacheClient, db, newTestJWT(t))
+	)
+
+	c := NewUserController(options, r, db, cacheClient, newTestStorageClient(t), nil, nil, authController)
+	c.Register()
+	return c, r, mr
+}
+
+func TestLoginLockoutBackoff(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		telephone = "13800000001"
+	)
+	c, _, mr := newTestUserController(t, &UserControllerOptions{
+		LoginMaxAttempts: 3,
+		LoginLockout:     60,
+		LoginMaxLockout:  200,
+	})
+
+	// 连续被锁定时锁定时长翻倍，不超过上限；登录成功后重新从第一次开始计算
+	tests := []struct {
+		name  string
+		reset bool
+		want  time.Duration
+	}{
+		{name: "first lock", want: 60 * time.Second},
+		{name: "doubled", want: 120 * time.Second},
+		{name: "capped", want: 200 * time.Second},
+		{name: "stays capped", want: 200 * time.Second},
+		{name: "after success", reset: true, want: 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.reset {
+				c.resetLoginFailures(ctx, telephone)
+			}
+
+			for i := 0; i < 3; i++ {
+				retryAfter, err := c.loginRetryAfter(ctx, telephone, "10.0.0.1")
+				if err != nil {
+					t.Fatalf("loginRetryAfter error: %v", err)
+				}
+				if retryAfter != 0 {
+					t.Fatalf("locked after %d failures: %s", i, retryAfter)
+				}
+				c.recordLoginFailure(ctx, telephone, "10.0.0.1")
+			}
+
+			retryAfter, err := c.loginRetryAfter(ctx, telephone, "10.0.0.1")
+			if err != nil {
+				t.Fatalf("loginRetryAfter error: %v", err)
+			}
+			if retryAfter != tt.want {
+				t.Errorf("retryAfter = %s, want %s", retryAfter, tt.want)
+			}
+			mr.FastForward(retryAfter)
+		})
+	}
+}
+
+func TestLoginIPLimit(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newTestUserController(t, &UserControllerOptions{
+		LoginMaxAttempts:   10,
+		LoginIPMaxAttempts: 3,
+		LoginWindow:        300,
+	})
+
+	// 同一个 IP 尝试不同的账号也会被限制
+	for _, telephone := range []string{"13800000001", "13800000002", "13800000003"} {
+		c.recordLoginFailure(ctx, telephone, "10.0.0.1")
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		want time.Duration
+	}{
+		{name: "limited ip", ip: "10.0.0.1", want: 300 * time.Second},
+		{name: "other ip", ip: "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retryAfter, err := c.loginRetryAfter(ctx, "13800000004", tt.ip)
+			if err != nil {
+				t.Fatalf("loginRetryAfter error: %v", err)
+			}
+			if retryAfter != tt.want {
+				t.Errorf("retryAfter = %s, want %s", retryAfter, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginResponses(t *testing.T) {
+	c, r, mr := newTestUserController(t, &UserControllerOptions{LoginMaxAttempts: 2, LoginLockout: 60})
+
+	password, err := bcrypt.GenerateFromPassword([]byte("secret1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.db.Create(&model.User{Name: "user", Telephone: "13800000001", Password: string(password)}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(telephone string, password string) *api.LoginRequest {
+		return &api.LoginRequest{Telephone: telephone, Password: password}
+	}
+
+	// 用户不存在和密码错误的响应相同
+	unknown := serve(r, http.MethodPost, "/login", "", login("13800000002", "secret1"))
+	assertStatus(t, unknown, http.StatusUnauthorized)
+	wrong := serve(r, http.MethodPost, "/login", "", login("13800000001", "secret2"))
+	assertStatus(t, wrong, http.StatusUnauthorized)
+	if unknown.Body.String() != wrong.Body.String() {
+		t.Errorf("unknown user body %s differs from wrong password body %s", unknown.Body.String(), wrong.Body.String())
+	}
+
+	wrong = serve(r, http.MethodPost, "/login", "", login("13800000001", "secret2"))
+	assertStatus(t, wrong, http.StatusUnauthorized)
+	locked := serve(r, http.MethodPost, "/login", "", login("13800000001", "secret1"))
+	assertStatus(t, locked, http.StatusTooManyRequests)
+	if locked.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After = %q, want 60", locked.Header().Get("Retry-After"))
+	}
+
+	mr.FastForward(time.Minute)
+	assertStatus(t, serve(r, http.MethodPost, "/login", "", login("13800000001", "secret1")), http.StatusOK)
+}
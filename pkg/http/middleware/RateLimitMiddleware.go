@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smile.expression/destiny/pkg/auth"
+	"smile.expression/destiny/pkg/cache"
+	"smile.expression/destiny/pkg/logger"
+)
+
+type RateLimitOptions struct {
+	Limit  int `json:"limit"`  // 窗口内允许的请求数
+	Window int `json:"window"` // 窗口长度（秒）
+}
+
+// KeyFunc 决定按什么维度限流
+type KeyFunc func(ctx *gin.Context) string
+
+// ByIP 按客户端 IP 限流
+func ByIP(ctx *gin.Context) string {
+	return "ip:" + ctx.ClientIP()
+}
+
+// ByUser 按登录用户限流，未登录时退回按 IP，需要放在 AuthMiddleware 之后
+func ByUser(ctx *gin.Context) string {
+	if user, exists := auth.UserFrom(ctx); exists {
+		return UserKey(user.ID)
+	}
+	return ByIP(ctx)
+}
+
+// UserKey 按用户限流的 key，和 ByUser 一致
+func UserKey(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// RateLimit 基于 redis 滑动窗口的限流中间件，options 为空或 Limit 为 0 时不限流
+func RateLimit(cacheClient *cache.Client, name string, options *RateLimitOptions, keyFunc KeyFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if options == nil || options.Limit <= 0 || options.Window <= 0 {
+			ctx.Next()
+			return
+		}
+
+		if !Allow(ctx.Request.Context(), cacheClient, name, options, keyFunc(ctx)) {
+			ctx.Header("Retry-After", strconv.Itoa(options.Window))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Allow 记录一次请求并判断是否超出限制，用于不经过 HTTP 中间件的入口（例如 WebSocket），
+// name 和 key 与 RateLimit 相同时共用同一个窗口
+func Allow(ctx context.Context, cacheClient *cache.Client, name string, options *RateLimitOptions, key string) bool {
+	if options == nil || options.Limit <= 0 || options.Window <= 0 {
+		return true
+	}
+
+	var (
+		log      = logger.SmileLog.WithContext(ctx)
+		window   = time.Duration(options.Window) * time.Second
+		cacheKey = fmt.Sprintf("ratelimit/%s/%s", name, key)
+	)
+
+	count, err := cacheClient.RecordEvent(ctx, cacheKey, window)
+	if err != nil {
+		// redis 故障时放行，不影响正常业务
+		log.WithError(err).Errorf("rate limit error: %s", cacheKey)
+		return true
+	}
+
+	if count > int64(options.Limit) {
+		log.Errorf("rate limit exceeded: %s", cacheKey)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type Options struct {
+	Rules []RuleOptions `json:"rules"`
+}
+
+// RuleOptions Keywords 和 Pattern 任选其一，关键词不区分大小写
+type RuleOptions struct {
+	Name     string   `json:"name"`
+	Keywords []string `json:"keywords"`
+	Pattern  string   `json:"pattern"` // 正则，RE2 语法，可以直接写中文
+	Action   Action   `json:"action"`
+	Scopes   []Scope  `json:"scopes"` // 为空时对所有来源生效
+}
+
+type rule struct {
+	name   string
+	re     *regexp.Regexp
+	action Action
+	scopes []Scope
+}
+
+// Filter 基于关键词和正则的审核，规则可以在运行时替换
+type Filter struct {
+	mu    sync.RWMutex
+	rules []*rule
+}
+
+func NewFilter(options *Options) (*Filter, error) {
+	f := &Filter{}
+	if err := f.Update(options); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update 替换全部规则，有规则不合法时保留原来的规则
+func (f *Filter) Update(options *Options) error {
+	var rules []*rule
+	if options != nil {
+		for i, o := range options.Rules {
+			r, err := compileRule(&o)
+			if err != nil {
+				return fmt.Errorf("rule %d (%s): %w", i, o.Name, err)
+			}
+			rules = append(rules, r)
+		}
+	}
+
+	f.mu.Lock()
+	f.rules = rules
+	f.mu.Unlock()
+	return nil
+}
+
+func compileRule(o *RuleOptions) (*rule, error) {
+	switch o.Action {
+	case ActionAllow, ActionMask, ActionFlag, ActionBlock:
+	default:
+		return nil, fmt.Errorf("invalid action: %q", o.Action)
+	}
+
+	pattern := o.Pattern
+	if len(o.Keywords) > 0 {
+		if pattern != "" {
+			return nil, fmt.Errorf("keywords and pattern are exclusive")
+		}
+		quoted := make([]string, 0, len(o.Keywords))
+		for _, keyword := range o.Keywords {
+			if keyword = normalize(keyword); keyword != "" {
+				quoted = append(quoted, regexp.QuoteMeta(keyword))
+			}
+		}
+		pattern = "(?i)" + strings.Join(quoted, "|")
+	}
+	if pattern == "" || pattern == "(?i)" {
+		return nil, fmt.Errorf("empty rule")
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &rule{name: o.Name, re: re, action: o.Action, scopes: o.Scopes}, nil
+}
+
+func (r *rule) applies(scope Scope) bool {
+	if len(r.scopes) == 0 {
+		return true
+	}
+	for _, s := range r.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Moderate 在全角转半角之后的文本上匹配，打码按字符位置作用在原文上
+func (f *Filter) Moderate(_ context.Context, scope Scope, text string) (*Result, error) {
+	f.mu.RLock()
+	rules := f.rules
+	f.mu.RUnlock()
+
+	result := &Result{Action: ActionAllow, Text: text}
+	if len(rules) == 0 || text == "" {
+		return result, nil
+	}
+
+	normalized := normalize(text)
+
+	// 先找出白名单覆盖的区间，其他规则在这些区间内的命中忽略
+	var allowed [][]int
+	for _, r := range rules {
+		if r.action == ActionAllow && r.applies(scope) {
+			allowed = append(allowed, runeSpans(normalized, r.re.FindAllStringIndex(normalized, -1))...)
+		}
+	}
+
+	var masked [][]int
+	for _, r := range rules {
+		if r.action == ActionAllow || !r.applies(scope) {
+			continue
+		}
+
+		hit := false
+		for _, span := range runeSpans(normalized, r.re.FindAllStringIndex(normalized, -1)) {
+			if covered(allowed, span) {
+				continue
+			}
+			hit = true
+			if r.action == ActionMask {
+				masked = append(masked, span)
+			}
+		}
+		if !hit {
+			continue
+		}
+
+		result.Rules = append(result.Rules, r.name)
+		if severity(r.action) > severity(result.Action) {
+			result.Action = r.action
+		}
+	}
+
+	if len(masked) > 0 {
+		runes := []rune(text)
+		for _, span := range masked {
+			for i := span[0]; i < span[1]; i++ {
+				runes[i] = '*'
+			}
+		}
+		result.Text = string(runes)
+	}
+	return result, nil
+}
+
+// normalize 全角字符转半角，不改变字符个数，“１３８”和“138”按相同内容匹配
+func normalize(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '　':
+			return ' '
+		case r >= '！' && r <= '～':
+			return r - 0xfee0
+		default:
+			return r
+		}
+	}, text)
+}
+
+// runeSpans 把字节区间换算为字符区间
+func runeSpans(text string, spans [][]int) [][]int {
+	result := make([][]int, 0, len(spans))
+	for _, span := range spans {
+		if span[0] == span[1] {
+			continue
+		}
+		start := utf8.RuneCountInString(text[:span[0]])
+		result = append(result, []int{start, start + utf8.RuneCountInString(text[span[0]:span[1]])})
+	}
+	return result
+}
+
+func covered(allowed [][]int, span []int) bool {
+	for _, a := range allowed {
+		if a[0] <= span[0] && span[1] <= a[1] {
+			return true
+		}
+	}
+	return false
+}
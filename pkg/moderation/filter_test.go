@@ -0,0 +1,117 @@
+package moderation
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "empty", text: "", want: ""},
+		{name: "ascii", text: "abc 123", want: "abc 123"},
+		{name: "full width digits", text: "１３８", want: "138"},
+		{name: "full width letters and symbols", text: "ＷＸ：ａｂｃ！", want: "WX:abc!"},
+		{name: "ideographic space", text: "加　微信", want: "加 微信"},
+		{name: "chinese unchanged", text: "你好，世界", want: "你好,世界"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalize(tt.text); got != tt.want {
+				t.Errorf("normalize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuneSpans(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		spans [][]int
+		want  [][]int
+	}{
+		{name: "no spans", text: "abc", spans: nil, want: [][]int{}},
+		{name: "ascii", text: "abcdef", spans: [][]int{{1, 3}}, want: [][]int{{1, 3}}},
+		{name: "chinese", text: "加微信abc", spans: [][]int{{3, 9}}, want: [][]int{{1, 3}}},
+		{name: "mixed", text: "我的qq是123", spans: [][]int{{6, 8}, {11, 14}}, want: [][]int{{2, 4}, {5, 8}}},
+		{name: "empty span skipped", text: "abc", spans: [][]int{{1, 1}, {0, 2}}, want: [][]int{{0, 2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runeSpans(tt.text, tt.spans); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runeSpans(%q, %v) = %v, want %v", tt.text, tt.spans, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterModerate(t *testing.T) {
+	filter, err := NewFilter(&Options{Rules: []RuleOptions{
+		{Name: "official", Keywords: []string{"官方客服"}, Action: ActionAllow},
+		{Name: "phone", Pattern: `1[3-9]\d{9}`, Action: ActionMask},
+		{Name: "wechat", Keywords: []string{"微信", "WX"}, Action: ActionFlag, Scopes: []Scope{ScopeChat}},
+		{Name: "scam", Keywords: []string{"刷单", "客服"}, Action: ActionBlock},
+	}})
+	if err != nil {
+		t.Fatalf("NewFilter error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		scope  Scope
+		text   string
+		action Action
+		result string
+		rules  []string
+	}{
+		{name: "empty", scope: ScopeChat, text: "", action: ActionAllow, result: ""},
+		{name: "clean", scope: ScopeChat, text: "还在吗", action: ActionAllow, result: "还在吗"},
+		{name: "mask phone", scope: ScopeChat, text: "电话13812345678", action: ActionMask, result: "电话***********", rules: []string{"phone"}},
+		{name: "mask full width phone", scope: ScopeChat, text: "电话１３８１２３４５６７８", action: ActionMask, result: "电话***********", rules: []string{"phone"}},
+		{name: "flag case insensitive", scope: ScopeChat, text: "加我wx", action: ActionFlag, result: "加我wx", rules: []string{"wechat"}},
+		{name: "scope not applied", scope: ScopeGoods, text: "加我微信", action: ActionAllow, result: "加我微信"},
+		{name: "most severe wins", scope: ScopeChat, text: "微信13812345678刷单", action: ActionBlock, result: "微信***********刷单", rules: []string{"phone", "wechat", "scam"}},
+		{name: "allow list", scope: ScopeGoods, text: "请联系官方客服", action: ActionAllow, result: "请联系官方客服"},
+		{name: "allow list only covers its span", scope: ScopeGoods, text: "官方客服说找客服", action: ActionBlock, result: "官方客服说找客服", rules: []string{"scam"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filter.Moderate(context.Background(), tt.scope, tt.text)
+			if err != nil {
+				t.Fatalf("Moderate error: %v", err)
+			}
+			if got.Action != tt.action || got.Text != tt.result || !reflect.DeepEqual(got.Rules, tt.rules) {
+				t.Errorf("Moderate(%q) = {%s %q %v}, want {%s %q %v}", tt.text, got.Action, got.Text, got.Rules, tt.action, tt.result, tt.rules)
+			}
+		})
+	}
+}
+
+func TestNewFilterInvalidRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RuleOptions
+	}{
+		{name: "invalid action", rule: RuleOptions{Keywords: []string{"a"}, Action: "drop"}},
+		{name: "keywords and pattern", rule: RuleOptions{Keywords: []string{"a"}, Pattern: "b", Action: ActionBlock}},
+		{name: "empty", rule: RuleOptions{Action: ActionBlock}},
+		{name: "blank keywords", rule: RuleOptions{Keywords: []string{""}, Action: ActionBlock}},
+		{name: "invalid pattern", rule: RuleOptions{Pattern: "(", Action: ActionBlock}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFilter(&Options{Rules: []RuleOptions{tt.rule}}); err == nil {
+				t.Errorf("NewFilter(%+v) error = nil, want error", tt.rule)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package moderation
+
+import (
+	"context"
+
+	"smile.expression/destiny/pkg/logger"
+)
+
+// Action 命中规则后的处理方式，按严重程度递增
+type Action string
+
+const (
+	ActionAllow Action = "allow" // 白名单，命中的内容不再受其他规则影响
+	ActionMask  Action = "mask"  // 命中的内容替换为 *
+	ActionFlag  Action = "flag"  // 照常发布，同时进入人工审核
+	ActionBlock Action = "block" // 拒绝发布
+)
+
+// Scope 审核的内容来源，规则可以只对部分来源生效
+type Scope string
+
+const (
+	ScopeChat  Scope = "chat"
+	ScopeGoods Scope = "goods"
+)
+
+// Result 审核结果，Text 是处理（打码）之后的内容
+type Result struct {
+	Action Action
+	Text   string
+	Rules  []string // 命中的规则名
+}
+
+// Moderator 一个审核环节，可以是关键词过滤，也可以接入第三方内容安全服务
+type Moderator interface {
+	Moderate(ctx context.Context, scope Scope, text string) (*Result, error)
+}
+
+// Pipeline 依次执行各个审核环节，后一个环节审核前一个打码后的内容，结果取最严重的处理方式
+type Pipeline struct {
+	moderators []Moderator
+}
+
+func NewPipeline(moderators ...Moderator) *Pipeline {
+	return &Pipeline{moderators: moderators}
+}
+
+// Use 追加审核环节，需要在开始处理请求之前调用
+func (p *Pipeline) Use(moderator Moderator) {
+	p.moderators = append(p.moderators, moderator)
+}
+
+// Moderate pipeline 为空时直接放行，单个环节出错时跳过，不影响正常发布
+func (p *Pipeline) Moderate(ctx context.Context, scope Scope, text string) *Result {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	result := &Result{Action: ActionAllow, Text: text}
+	if p == nil {
+		return result
+	}
+
+	for _, moderator := range p.moderators {
+		r, err := moderator.Moderate(ctx, scope, result.Text)
+		if err != nil {
+			log.WithError(err).Errorf("moderate %s error", scope)
+			continue
+		}
+
+		result.Text = r.Text
+		result.Rules = append(result.Rules, r.Rules...)
+		if severity(r.Action) > severity(result.Action) {
+			result.Action = r.Action
+		}
+		if result.Action == ActionBlock {
+			break
+		}
+	}
+	return result
+}
+
+func severity(action Action) int {
+	switch action {
+	case ActionMask:
+		return 1
+	case ActionFlag:
+		return 2
+	case ActionBlock:
+		return 3
+	default:
+		return 0
+	}
+}
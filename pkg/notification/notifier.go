@@ -0,0 +1,182 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"text/template"
+
+	"gorm.io/gorm"
+
+	"smile.expression/destiny/pkg/chat"
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/http/api"
+	"smile.expression/destiny/pkg/logger"
+	"smile.expression/destiny/pkg/push"
+)
+
+// Type 通知类型，每种类型对应一个标题和内容模板
+type Type string
+
+const (
+	GoodsSold          Type = "goods_sold"           // 卖家：商品被购买
+	GoodsTakenDown     Type = "goods_taken_down"     // 卖家：商品被管理员下架
+	OrderCompleted     Type = "order_completed"      // 卖家：买家确认收货
+	OrderUnconfirmed   Type = "order_unconfirmed"    // 买家：订单长时间未确认收货
+	OrderAutoCompleted Type = "order_auto_completed" // 买家和卖家：超时自动确认收货
+	ReviewReceived     Type = "review_received"      // 被评价的一方
+	DisputeClosed      Type = "dispute_closed"       // 纠纷发起人：纠纷处理完毕
+)
+
+type templates struct {
+	title   *template.Template
+	content *template.Template
+}
+
+var registry = map[Type]*templates{}
+
+func register(typ Type, title, content string) {
+	registry[typ] = &templates{
+		title:   template.Must(template.New(string(typ) + ".title").Option("missingkey=error").Parse(title)),
+		content: template.Must(template.New(string(typ) + ".content").Option("missingkey=error").Parse(content)),
+	}
+}
+
+func init() {
+	register(GoodsSold, "商品已售出",
+		"你的商品「{{.GoodsName}}」已被购买，请尽快联系买家发货。")
+	register(GoodsTakenDown, "商品已下架",
+		"你的商品「{{.GoodsName}}」已被管理员下架{{if .Reason}}，原因：{{.Reason}}{{end}}。")
+	register(OrderCompleted, "买家已确认收货",
+		"商品「{{.GoodsName}}」的订单已完成，可以去评价买家了。")
+	register(OrderUnconfirmed, "请确认收货",
+		"商品「{{.GoodsName}}」已下单 {{.Days}} 天，收到商品后请及时确认收货。")
+	register(OrderAutoCompleted, "订单已自动完成",
+		"商品「{{.GoodsName}}」的订单超时未确认收货，已自动完成，可以互相评价了。")
+	register(ReviewReceived, "收到新评价",
+		"{{.Reviewer}} 给了你 {{.Rating}} 星评价{{if .Content}}：{{.Content}}{{end}}")
+	register(DisputeClosed, "纠纷已处理",
+		"订单 #{{.OrderID}} 的纠纷{{if eq .Status \"resolved\"}}已解决{{else}}已驳回{{end}}{{if .Resolution}}：{{.Resolution}}{{end}}")
+}
+
+// Data 模板参数，模板中用到的字段都要给出，缺少时渲染失败
+type Data map[string]interface{}
+
+// Notifier 保存通知到用户收件箱，用户在线时通过聊天 WebSocket 推送，不在线时发送离线推送
+type Notifier struct {
+	db         *gorm.DB
+	relay      *chat.Relay
+	presence   *chat.Presence
+	pushClient *push.Client
+}
+
+// NewNotifier relay 为空时只保存不推送，presence 或 pushClient 为空时不发送离线推送
+func NewNotifier(db *gorm.DB, relay *chat.Relay, presence *chat.Presence, pushClient *push.Client) *Notifier {
+	return &Notifier{
+		db:         db,
+		relay:      relay,
+		presence:   presence,
+		pushClient: pushClient,
+	}
+}
+
+// Notify 发送通知，失败只记录日志，不影响触发通知的业务
+func (n *Notifier) Notify(ctx context.Context, userID uint, typ Type, refID uint, data Data) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if n == nil || userID == 0 {
+		return
+	}
+
+	record, err := render(typ, data)
+	if err != nil {
+		log.WithError(err).Errorf("render notification error: %s", typ)
+		return
+	}
+	record.UserID = userID
+	record.RefID = refID
+
+	if err = n.db.Create(record).Error; err != nil {
+		log.WithError(err).Errorf("mysql create notification error: %d, %s", userID, typ)
+		return
+	}
+
+	if n.relay != nil {
+		item := ToAPI(record)
+		n.relay.SendToUser(ctx, userID, &chat.Frame{Type: chat.FrameNotification, Notification: &item})
+	}
+	go n.pushOffline(context.WithoutCancel(ctx), record)
+}
+
+func (n *Notifier) pushOffline(ctx context.Context, record *model.Notification) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if n.presence == nil || n.pushClient == nil {
+		return
+	}
+
+	online, _, err := n.presence.Status(ctx, record.UserID)
+	if err != nil {
+		log.WithError(err).Errorf("redis query presence error: %d", record.UserID)
+	}
+	if online {
+		return
+	}
+
+	n.pushClient.SendToUser(ctx, record.UserID, &push.Payload{
+		Title: record.Title,
+		Body:  record.Content,
+		Data: map[string]string{
+			"type":             "notification",
+			"notificationId":   strconv.Itoa(int(record.ID)),
+			"notificationType": record.Type,
+			"refId":            strconv.Itoa(int(record.RefID)),
+		},
+	})
+}
+
+func render(typ Type, data Data) (*model.Notification, error) {
+	tpl, ok := registry[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown notification type: %s", typ)
+	}
+
+	var title, content bytes.Buffer
+	if err := tpl.title.Execute(&title, data); err != nil {
+		return nil, err
+	}
+	if err := tpl.content.Execute(&content, data); err != nil {
+		return nil, err
+	}
+	return &model.Notification{
+		Type:    string(typ),
+		Title:   title.String(),
+		Content: truncate(content.String(), 1024),
+	}, nil
+}
+
+// truncate 内容中可能包含用户输入（评价、处理意见），按字符截断到字段长度以内
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+func ToAPI(record *model.Notification) api.Notification {
+	return api.Notification{
+		ID:        record.ID,
+		Type:      record.Type,
+		RefID:     record.RefID,
+		Title:     record.Title,
+		Content:   record.Content,
+		Read:      record.ReadAt != nil,
+		CreatedAt: record.CreatedAt,
+	}
+}
@@ -0,0 +1,100 @@
+package push
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"smile.expression/destiny/pkg/database/model"
+	"smile.expression/destiny/pkg/logger"
+)
+
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformWeb     = "web"
+)
+
+type Options struct {
+	Driver string `json:"driver"` // 目前只有 console，必须显式配置
+	File   string `json:"file"`   // console 同时把推送追加写入的文件，为空时只写日志
+}
+
+// Client 管理设备令牌，给用户的所有设备发送推送
+type Client struct {
+	db     *gorm.DB
+	pusher Pusher
+}
+
+func NewClient(options *Options, db *gorm.DB) *Client {
+	if options == nil {
+		options = &Options{}
+	}
+
+	pusher, err := newPusher(options)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Client{
+		db:     db,
+		pusher: pusher,
+	}
+}
+
+// ValidPlatform 校验客户端上报的平台
+func ValidPlatform(platform string) bool {
+	switch platform {
+	case PlatformIOS, PlatformAndroid, PlatformWeb:
+		return true
+	default:
+		return false
+	}
+}
+
+// Register 保存设备令牌，令牌已被其他账号登记时改为归属当前用户
+func (c *Client) Register(userID uint, platform, token string) error {
+	device := model.DeviceToken{UserID: userID, Token: token, Platform: platform}
+	return c.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "platform", "updated_at"}),
+	}).Create(&device).Error
+}
+
+// Unregister 删除当前用户的设备令牌，退出登录时调用
+func (c *Client) Unregister(userID uint, token string) error {
+	return c.db.Where("user_id = ? AND token = ?", userID, token).Delete(&model.DeviceToken{}).Error
+}
+
+// SendToUser 推送到用户的所有设备，失效的令牌直接删除，单个设备失败不影响其他设备
+func (c *Client) SendToUser(ctx context.Context, userID uint, payload *Payload) {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if c == nil {
+		return
+	}
+
+	var devices []model.DeviceToken
+	if err := c.db.Where("user_id = ?", userID).Find(&devices).Error; err != nil {
+		log.WithError(err).Errorf("mysql query device tokens error: %d", userID)
+		return
+	}
+
+	for _, device := range devices {
+		err := c.pusher.Push(ctx, device.Platform, device.Token, payload)
+		if errors.Is(err, ErrInvalidToken) {
+			log.Infof("remove invalid device token: %d, %d", userID, device.ID)
+			if err = c.db.Delete(&device).Error; err != nil {
+				log.WithError(err).Errorf("mysql delete device token error: %d", device.ID)
+			}
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Errorf("push error: %d, device: %d", userID, device.ID)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"smile.expression/destiny/pkg/logger"
+)
+
+// invalidTokenPrefix console 驱动把这个前缀的令牌当作已失效，方便在开发环境验证失效令牌的清理
+const invalidTokenPrefix = "invalid-"
+
+// consolePusher 开发和测试用，不真正推送，只写到日志和文件
+type consolePusher struct {
+	file string
+	mu   sync.Mutex
+}
+
+func newConsolePusher(options *Options) (Pusher, error) {
+	return &consolePusher{file: options.File}, nil
+}
+
+func (p *consolePusher) Push(ctx context.Context, platform, token string, payload *Payload) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	if strings.HasPrefix(token, invalidTokenPrefix) {
+		return ErrInvalidToken
+	}
+
+	// 日志中不记录令牌和推送内容，内容可能是聊天消息预览
+	log.Debugf("push to %s device: %d bytes", platform, len(payload.Body))
+	if p.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := os.OpenFile(p.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\t%s\n", time.Now().Format(time.RFC3339), platform, token, data)
+	return err
+}
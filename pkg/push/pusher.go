@@ -0,0 +1,63 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	DriverConsole = "console"
+)
+
+// ErrInvalidToken 设备令牌已失效（应用被卸载、令牌过期等），收到后删除该令牌
+var ErrInvalidToken = errors.New("invalid device token")
+
+// Payload 推送内容，Data 由客户端在点击通知时解析，例如跳转到会话
+type Payload struct {
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+// Pusher 推送服务接口，接入厂商（APNs、FCM 等）时新增一个实现并调用 RegisterDriver
+type Pusher interface {
+	Push(ctx context.Context, platform, token string, payload *Payload) error
+}
+
+// Factory 按配置创建 Pusher
+type Factory func(options *Options) (Pusher, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Factory{}
+)
+
+// RegisterDriver 注册推送驱动，需要在 NewClient 之前调用
+func RegisterDriver(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	drivers[name] = factory
+}
+
+func init() {
+	RegisterDriver(DriverConsole, newConsolePusher)
+}
+
+func newPusher(options *Options) (Pusher, error) {
+	// console 不会真正推送，漏配时应该启动失败而不是静默丢掉所有推送
+	driver := options.Driver
+	if driver == "" {
+		return nil, fmt.Errorf("push driver is not configured")
+	}
+
+	driversMu.RLock()
+	factory, ok := drivers[driver]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown push driver: %s", driver)
+	}
+	return factory(options)
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	DriverMinIO = "minio"
+	DriverLocal = "local"
+)
+
+// Backend 对象存储后端，MinIO 和本地磁盘各有一个实现
+type Backend interface {
+	MakeBucket(ctx context.Context, bucketName string) error
+	PutObject(ctx context.Context, bucketName string, objectName string, reader io.Reader, objectSize int64, opts PutOptions) (*ObjectInfo, error)
+	GetObject(ctx context.Context, bucketName string, objectName string) (io.ReadCloser, *ObjectInfo, error)
+	RemoveObject(ctx context.Context, bucketName string, objectName string) error
+	StatObject(ctx context.Context, bucketName string, objectName string) (*ObjectInfo, error)
+	ListObjects(ctx context.Context, bucketName string, prefix string) ([]ObjectInfo, error)
+	PresignedGetObject(ctx context.Context, bucketName string, objectName string, expiry time.Duration) (string, error)
+
+	// 分片上传，客户端可以按分片续传大文件
+	NewMultipartUpload(ctx context.Context, bucketName string, objectName string, opts PutOptions) (string, error)
+	PutObjectPart(ctx context.Context, bucketName string, objectName string, uploadID string, partNumber int, reader io.Reader, partSize int64) (*PartInfo, error)
+	ListObjectParts(ctx context.Context, bucketName string, objectName string, uploadID string) ([]PartInfo, error)
+	CompleteMultipartUpload(ctx context.Context, bucketName string, objectName string, uploadID string, parts []PartInfo) (*ObjectInfo, error)
+	AbortMultipartUpload(ctx context.Context, bucketName string, objectName string, uploadID string) error
+}
+
+type PutOptions struct {
+	ContentType string
+}
+
+type ObjectInfo struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	ContentType  string    `json:"contentType"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+type PartInfo struct {
+	PartNumber   int       `json:"partNumber"`
+	ETag         string    `json:"etag"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
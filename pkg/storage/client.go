@@ -4,11 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net/url"
-	"strings"
+	"time"
 
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/sirupsen/logrus"
 
 	"smile.expression/destiny/pkg/constant"
@@ -17,16 +14,22 @@ import (
 )
 
 type Client struct {
-	client  *minio.Client
+	backend Backend
 	options *Options
 }
 
 type Options struct {
-	Endpoint string   `json:"endpoint"`
-	ID       string   `json:"id"`
-	Secret   string   `json:"secret"`
-	Secure   bool     `json:"secure"`
-	Buckets  []string `json:"buckets"`
+	Driver        string            `json:"driver"` // minio 或 local，默认 minio
+	Endpoint      string            `json:"endpoint"`
+	ID            string            `json:"id"`
+	Secret        string            `json:"secret"`
+	Secure        bool              `json:"secure"`
+	Buckets       []string          `json:"buckets"`
+	DefaultBucket string            `json:"defaultBucket"` // 数据库中不带桶名的对象所在的桶，默认 picture
+	PublicURL     string            `json:"publicURL"`     // 对外访问前缀，例如反向代理地址，后面拼接 /bucket/object
+	BucketURLs    map[string]string `json:"bucketURLs"`    // 按桶覆盖的访问前缀，例如 CDN 域名，后面直接拼接 object
+	CDN           *CDNOptions       `json:"cdn"`
+	Local         *LocalOptions     `json:"local"`
 }
 
 func NewClient(options *Options) *Client {
@@ -34,31 +37,37 @@ func NewClient(options *Options) *Client {
 		log = logger.SmileLog.Logger
 	)
 
-	client, err := minio.New(options.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(options.ID, options.Secret, ""),
-		Secure: options.Secure,
-	})
+	backend, err := newBackend(options)
 	if err != nil {
 		log.WithError(err).Fatal("error creating new client")
 		panic(err)
 	}
 
 	for _, bucket := range options.Buckets {
-		if err = client.MakeBucket(context.Background(), bucket, minio.MakeBucketOptions{}); err != nil {
-			if exists, errBucketExists := client.BucketExists(context.Background(), bucket); !exists || errBucketExists != nil {
-				log.WithError(err).Errorf("Failed to create bucket: %s", bucket)
-				panic(errBucketExists)
-			}
+		if err = backend.MakeBucket(context.Background(), bucket); err != nil {
+			log.WithError(err).Errorf("Failed to create bucket: %s", bucket)
+			panic(err)
 		}
 	}
 
 	return &Client{
-		client:  client,
+		backend: backend,
 		options: options,
 	}
 }
 
-func (c *Client) PutObject(ctx context.Context, bucketName string, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (*api.PutObjectResponse, error) {
+func newBackend(options *Options) (Backend, error) {
+	switch options.Driver {
+	case "", DriverMinIO:
+		return newMinioBackend(options)
+	case DriverLocal:
+		return newLocalBackend(options.Local)
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %s", options.Driver)
+	}
+}
+
+func (c *Client) PutObject(ctx context.Context, bucketName string, objectName string, reader io.Reader, objectSize int64, opts PutOptions) (*api.PutObjectResponse, error) {
 	var (
 		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
 			constant.Route:  constant.PutObject,
@@ -68,22 +77,39 @@ func (c *Client) PutObject(ctx context.Context, bucketName string, objectName st
 		})
 	)
 
-	info, err := c.client.PutObject(ctx, bucketName, objectName, reader, objectSize, opts)
+	info, err := c.backend.PutObject(ctx, bucketName, objectName, reader, objectSize, opts)
 	if err != nil {
 		log.WithError(err).Error("PutObject fail")
 		return nil, err
 	}
 	log.Info("PutObject success")
 
-	respURL := fmt.Sprintf("http://%s/%s/%s", c.options.Endpoint, bucketName, objectName)
 	return &api.PutObjectResponse{
-		URL:  respURL,
+		URL:  c.URL(bucketName, objectName),
 		ETag: info.ETag,
 		Size: info.Size,
 	}, nil
 }
 
-func (c *Client) RemoveObject(ctx context.Context, bucketName string, objectName string, opts minio.RemoveObjectOptions) error {
+func (c *Client) GetObject(ctx context.Context, bucketName string, objectName string) (io.ReadCloser, *ObjectInfo, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
+			constant.Route:  constant.GetObject,
+			constant.Bucket: bucketName,
+			constant.Object: objectName,
+		})
+	)
+
+	reader, info, err := c.backend.GetObject(ctx, bucketName, objectName)
+	if err != nil {
+		log.WithError(err).Error("GetObject fail")
+		return nil, nil, err
+	}
+
+	return reader, info, nil
+}
+
+func (c *Client) RemoveObject(ctx context.Context, bucketName string, objectName string) error {
 	var (
 		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
 			constant.Route:  constant.RemoveObject,
@@ -92,7 +118,7 @@ func (c *Client) RemoveObject(ctx context.Context, bucketName string, objectName
 		})
 	)
 
-	if err := c.client.RemoveObject(ctx, bucketName, objectName, opts); err != nil {
+	if err := c.backend.RemoveObject(ctx, bucketName, objectName); err != nil {
 		log.WithError(err).Error("RemoveObject fail")
 		return err
 	}
@@ -101,31 +127,124 @@ func (c *Client) RemoveObject(ctx context.Context, bucketName string, objectName
 	return nil
 }
 
-func (c *Client) SetEndpoint(uri string) string {
-	return fmt.Sprintf("http://%s/%s", c.options.Endpoint, uri)
+func (c *Client) StatObject(ctx context.Context, bucketName string, objectName string) (*ObjectInfo, error) {
+	return c.backend.StatObject(ctx, bucketName, objectName)
+}
+
+func (c *Client) ListObjects(ctx context.Context, bucketName string, prefix string) ([]ObjectInfo, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
+			constant.Route:  constant.ListObjects,
+			constant.Bucket: bucketName,
+		})
+	)
+
+	objects, err := c.backend.ListObjects(ctx, bucketName, prefix)
+	if err != nil {
+		log.WithError(err).Errorf("ListObjects fail: %s", prefix)
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+func (c *Client) PresignedGetObject(ctx context.Context, bucketName string, objectName string, expiry time.Duration) (string, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
+			constant.Route:  constant.PresignObject,
+			constant.Bucket: bucketName,
+			constant.Object: objectName,
+		})
+	)
+
+	u, err := c.backend.PresignedGetObject(ctx, bucketName, objectName, expiry)
+	if err != nil {
+		log.WithError(err).Error("PresignedGetObject fail")
+		return "", err
+	}
+
+	return u, nil
+}
+
+func (c *Client) NewMultipartUpload(ctx context.Context, bucketName string, objectName string, opts PutOptions) (string, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
+			constant.Route:  constant.MultipartUpload,
+			constant.Bucket: bucketName,
+			constant.Object: objectName,
+		})
+	)
+
+	uploadID, err := c.backend.NewMultipartUpload(ctx, bucketName, objectName, opts)
+	if err != nil {
+		log.WithError(err).Error("NewMultipartUpload fail")
+		return "", err
+	}
+	log.Infof("NewMultipartUpload success: %s", uploadID)
+
+	return uploadID, nil
 }
 
-func (c *Client) GetObjectName(url string) string {
-	_, objectName, err := c.ParseURL(url)
+func (c *Client) PutObjectPart(ctx context.Context, bucketName string, objectName string, uploadID string, partNumber int, reader io.Reader, partSize int64) (*PartInfo, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
+			constant.Route:  constant.MultipartUpload,
+			constant.Bucket: bucketName,
+			constant.Object: objectName,
+			constant.Size:   partSize,
+		})
+	)
+
+	part, err := c.backend.PutObjectPart(ctx, bucketName, objectName, uploadID, partNumber, reader, partSize)
 	if err != nil {
-		return ""
+		log.WithError(err).Errorf("PutObjectPart fail: %s #%d", uploadID, partNumber)
+		return nil, err
 	}
-	return objectName
+
+	return part, nil
 }
 
-func (c *Client) ParseURL(fileURL string) (string, string, error) {
-	parsedURL, err := url.Parse(fileURL)
+func (c *Client) ListObjectParts(ctx context.Context, bucketName string, objectName string, uploadID string) ([]PartInfo, error) {
+	return c.backend.ListObjectParts(ctx, bucketName, objectName, uploadID)
+}
+
+func (c *Client) CompleteMultipartUpload(ctx context.Context, bucketName string, objectName string, uploadID string, parts []PartInfo) (*api.PutObjectResponse, error) {
+	var (
+		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
+			constant.Route:  constant.MultipartUpload,
+			constant.Bucket: bucketName,
+			constant.Object: objectName,
+		})
+	)
+
+	info, err := c.backend.CompleteMultipartUpload(ctx, bucketName, objectName, uploadID, parts)
 	if err != nil {
-		return "", "", err
+		log.WithError(err).Errorf("CompleteMultipartUpload fail: %s", uploadID)
+		return nil, err
 	}
+	log.Infof("CompleteMultipartUpload success: %s", uploadID)
 
-	// 解析路径，例如 "/my-bucket/my-file.txt"
-	path := strings.TrimPrefix(parsedURL.Path, "/")
-	parts := strings.SplitN(path, "/", 2)
+	return &api.PutObjectResponse{
+		URL:  c.URL(bucketName, objectName),
+		ETag: info.ETag,
+		Size: info.Size,
+	}, nil
+}
+
+func (c *Client) AbortMultipartUpload(ctx context.Context, bucketName string, objectName string, uploadID string) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx).WithFields(logrus.Fields{
+			constant.Route:  constant.MultipartUpload,
+			constant.Bucket: bucketName,
+			constant.Object: objectName,
+		})
+	)
 
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("invalid URL format")
+	if err := c.backend.AbortMultipartUpload(ctx, bucketName, objectName, uploadID); err != nil {
+		log.WithError(err).Errorf("AbortMultipartUpload fail: %s", uploadID)
+		return err
 	}
+	log.Infof("AbortMultipartUpload success: %s", uploadID)
 
-	return parts[0], parts[1], nil
+	return nil
 }
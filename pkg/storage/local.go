@@ -0,0 +1,456 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	ErrUploadNotFound = errors.New("multipart upload not found")
+)
+
+type LocalOptions struct {
+	Root    string `json:"root"`
+	BaseURL string `json:"baseURL"` // 对外访问前缀，例如 http://localhost:8080/storage/file
+}
+
+// localBackend 把对象保存在本地目录 root/bucket/object 下，用于本地开发和测试
+type localBackend struct {
+	root    string
+	baseURL string
+}
+
+func newLocalBackend(options *LocalOptions) (*localBackend, error) {
+	if options == nil || options.Root == "" {
+		return nil, fmt.Errorf("local storage root is empty")
+	}
+
+	root, err := filepath.Abs(options.Root)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &localBackend{
+		root:    root,
+		baseURL: strings.TrimSuffix(options.BaseURL, "/"),
+	}, nil
+}
+
+// path 返回对象在磁盘上的路径，拒绝跳出 root 的对象名；
+// 以 . 开头的目录（例如 .multipart）是内部使用的，不能作为桶名
+func (b *localBackend) path(bucketName string, objectName string) (string, error) {
+	if !validBucketName(bucketName) {
+		return "", fmt.Errorf("invalid bucket name: %s", bucketName)
+	}
+
+	cleaned := path.Clean("/" + objectName)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid object name: %s", objectName)
+	}
+
+	return filepath.Join(b.root, bucketName, filepath.FromSlash(cleaned)), nil
+}
+
+func (b *localBackend) MakeBucket(_ context.Context, bucketName string) error {
+	if !validBucketName(bucketName) {
+		return fmt.Errorf("invalid bucket name: %s", bucketName)
+	}
+	return os.MkdirAll(filepath.Join(b.root, bucketName), 0755)
+}
+
+func validBucketName(bucketName string) bool {
+	return bucketName != "" && !strings.HasPrefix(bucketName, ".") && !strings.ContainsAny(bucketName, `/\`)
+}
+
+func (b *localBackend) PutObject(_ context.Context, bucketName string, objectName string, reader io.Reader, _ int64, opts PutOptions) (*ObjectInfo, error) {
+	filePath, err := b.path(bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, err
+	}
+
+	// 先写临时文件再 rename，避免读到写了一半的对象
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), reader)
+	if err != nil {
+		_ = tmp.Close()
+		return nil, err
+	}
+	if err = tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp.Name(), filePath); err != nil {
+		return nil, err
+	}
+
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = detectContentType(filePath)
+	}
+
+	return &ObjectInfo{
+		Bucket:       bucketName,
+		Key:          objectName,
+		Size:         size,
+		ETag:         hex.EncodeToString(hash.Sum(nil)),
+		ContentType:  contentType,
+		LastModified: time.Now(),
+	}, nil
+}
+
+func (b *localBackend) GetObject(ctx context.Context, bucketName string, objectName string) (io.ReadCloser, *ObjectInfo, error) {
+	info, err := b.StatObject(ctx, bucketName, objectName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filePath, _ := b.path(bucketName, objectName)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+func (b *localBackend) RemoveObject(_ context.Context, bucketName string, objectName string) error {
+	filePath, err := b.path(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+
+	// 与 MinIO 保持一致：删除不存在的对象不报错
+	if err = os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *localBackend) StatObject(_ context.Context, bucketName string, objectName string) (*ObjectInfo, error) {
+	filePath, err := b.path(bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, ErrObjectNotFound
+	}
+
+	return &ObjectInfo{
+		Bucket:       bucketName,
+		Key:          objectName,
+		Size:         stat.Size(),
+		ETag:         fmt.Sprintf("%x-%x", stat.ModTime().UnixNano(), stat.Size()),
+		ContentType:  detectContentType(filePath),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+func (b *localBackend) ListObjects(_ context.Context, bucketName string, prefix string) ([]ObjectInfo, error) {
+	bucketPath := filepath.Join(b.root, bucketName)
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(bucketPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(bucketPath, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Bucket:       bucketName,
+			Key:          key,
+			Size:         info.Size(),
+			ETag:         fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// PresignedGetObject 本地文件通过公开路由访问，不需要签名，直接返回访问地址
+func (b *localBackend) PresignedGetObject(ctx context.Context, bucketName string, objectName string, _ time.Duration) (string, error) {
+	if _, err := b.StatObject(ctx, bucketName, objectName); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%s", b.baseURL, bucketName, objectName), nil
+}
+
+func detectContentType(filePath string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(filePath)); contentType != "" {
+		return contentType
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+	return http.DetectContentType(buf[:n])
+}
+
+// 分片上传时各分片先保存在 root/.multipart/{uploadID} 下，完成时按序拼接成对象
+const multipartDir = ".multipart"
+
+type localUpload struct {
+	Bucket      string `json:"bucket"`
+	Object      string `json:"object"`
+	ContentType string `json:"contentType"`
+}
+
+func (b *localBackend) uploadPath(uploadID string) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return "", fmt.Errorf("invalid upload id: %s", uploadID)
+	}
+	return filepath.Join(b.root, multipartDir, uploadID), nil
+}
+
+func (b *localBackend) loadUpload(bucketName string, objectName string, uploadID string) (string, *localUpload, error) {
+	dir, err := b.uploadPath(uploadID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil, ErrUploadNotFound
+		}
+		return "", nil, err
+	}
+
+	var upload localUpload
+	if err = json.Unmarshal(data, &upload); err != nil {
+		return "", nil, err
+	}
+	if upload.Bucket != bucketName || upload.Object != objectName {
+		return "", nil, ErrUploadNotFound
+	}
+	return dir, &upload, nil
+}
+
+func (b *localBackend) NewMultipartUpload(_ context.Context, bucketName string, objectName string, opts PutOptions) (string, error) {
+	if _, err := b.path(bucketName, objectName); err != nil {
+		return "", err
+	}
+
+	uploadID := uuid.New().String()
+	dir, _ := b.uploadPath(uploadID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(&localUpload{
+		Bucket:      bucketName,
+		Object:      objectName,
+		ContentType: opts.ContentType,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(filepath.Join(dir, "upload.json"), data, 0644); err != nil {
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+func (b *localBackend) PutObjectPart(_ context.Context, bucketName string, objectName string, uploadID string, partNumber int, reader io.Reader, _ int64) (*PartInfo, error) {
+	dir, _, err := b.loadUpload(bucketName, objectName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), reader)
+	if err != nil {
+		_ = tmp.Close()
+		return nil, err
+	}
+	if err = tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	// 同一分片重复上传时覆盖之前的内容
+	if err = os.Rename(tmp.Name(), filepath.Join(dir, fmt.Sprintf("%05d", partNumber))); err != nil {
+		return nil, err
+	}
+
+	return &PartInfo{
+		PartNumber:   partNumber,
+		ETag:         hex.EncodeToString(hash.Sum(nil)),
+		Size:         size,
+		LastModified: time.Now(),
+	}, nil
+}
+
+func (b *localBackend) ListObjectParts(_ context.Context, bucketName string, objectName string, uploadID string) ([]PartInfo, error) {
+	dir, _, err := b.loadUpload(bucketName, objectName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []PartInfo
+	for _, entry := range entries {
+		partNumber, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		etag, err := fileMD5(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, PartInfo{
+			PartNumber:   partNumber,
+			ETag:         etag,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+func (b *localBackend) CompleteMultipartUpload(ctx context.Context, bucketName string, objectName string, uploadID string, parts []PartInfo) (*ObjectInfo, error) {
+	dir, upload, err := b.loadUpload(bucketName, objectName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		readers []io.Reader
+		size    int64
+	)
+	for _, part := range parts {
+		file, err := os.Open(filepath.Join(dir, fmt.Sprintf("%05d", part.PartNumber)))
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %w", part.PartNumber, err)
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+
+		readers = append(readers, file)
+		size += part.Size
+	}
+
+	info, err := b.PutObject(ctx, bucketName, objectName, io.MultiReader(readers...), size, PutOptions{
+		ContentType: upload.ContentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (b *localBackend) AbortMultipartUpload(_ context.Context, bucketName string, objectName string, uploadID string) error {
+	dir, _, err := b.loadUpload(bucketName, objectName, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func fileMD5(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	hash := md5.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
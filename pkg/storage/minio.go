@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type minioBackend struct {
+	client *minio.Client
+	core   *minio.Core
+}
+
+func newMinioBackend(options *Options) (*minioBackend, error) {
+	client, err := minio.New(options.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(options.ID, options.Secret, ""),
+		Secure: options.Secure,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &minioBackend{
+		client: client,
+		core:   &minio.Core{Client: client},
+	}, nil
+}
+
+func (b *minioBackend) MakeBucket(ctx context.Context, bucketName string) error {
+	if err := b.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{}); err != nil {
+		exists, errBucketExists := b.client.BucketExists(ctx, bucketName)
+		if errBucketExists != nil {
+			return errBucketExists
+		}
+		if !exists {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *minioBackend) PutObject(ctx context.Context, bucketName string, objectName string, reader io.Reader, objectSize int64, opts PutOptions) (*ObjectInfo, error) {
+	info, err := b.client.PutObject(ctx, bucketName, objectName, reader, objectSize, minio.PutObjectOptions{
+		ContentType: opts.ContentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Bucket:       bucketName,
+		Key:          objectName,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		ContentType:  opts.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (b *minioBackend) GetObject(ctx context.Context, bucketName string, objectName string) (io.ReadCloser, *ObjectInfo, error) {
+	object, err := b.client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// GetObject 是惰性的，Stat 之后才能确认对象存在
+	stat, err := object.Stat()
+	if err != nil {
+		_ = object.Close()
+		return nil, nil, err
+	}
+
+	return object, toObjectInfo(bucketName, stat), nil
+}
+
+func (b *minioBackend) RemoveObject(ctx context.Context, bucketName string, objectName string) error {
+	return b.client.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{})
+}
+
+func (b *minioBackend) StatObject(ctx context.Context, bucketName string, objectName string) (*ObjectInfo, error) {
+	stat, err := b.client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return toObjectInfo(bucketName, stat), nil
+}
+
+func (b *minioBackend) ListObjects(ctx context.Context, bucketName string, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for object := range b.client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, *toObjectInfo(bucketName, object))
+	}
+	return objects, nil
+}
+
+func (b *minioBackend) PresignedGetObject(ctx context.Context, bucketName string, objectName string, expiry time.Duration) (string, error) {
+	u, err := b.client.PresignedGetObject(ctx, bucketName, objectName, expiry, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (b *minioBackend) NewMultipartUpload(ctx context.Context, bucketName string, objectName string, opts PutOptions) (string, error) {
+	return b.core.NewMultipartUpload(ctx, bucketName, objectName, minio.PutObjectOptions{
+		ContentType: opts.ContentType,
+	})
+}
+
+func (b *minioBackend) PutObjectPart(ctx context.Context, bucketName string, objectName string, uploadID string, partNumber int, reader io.Reader, partSize int64) (*PartInfo, error) {
+	part, err := b.core.PutObjectPart(ctx, bucketName, objectName, uploadID, partNumber, reader, partSize, minio.PutObjectPartOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PartInfo{
+		PartNumber:   part.PartNumber,
+		ETag:         part.ETag,
+		Size:         part.Size,
+		LastModified: part.LastModified,
+	}, nil
+}
+
+func (b *minioBackend) ListObjectParts(ctx context.Context, bucketName string, objectName string, uploadID string) ([]PartInfo, error) {
+	var (
+		parts  []PartInfo
+		marker int
+	)
+
+	for {
+		result, err := b.core.ListObjectParts(ctx, bucketName, objectName, uploadID, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, part := range result.ObjectParts {
+			parts = append(parts, PartInfo{
+				PartNumber:   part.PartNumber,
+				ETag:         part.ETag,
+				Size:         part.Size,
+				LastModified: part.LastModified,
+			})
+		}
+
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+func (b *minioBackend) CompleteMultipartUpload(ctx context.Context, bucketName string, objectName string, uploadID string, parts []PartInfo) (*ObjectInfo, error) {
+	completeParts := make([]minio.CompletePart, len(parts))
+	for i, part := range parts {
+		completeParts[i] = minio.CompletePart{
+			PartNumber: part.PartNumber,
+			ETag:       part.ETag,
+		}
+	}
+
+	if _, err := b.core.CompleteMultipartUpload(ctx, bucketName, objectName, uploadID, completeParts, minio.PutObjectOptions{}); err != nil {
+		return nil, err
+	}
+
+	return b.StatObject(ctx, bucketName, objectName)
+}
+
+func (b *minioBackend) AbortMultipartUpload(ctx context.Context, bucketName string, objectName string, uploadID string) error {
+	err := b.core.AbortMultipartUpload(ctx, bucketName, objectName, uploadID)
+	if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+		return ErrUploadNotFound
+	}
+	return err
+}
+
+func toObjectInfo(bucketName string, info minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Bucket:       bucketName,
+		Key:          info.Key,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}
+}
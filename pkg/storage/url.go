@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultBucket = "picture"
+
+// CDNOptions 开启后对外地址按 CDN 鉴权方式 A 签名：
+// auth_key={timestamp}-{rand}-{uid}-md5({path}-{timestamp}-{rand}-{uid}-{secret})
+type CDNOptions struct {
+	Secret string `json:"secret"`
+	Param  string `json:"param"` // 签名参数名，默认 auth_key
+}
+
+// URL 返回对象的对外访问地址
+func (c *Client) URL(bucketName string, objectName string) string {
+	var u string
+	if base, ok := c.options.BucketURLs[bucketName]; ok && base != "" {
+		u = fmt.Sprintf("%s/%s", strings.TrimSuffix(base, "/"), objectName)
+	} else {
+		u = fmt.Sprintf("%s/%s/%s", c.baseURL(), bucketName, objectName)
+	}
+
+	if c.options.CDN != nil && c.options.CDN.Secret != "" {
+		return c.sign(u)
+	}
+	return u
+}
+
+// ObjectURL 把数据库中保存的对象 key 渲染成访问地址。
+// key 可以是 "bucket/object" 或默认桶下的 "object"，已经是完整地址的旧数据原样返回
+func (c *Client) ObjectURL(key string) string {
+	if key == "" || isAbsoluteURL(key) {
+		return key
+	}
+
+	bucketName, objectName := c.splitKey(key)
+	return c.URL(bucketName, objectName)
+}
+
+// ObjectKey 是 ObjectURL 的逆操作，把本服务生成的地址还原成用于保存的 key，
+// 默认桶下的对象只保留对象名；无法识别的地址原样返回
+func (c *Client) ObjectKey(value string) string {
+	if !isAbsoluteURL(value) {
+		return value
+	}
+
+	bucketName, objectName, err := c.ParseURL(value)
+	if err != nil || !c.IsBucket(bucketName) {
+		return value
+	}
+	if bucketName == c.defaultBucket() {
+		return objectName
+	}
+	return bucketName + "/" + objectName
+}
+
+func (c *Client) GetObjectName(url string) string {
+	_, objectName, err := c.ParseURL(url)
+	if err != nil {
+		return ""
+	}
+	return objectName
+}
+
+// ParseURL 从对象地址或 key 中解析出桶名和对象名
+func (c *Client) ParseURL(fileURL string) (string, string, error) {
+	parsedURL, err := url.Parse(fileURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	if parsedURL.Host == "" {
+		bucketName, objectName := c.splitKey(strings.TrimPrefix(parsedURL.Path, "/"))
+		if objectName == "" {
+			return "", "", fmt.Errorf("invalid URL format")
+		}
+		return bucketName, objectName, nil
+	}
+
+	for bucketName, base := range c.options.BucketURLs {
+		if objectName, ok := trimBase(parsedURL, base); ok {
+			return bucketName, objectName, nil
+		}
+	}
+
+	// 解析路径，例如 "/my-bucket/my-file.txt"，带路由前缀时需要先去掉前缀
+	path, ok := trimBase(parsedURL, c.baseURL())
+	if !ok {
+		path = strings.TrimPrefix(parsedURL.Path, "/")
+	}
+	parts := strings.SplitN(path, "/", 2)
+
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid URL format")
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func (c *Client) baseURL() string {
+	if c.options.PublicURL != "" {
+		return strings.TrimSuffix(c.options.PublicURL, "/")
+	}
+	if c.options.Driver == DriverLocal {
+		return strings.TrimSuffix(c.options.Local.BaseURL, "/")
+	}
+
+	scheme := "http"
+	if c.options.Secure {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.options.Endpoint)
+}
+
+func (c *Client) defaultBucket() string {
+	if c.options.DefaultBucket != "" {
+		return c.options.DefaultBucket
+	}
+	return defaultBucket
+}
+
+// IsBucket 判断是否为配置的桶，包括默认桶
+func (c *Client) IsBucket(bucketName string) bool {
+	if bucketName == c.defaultBucket() {
+		return true
+	}
+	for _, bucket := range c.options.Buckets {
+		if bucket == bucketName {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) splitKey(key string) (string, string) {
+	if parts := strings.SplitN(key, "/", 2); len(parts) == 2 && c.IsBucket(parts[0]) {
+		return parts[0], parts[1]
+	}
+	return c.defaultBucket(), key
+}
+
+func (c *Client) sign(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	param := c.options.CDN.Param
+	if param == "" {
+		param = "auth_key"
+	}
+
+	timestamp := time.Now().Unix()
+	random := strings.ReplaceAll(uuid.New().String(), "-", "")
+	sum := md5.Sum([]byte(fmt.Sprintf("%s-%d-%s-0-%s", u.Path, timestamp, random, c.options.CDN.Secret)))
+
+	query := u.Query()
+	query.Set(param, fmt.Sprintf("%d-%s-0-%s", timestamp, random, hex.EncodeToString(sum[:])))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// trimBase 判断地址是否以 base 开头，是则返回去掉 base 之后的路径
+func trimBase(u *url.URL, base string) (string, bool) {
+	baseURL, err := url.Parse(base)
+	if err != nil || baseURL.Host == "" || !strings.EqualFold(baseURL.Host, u.Host) {
+		return "", false
+	}
+
+	prefix := strings.TrimSuffix(baseURL.Path, "/") + "/"
+	if !strings.HasPrefix(u.Path, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(u.Path, prefix), true
+}
+
+func isAbsoluteURL(value string) bool {
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
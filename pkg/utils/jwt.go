@@ -1,47 +1,296 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 
 	"smile.expression/destiny/pkg/database/model"
 )
 
-var jwtKey = []byte("a_secret_crect")
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type JWTOptions struct {
+	Algorithm  string          `json:"algorithm"`  // 默认算法：HS256、RS256 或 EdDSA，默认 HS256
+	TTL        int             `json:"ttl"`        // access token 有效期（秒），默认 15 分钟
+	Issuer     string          `json:"issuer"`     // 默认 SYSU-sse
+	SigningKey string          `json:"signingKey"` // 签发新 token 使用的 kid
+	Keys       []JWTKeyOptions `json:"keys"`       // 所有可用于校验的 key，轮换时旧 key 保留到它签发的 token 过期
+}
+
+type JWTKeyOptions struct {
+	ID             string `json:"id"`
+	Algorithm      string `json:"algorithm"`      // 为空时使用 JWTOptions.Algorithm
+	Secret         string `json:"secret"`         // HS256 密钥
+	PrivateKeyFile string `json:"privateKeyFile"` // RS256/EdDSA 私钥 PEM 文件，只用于校验的旧 key 可以不配置
+	PublicKeyFile  string `json:"publicKeyFile"`  // RS256/EdDSA 公钥 PEM 文件
+}
 
 type Claims struct {
 	UserID uint
 	jwt.StandardClaims
 }
 
+// JWK JSON Web Key，只用于发布非对称 key 的公钥
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWT struct {
+	options *JWTOptions
+	keys    map[string]*jwtKey
+	signing *jwtKey
+}
+
+type jwtKey struct {
+	id         string
+	method     jwt.SigningMethod
+	signKey    interface{}
+	verifyKey  interface{}
+	publicKey  crypto.PublicKey
+	asymmetric bool
+}
+
+func NewJWT(options *JWTOptions) (*JWT, error) {
+	if options == nil || len(options.Keys) == 0 {
+		return nil, fmt.Errorf("jwt keys are not configured")
+	}
+	if options.Algorithm == "" {
+		options.Algorithm = AlgorithmHS256
+	}
+	if options.TTL <= 0 {
+		options.TTL = int((15 * time.Minute).Seconds())
+	}
+	if options.Issuer == "" {
+		options.Issuer = "SYSU-sse"
+	}
+
+	j := &JWT{
+		options: options,
+		keys:    make(map[string]*jwtKey, len(options.Keys)),
+	}
+	for i := range options.Keys {
+		key, err := loadKey(&options.Keys[i], options.Algorithm)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", options.Keys[i].ID, err)
+		}
+		if _, exists := j.keys[key.id]; exists {
+			return nil, fmt.Errorf("duplicate jwt key id: %s", key.id)
+		}
+		j.keys[key.id] = key
+	}
+
+	signing, ok := j.keys[options.SigningKey]
+	if !ok && len(options.Keys) == 1 {
+		signing = j.keys[options.Keys[0].ID]
+	}
+	if signing == nil {
+		return nil, fmt.Errorf("jwt signing key not found: %s", options.SigningKey)
+	}
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("jwt signing key has no private key: %s", signing.id)
+	}
+	j.signing = signing
+
+	return j, nil
+}
+
+func loadKey(options *JWTKeyOptions, defaultAlgorithm string) (*jwtKey, error) {
+	algorithm := options.Algorithm
+	if algorithm == "" {
+		algorithm = defaultAlgorithm
+	}
+
+	key := &jwtKey{
+		id:     options.ID,
+		method: jwt.GetSigningMethod(algorithm),
+	}
+
+	switch algorithm {
+	case AlgorithmHS256:
+		if options.Secret == "" {
+			return nil, fmt.Errorf("secret is empty")
+		}
+		key.signKey = []byte(options.Secret)
+		key.verifyKey = []byte(options.Secret)
+	case AlgorithmRS256:
+		if options.PrivateKeyFile != "" {
+			data, err := os.ReadFile(options.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = privateKey
+			key.verifyKey = &privateKey.PublicKey
+		}
+		if options.PublicKeyFile != "" {
+			data, err := os.ReadFile(options.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = publicKey
+		}
+		key.asymmetric = true
+	case AlgorithmEdDSA:
+		if options.PrivateKeyFile != "" {
+			data, err := os.ReadFile(options.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = privateKey
+			key.verifyKey = privateKey.(ed25519.PrivateKey).Public()
+		}
+		if options.PublicKeyFile != "" {
+			data, err := os.ReadFile(options.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			publicKey, err := jwt.ParseEdPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = publicKey
+		}
+		key.asymmetric = true
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+
+	if key.verifyKey == nil {
+		return nil, fmt.Errorf("neither private key nor public key is configured")
+	}
+	if key.asymmetric {
+		key.publicKey = key.verifyKey
+	}
+	return key, nil
+}
+
 // ReleaseToken 生成token
-func ReleaseToken(user model.User) (string, error) {
-	expirationTime := time.Now().Add(7 * 24 * time.Hour)
+func (j *JWT) ReleaseToken(user model.User) (string, error) {
+	now := time.Now()
 	claims := &Claims{
 		UserID: user.ID,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-			IssuedAt:  time.Now().Unix(),
-			Issuer:    "SYSU-sse",
+			ExpiresAt: now.Add(time.Duration(j.options.TTL) * time.Second).Unix(),
+			IssuedAt:  now.Unix(),
+			Id:        uuid.New().String(),
+			Issuer:    j.options.Issuer,
 			Subject:   "user token",
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	token := jwt.NewWithClaims(j.signing.method, claims)
+	token.Header["kid"] = j.signing.id
 
-	if err != nil {
-		return "", err
-	}
-	return tokenString, err
+	return token.SignedString(j.signing.signKey)
 }
 
-func ParseToken(tokenString string) (*jwt.Token, *Claims, error) {
+// ParseToken 按 header 中的 kid 选择校验 key，没有 kid 的旧 token 使用当前签名 key 校验
+func (j *JWT) ParseToken(tokenString string) (*jwt.Token, *Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (i interface{}, err error) {
-		return jwtKey, nil
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		key := j.signing
+		if kid, ok := token.Header["kid"].(string); ok {
+			if key, ok = j.keys[kid]; !ok {
+				return nil, ErrUnknownKey
+			}
+		}
+
+		// 算法必须与 key 一致，防止用公钥当 HMAC 密钥伪造 token
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+		}
+		return key.verifyKey, nil
 	})
+	if err != nil {
+		return token, claims, err
+	}
+
+	if !claims.VerifyIssuer(j.options.Issuer, true) {
+		token.Valid = false
+		return token, claims, fmt.Errorf("unexpected issuer: %s", claims.Issuer)
+	}
+
+	return token, claims, nil
+}
+
+// TTL 返回 access token 的有效期
+func (j *JWT) TTL() time.Duration {
+	return time.Duration(j.options.TTL) * time.Second
+}
 
-	return token, claims, err
+// Asymmetric 是否配置了可以公开发布的非对称 key
+func (j *JWT) Asymmetric() bool {
+	for _, key := range j.keys {
+		if key.asymmetric {
+			return true
+		}
+	}
+	return false
+}
+
+// JWKS 返回所有非对称 key 的公钥
+func (j *JWT) JWKS() []JWK {
+	jwks := make([]JWK, 0, len(j.keys))
+	for _, key := range j.keys {
+		switch publicKey := key.publicKey.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "RSA",
+				Kid: key.id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "OKP",
+				Kid: key.id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+
+	sort.Slice(jwks, func(i, k int) bool {
+		return jwks[i].Kid < jwks[k].Kid
+	})
+	return jwks
 }
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"smile.expression/destiny/pkg/database/model"
+)
+
+// writeEdDSAKeys 生成一对 Ed25519 key，返回私钥和公钥 PEM 文件路径
+func writeEdDSAKeys(t *testing.T) (string, string) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	privateFile := filepath.Join(dir, "private.pem")
+	publicFile := filepath.Join(dir, "public.pem")
+	if err = os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return privateFile, publicFile
+}
+
+func TestNewJWT(t *testing.T) {
+	privateFile, publicFile := writeEdDSAKeys(t)
+
+	tests := []struct {
+		name       string
+		options    *JWTOptions
+		wantErr    bool
+		asymmetric bool
+	}{
+		{name: "nil options", options: nil, wantErr: true},
+		{name: "no keys", options: &JWTOptions{}, wantErr: true},
+		{name: "single hs256 key", options: &JWTOptions{Keys: []JWTKeyOptions{{ID: "a", Secret: "secret"}}}},
+		{name: "empty secret", options: &JWTOptions{Keys: []JWTKeyOptions{{ID: "a"}}}, wantErr: true},
+		{name: "duplicate kid", options: &JWTOptions{SigningKey: "a", Keys: []JWTKeyOptions{{ID: "a", Secret: "x"}, {ID: "a", Secret: "y"}}}, wantErr: true},
+		{name: "signing key not found", options: &JWTOptions{SigningKey: "c", Keys: []JWTKeyOptions{{ID: "a", Secret: "x"}, {ID: "b", Secret: "y"}}}, wantErr: true},
+		{name: "rotation", options: &JWTOptions{SigningKey: "b", Keys: []JWTKeyOptions{{ID: "a", Secret: "x"}, {ID: "b", Secret: "y"}}}},
+		{name: "unsupported algorithm", options: &JWTOptions{Algorithm: "none", Keys: []JWTKeyOptions{{ID: "a", Secret: "x"}}}, wantErr: true},
+		{name: "eddsa", options: &JWTOptions{Algorithm: AlgorithmEdDSA, Keys: []JWTKeyOptions{{ID: "a", PrivateKeyFile: privateFile}}}, asymmetric: true},
+		{name: "eddsa public key only", options: &JWTOptions{Algorithm: AlgorithmEdDSA, Keys: []JWTKeyOptions{{ID: "a", PublicKeyFile: publicFile}}}, wantErr: true},
+		{name: "eddsa without key files", options: &JWTOptions{Algorithm: AlgorithmEdDSA, Keys: []JWTKeyOptions{{ID: "a"}}}, wantErr: true},
+		{name: "eddsa missing file", options: &JWTOptions{Algorithm: AlgorithmEdDSA, Keys: []JWTKeyOptions{{ID: "a", PrivateKeyFile: filepath.Join(t.TempDir(), "missing.pem")}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := NewJWT(tt.options)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewJWT error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewJWT error: %v", err)
+			}
+			if j.Asymmetric() != tt.asymmetric {
+				t.Errorf("Asymmetric() = %v, want %v", j.Asymmetric(), tt.asymmetric)
+			}
+
+			user := model.User{}
+			user.ID = 42
+			token, err := j.ReleaseToken(user)
+			if err != nil {
+				t.Fatalf("ReleaseToken error: %v", err)
+			}
+			parsed, claims, err := j.ParseToken(token)
+			if err != nil || !parsed.Valid {
+				t.Fatalf("ParseToken error: %v", err)
+			}
+			if claims.UserID != user.ID {
+				t.Errorf("claims.UserID = %d, want %d", claims.UserID, user.ID)
+			}
+		})
+	}
+}
+
+func TestNewJWTDefaults(t *testing.T) {
+	j, err := NewJWT(&JWTOptions{Keys: []JWTKeyOptions{{ID: "a", Secret: "secret"}}})
+	if err != nil {
+		t.Fatalf("NewJWT error: %v", err)
+	}
+	if j.options.Algorithm != AlgorithmHS256 {
+		t.Errorf("Algorithm = %s, want %s", j.options.Algorithm, AlgorithmHS256)
+	}
+	if j.TTL() != 15*time.Minute {
+		t.Errorf("TTL() = %s, want %s", j.TTL(), 15*time.Minute)
+	}
+	if j.options.Issuer != "SYSU-sse" {
+		t.Errorf("Issuer = %s, want SYSU-sse", j.options.Issuer)
+	}
+}
+
+func TestParseTokenRotation(t *testing.T) {
+	old, err := NewJWT(&JWTOptions{SigningKey: "a", Keys: []JWTKeyOptions{{ID: "a", Secret: "x"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := old.ReleaseToken(model.User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keys    []JWTKeyOptions
+		signing string
+		wantErr bool
+	}{
+		{name: "old key kept", keys: []JWTKeyOptions{{ID: "a", Secret: "x"}, {ID: "b", Secret: "y"}}, signing: "b"},
+		{name: "old key removed", keys: []JWTKeyOptions{{ID: "b", Secret: "y"}}, signing: "b", wantErr: true},
+		{name: "secret changed", keys: []JWTKeyOptions{{ID: "a", Secret: "z"}}, signing: "a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := NewJWT(&JWTOptions{SigningKey: tt.signing, Keys: tt.keys})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err = j.ParseToken(token); (err != nil) != tt.wantErr {
+				t.Errorf("ParseToken error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package verification
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"smile.expression/destiny/pkg/cache"
+	"smile.expression/destiny/pkg/logger"
+)
+
+// Purpose 验证码用途，不同用途的验证码互不通用
+type Purpose string
+
+const (
+	PurposeRegister        Purpose = "register"
+	PurposeResetPassword   Purpose = "reset_password"
+	PurposeChangeTelephone Purpose = "change_telephone"
+)
+
+var (
+	ErrTooFrequent     = errors.New("verification code requested too frequently")
+	ErrInvalidCode     = errors.New("invalid verification code")
+	ErrTooManyAttempts = errors.New("too many verification attempts")
+	ErrInvalidPurpose  = errors.New("invalid verification purpose")
+)
+
+type Client struct {
+	cacheClient *cache.Client
+	sender      SMSSender
+	options     *Options
+}
+
+type Options struct {
+	CodeLength     int            `json:"codeLength"`     // 验证码位数，默认 6
+	TTL            int            `json:"ttl"`            // 验证码有效期（秒），默认 5 分钟
+	ResendInterval int            `json:"resendInterval"` // 同一号码两次发送的最小间隔（秒），默认 60
+	MaxAttempts    int            `json:"maxAttempts"`    // 每个验证码允许输错的次数，默认 5
+	Sender         *SenderOptions `json:"sender"`
+}
+
+func NewClient(options *Options, cacheClient *cache.Client) *Client {
+	if options == nil {
+		options = &Options{}
+	}
+
+	sender, err := newSender(options.Sender)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Client{
+		cacheClient: cacheClient,
+		sender:      sender,
+		options:     options,
+	}
+}
+
+// ParsePurpose 校验客户端传入的用途
+func ParsePurpose(s string) (Purpose, error) {
+	switch p := Purpose(s); p {
+	case PurposeRegister, PurposeResetPassword, PurposeChangeTelephone:
+		return p, nil
+	default:
+		return "", ErrInvalidPurpose
+	}
+}
+
+// Send 生成验证码并发送，redis 中只保存验证码的哈希
+func (c *Client) Send(ctx context.Context, purpose Purpose, telephone string) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	// 限制重发频率，key 存在说明间隔未到
+	throttled, err := c.cacheClient.Exists(ctx, throttleKey(purpose, telephone))
+	if err != nil {
+		return err
+	}
+	if throttled {
+		return ErrTooFrequent
+	}
+
+	code, err := c.generateCode()
+	if err != nil {
+		return err
+	}
+
+	if err = c.cacheClient.Set(ctx, codeKey(purpose, telephone), []byte(hashCode(telephone, code)), int(c.ttl().Seconds())); err != nil {
+		return err
+	}
+	// 新验证码重新计算输错次数
+	if err = c.cacheClient.Delete(ctx, attemptsKey(purpose, telephone)); err != nil {
+		return err
+	}
+	if err = c.cacheClient.Set(ctx, throttleKey(purpose, telephone), []byte("1"), int(c.resendInterval().Seconds())); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("您的验证码为 %s，%d 分钟内有效，请勿泄露给他人。", code, int(c.ttl().Minutes()))
+	if err = c.sender.Send(ctx, telephone, message); err != nil {
+		// 发送失败时允许立即重试
+		if errDelete := c.cacheClient.Delete(ctx, throttleKey(purpose, telephone)); errDelete != nil {
+			log.WithError(errDelete).Errorf("delete verification throttle error: %s", telephone)
+		}
+		return err
+	}
+
+	log.Infof("verification code sent: %s, purpose: %s", telephone, purpose)
+	return nil
+}
+
+// Verify 校验验证码，成功后验证码作废
+func (c *Client) Verify(ctx context.Context, purpose Purpose, telephone string, code string) error {
+	data, err := c.cacheClient.Get(ctx, codeKey(purpose, telephone))
+	if err != nil {
+		if cache.IsMiss(err) {
+			return ErrInvalidCode
+		}
+		return err
+	}
+
+	attempts, err := c.cacheClient.Incr(ctx, attemptsKey(purpose, telephone), int(c.ttl().Seconds()))
+	if err != nil {
+		return err
+	}
+	if attempts > int64(c.maxAttempts()) {
+		// 输错次数过多，验证码作废，需要重新获取
+		if err = c.cacheClient.Delete(ctx, codeKey(purpose, telephone), attemptsKey(purpose, telephone)); err != nil {
+			return err
+		}
+		return ErrTooManyAttempts
+	}
+
+	if subtle.ConstantTimeCompare(data, []byte(hashCode(telephone, code))) != 1 {
+		return ErrInvalidCode
+	}
+
+	return c.cacheClient.Delete(ctx, codeKey(purpose, telephone), attemptsKey(purpose, telephone))
+}
+
+func (c *Client) generateCode() (string, error) {
+	length := c.options.CodeLength
+	if length <= 0 {
+		length = 6
+	}
+
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
+func (c *Client) ttl() time.Duration {
+	if c.options.TTL > 0 {
+		return time.Duration(c.options.TTL) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+func (c *Client) resendInterval() time.Duration {
+	if c.options.ResendInterval > 0 {
+		return time.Duration(c.options.ResendInterval) * time.Second
+	}
+	return time.Minute
+}
+
+func (c *Client) maxAttempts() int {
+	if c.options.MaxAttempts > 0 {
+		return c.options.MaxAttempts
+	}
+	return 5
+}
+
+func hashCode(telephone string, code string) string {
+	sum := sha256.Sum256([]byte(telephone + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func codeKey(purpose Purpose, telephone string) string {
+	return fmt.Sprintf("verify/code/%s/%s", purpose, telephone)
+}
+
+func attemptsKey(purpose Purpose, telephone string) string {
+	return fmt.Sprintf("verify/attempts/%s/%s", purpose, telephone)
+}
+
+func throttleKey(purpose Purpose, telephone string) string {
+	return fmt.Sprintf("verify/throttle/%s/%s", purpose, telephone)
+}
@@ -0,0 +1,250 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/sirupsen/logrus"
+
+	"smile.expression/destiny/pkg/cache"
+	"smile.expression/destiny/pkg/logger"
+)
+
+const testTelephone = "13800000001"
+
+var codePattern = regexp.MustCompile(`\d{6}`)
+
+func TestMain(m *testing.M) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	logger.SmileLog = &logger.SmileLogger{Logger: log}
+
+	os.Exit(m.Run())
+}
+
+// recordSender 记录发出的短信，err 不为空时发送失败
+type recordSender struct {
+	messages []string
+	err      error
+}
+
+func (s *recordSender) Send(_ context.Context, _ string, message string) error {
+	s.messages = append(s.messages, message)
+	return s.err
+}
+
+// lastCode 最近一条短信中的验证码
+func (s *recordSender) lastCode(t *testing.T) string {
+	t.Helper()
+
+	if len(s.messages) == 0 {
+		t.Fatal("no sms sent")
+	}
+	code := codePattern.FindString(s.messages[len(s.messages)-1])
+	if code == "" {
+		t.Fatalf("no code in sms: %s", s.messages[len(s.messages)-1])
+	}
+	return code
+}
+
+func newTestClient(t *testing.T, options *Options) (*Client, *recordSender, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	sender := &recordSender{}
+	return &Client{
+		cacheClient: cache.NewClient(&cache.Options{Addr: mr.Addr()}),
+		sender:      sender,
+		options:     options,
+	}, sender, mr
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// verify 在发送验证码之后执行，返回最终校验的结果
+		verify  func(t *testing.T, c *Client, code string, mr *miniredis.Miniredis) error
+		wantErr error
+	}{
+		{
+			name: "valid code",
+			verify: func(t *testing.T, c *Client, code string, _ *miniredis.Miniredis) error {
+				return c.Verify(ctx, PurposeRegister, testTelephone, code)
+			},
+		},
+		{
+			name: "code used once",
+			verify: func(t *testing.T, c *Client, code string, _ *miniredis.Miniredis) error {
+				if err := c.Verify(ctx, PurposeRegister, testTelephone, code); err != nil {
+					t.Fatalf("first Verify error: %v", err)
+				}
+				return c.Verify(ctx, PurposeRegister, testTelephone, code)
+			},
+			wantErr: ErrInvalidCode,
+		},
+		{
+			name: "wrong code",
+			verify: func(t *testing.T, c *Client, code string, _ *miniredis.Miniredis) error {
+				return c.Verify(ctx, PurposeRegister, testTelephone, wrongCode(code))
+			},
+			wantErr: ErrInvalidCode,
+		},
+		{
+			name: "other purpose",
+			verify: func(t *testing.T, c *Client, code string, _ *miniredis.Miniredis) error {
+				return c.Verify(ctx, PurposeResetPassword, testTelephone, code)
+			},
+			wantErr: ErrInvalidCode,
+		},
+		{
+			name: "other telephone",
+			verify: func(t *testing.T, c *Client, code string, _ *miniredis.Miniredis) error {
+				return c.Verify(ctx, PurposeRegister, "13800000002", code)
+			},
+			wantErr: ErrInvalidCode,
+		},
+		{
+			name: "expired",
+			verify: func(t *testing.T, c *Client, code string, mr *miniredis.Miniredis) error {
+				mr.FastForward(5 * time.Minute)
+				return c.Verify(ctx, PurposeRegister, testTelephone, code)
+			},
+			wantErr: ErrInvalidCode,
+		},
+		{
+			name: "right code within attempts",
+			verify: func(t *testing.T, c *Client, code string, _ *miniredis.Miniredis) error {
+				for i := 0; i < 2; i++ {
+					_ = c.Verify(ctx, PurposeRegister, testTelephone, wrongCode(code))
+				}
+				return c.Verify(ctx, PurposeRegister, testTelephone, code)
+			},
+		},
+		{
+			name: "too many attempts",
+			verify: func(t *testing.T, c *Client, code string, _ *miniredis.Miniredis) error {
+				for i := 0; i < 3; i++ {
+					if err := c.Verify(ctx, PurposeRegister, testTelephone, wrongCode(code)); !errors.Is(err, ErrInvalidCode) {
+						t.Fatalf("Verify #%d error = %v, want %v", i, err, ErrInvalidCode)
+					}
+				}
+				return c.Verify(ctx, PurposeRegister, testTelephone, code)
+			},
+			wantErr: ErrTooManyAttempts,
+		},
+		{
+			name: "code discarded after too many attempts",
+			verify: func(t *testing.T, c *Client, code string, _ *miniredis.Miniredis) error {
+				for i := 0; i < 4; i++ {
+					_ = c.Verify(ctx, PurposeRegister, testTelephone, wrongCode(code))
+				}
+				return c.Verify(ctx, PurposeRegister, testTelephone, code)
+			},
+			wantErr: ErrInvalidCode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, sender, mr := newTestClient(t, &Options{MaxAttempts: 3})
+			if err := c.Send(ctx, PurposeRegister, testTelephone); err != nil {
+				t.Fatalf("Send error: %v", err)
+			}
+
+			if err := tt.verify(t, c, sender.lastCode(t), mr); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResendResetsAttempts(t *testing.T) {
+	ctx := context.Background()
+	c, sender, mr := newTestClient(t, &Options{MaxAttempts: 3})
+
+	if err := c.Send(ctx, PurposeRegister, testTelephone); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		_ = c.Verify(ctx, PurposeRegister, testTelephone, wrongCode(sender.lastCode(t)))
+	}
+
+	mr.FastForward(time.Minute)
+	if err := c.Send(ctx, PurposeRegister, testTelephone); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if err := c.Verify(ctx, PurposeRegister, testTelephone, sender.lastCode(t)); err != nil {
+		t.Errorf("Verify new code error: %v", err)
+	}
+}
+
+func TestSendThrottle(t *testing.T) {
+	ctx := context.Background()
+	c, sender, mr := newTestClient(t, &Options{})
+
+	if err := c.Send(ctx, PurposeRegister, testTelephone); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if err := c.Send(ctx, PurposeRegister, testTelephone); !errors.Is(err, ErrTooFrequent) {
+		t.Fatalf("second Send error = %v, want %v", err, ErrTooFrequent)
+	}
+	// 不同用途分别限制
+	if err := c.Send(ctx, PurposeResetPassword, testTelephone); err != nil {
+		t.Fatalf("Send other purpose error: %v", err)
+	}
+
+	mr.FastForward(time.Minute)
+	if err := c.Send(ctx, PurposeRegister, testTelephone); err != nil {
+		t.Fatalf("Send after interval error: %v", err)
+	}
+
+	// 发送失败时允许立即重试
+	sender.err = errors.New("sms unavailable")
+	mr.FastForward(time.Minute)
+	if err := c.Send(ctx, PurposeRegister, testTelephone); err == nil {
+		t.Fatal("Send error = nil, want error")
+	}
+	sender.err = nil
+	if err := c.Send(ctx, PurposeRegister, testTelephone); err != nil {
+		t.Errorf("Send after failure error: %v", err)
+	}
+}
+
+func TestParsePurpose(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Purpose
+		wantErr bool
+	}{
+		{value: "register", want: PurposeRegister},
+		{value: "reset_password", want: PurposeResetPassword},
+		{value: "change_telephone", want: PurposeChangeTelephone},
+		{value: "", wantErr: true},
+		{value: "login", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParsePurpose(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParsePurpose(%q) = %q, %v, want %q, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// wrongCode 返回与 code 不同的同长度验证码
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
@@ -0,0 +1,70 @@
+package verification
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"smile.expression/destiny/pkg/logger"
+)
+
+const (
+	SenderConsole = "console"
+)
+
+// SMSSender 短信发送接口，接入短信服务商时新增一个实现即可
+type SMSSender interface {
+	Send(ctx context.Context, telephone string, message string) error
+}
+
+type SenderOptions struct {
+	Driver string `json:"driver"` // 目前只有 console，必须显式配置
+	File   string `json:"file"`   // console 同时把短信追加写入的文件，为空时只写日志
+}
+
+func newSender(options *SenderOptions) (SMSSender, error) {
+	if options == nil {
+		options = &SenderOptions{}
+	}
+
+	// console 会把验证码明文写进日志，不能因为漏配而在生产环境启用
+	switch options.Driver {
+	case "":
+		return nil, fmt.Errorf("sms driver is not configured")
+	case SenderConsole:
+		return &consoleSender{file: options.File}, nil
+	default:
+		return nil, fmt.Errorf("unknown sms driver: %s", options.Driver)
+	}
+}
+
+// consoleSender 开发和测试用，不真正发送短信，只写到日志和文件
+type consoleSender struct {
+	file string
+	mu   sync.Mutex
+}
+
+func (s *consoleSender) Send(ctx context.Context, telephone string, message string) error {
+	var (
+		log = logger.SmileLog.WithContext(ctx)
+	)
+
+	log.Infof("sms to %s: %s", telephone, message)
+	if s.file == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), telephone, message)
+	return err
+}